@@ -27,6 +27,37 @@ const addHeader = (header, arr) => {
     return arr;
 }
 
+// Parses a cookie in the Set-Cookie format, e.g. "name=value; Domain=example.com; Path=/"
+const parseCookie = (cookie) => {
+    const parts = cookie.split(";");
+    const i = parts[0].indexOf("=");
+    const details = {
+        name: parts[0].slice(0, i).trim(),
+        value: parts[0].slice(i + 1).trim(),
+        path: "/"
+    };
+    parts.slice(1).forEach((attr) => {
+        const j = attr.indexOf("=");
+        const key = (j < 0 ? attr : attr.slice(0, j)).trim().toLowerCase();
+        const value = j < 0 ? "" : attr.slice(j + 1).trim();
+        if (key === "domain") {
+            details.domain = value;
+        } else if (key === "path") {
+            details.path = value;
+        } else if (key === "secure") {
+            details.secure = true;
+        } else if (key === "httponly") {
+            details.httpOnly = true;
+        }
+    });
+    return details;
+};
+
+const addCookie = (cookie, arr) => {
+    arr.push(parseCookie(cookie));
+    return arr;
+}
+
 athena
     .version("2.11.0")
     .description("convert HTML to PDF via stdin or a local / remote URI")
@@ -40,6 +71,7 @@ athena
     .option("-A, --aggressive", "aggressive mode / runs dom-distiller")
     .option("-B, --bypass", "bypasses paywalls on digital publications (experimental feature)")
     .option("-H, --http-header <key:value>", "add custom headers to request", addHeader, [])
+    .option("--cookie <cookie>", "set a cookie before loading a remote URI, only sent to its domain (e.g. 'name=value; Domain=example.com; Path=/')", addCookie, [])
     .option("--proxy <url>", "use proxy to load remote HTML")
     .option("--no-portrait", "render in landscape")
     .option("--no-background", "omit CSS backgrounds")
@@ -96,6 +128,8 @@ if (athena.proxy) {
         console.info("Using proxy: ", athena.proxy);
     }
     app.commandLine.appendSwitch("proxy-server", athena.proxy);
+    // Loopback hosts are not proxied by default
+    app.commandLine.appendSwitch("proxy-bypass-list", "<-loopback>");
 }
 
 if (athena.ignoreCertificateErrors) {
@@ -149,6 +183,24 @@ const pdfOpts = {
 };
 
 // Utils
+// Sets the cookies for their domain (or the host of the URI), so that they are
+// not sent to other hosts, and calls done once they are all set
+const _setCookies = (ses, done) => {
+    let pending = athena.cookie.length;
+    if (!pending || !uriArg.toLowerCase().startsWith("http")) {
+        return done();
+    }
+    const uri = url.parse(uriArg);
+    athena.cookie.forEach((cookie) => {
+        const host = cookie.domain ? cookie.domain.replace(/^\./, "") : uri.hostname;
+        cookie.url = `${uri.protocol}//${host}${cookie.path}`;
+        ses.cookies.set(cookie, (err) => {
+            if (err) console.error(err);
+            if (--pending === 0) done();
+        });
+    });
+};
+
 const _complete = () => {
     if (!athena.stdout) {
         console.timeEnd("PDF Conversion");
@@ -180,9 +232,9 @@ app.on("ready", () => {
         ses = null;
     });
 
-    bw.loadURL(uriArg, loadOpts);
-
     ses = bw.webContents.session;
+    _setCookies(ses, () => bw.loadURL(uriArg, loadOpts));
+
     if (athena.bypass) {
         const _cookieWhitelist = ["nytimes", "ft.com"];
         const _inCookieWhitelist = (url) => {
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key is an API key, and what it is allowed to do.
+type Key struct {
+	// ID is a public identifier of the key, used for signed URLs (see
+	// Verifier). Keys without an ID cannot sign URLs.
+	ID string `json:"id,omitempty"`
+	// Key is the secret sent by the client.
+	Key string `json:"key"`
+	// Tenant the key belongs to (used for scheduling, stats, and logs).
+	Tenant string `json:"tenant"`
+	// Enabled must be true for the key to be accepted. Set it to false to
+	// revoke the key.
+	Enabled bool `json:"enabled"`
+	// Routes the key can access, as 'METHOD /path' or '/path' (any method).
+	// A path also matches its sub-paths (e.g. '/jobs' matches '/jobs/123').
+	// Defaults to all routes.
+	Routes []string `json:"routes,omitempty"`
+	// Quota limits how many conversions can be requested with the key.
+	Quota Quota `json:"quota"`
+}
+
+// Quota limits the number of conversions of a key. A zero value means no
+// limit.
+type Quota struct {
+	// RequestsPerSecond is the rate at which the conversions are allowed.
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+	// Burst is the number of conversions allowed at once above the rate.
+	Burst int `json:"burst,omitempty"`
+	// Daily is the number of conversions allowed per day (UTC).
+	Daily int `json:"daily,omitempty"`
+	// Monthly is the number of conversions allowed per month (UTC).
+	Monthly int `json:"monthly,omitempty"`
+}
+
+// Allowed returns true if the key can access a route.
+func (k Key) Allowed(method, path string) bool {
+	if len(k.Routes) == 0 {
+		return true
+	}
+	for _, r := range k.Routes {
+		p := r
+		if i := strings.IndexByte(r, ' '); i >= 0 {
+			if !strings.EqualFold(r[:i], method) {
+				continue
+			}
+			p = strings.TrimSpace(r[i+1:])
+		}
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// keyFile is the format of a key file.
+type keyFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// KeyStore holds the API keys. It is safe for concurrent use.
+// A KeyStore loaded from a file can be reloaded (see Watch) so that keys can
+// be added, rotated, or revoked without restarting the microservice.
+type KeyStore struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    []Key
+	digests [][sha256.Size]byte
+	modTime time.Time
+}
+
+// NewKeyStore creates, and returns a KeyStore containing keys.
+func NewKeyStore(keys ...Key) *KeyStore {
+	s := &KeyStore{}
+	s.set(keys)
+	return s
+}
+
+// LoadKeyStore creates, and returns a KeyStore containing the keys of a JSON
+// file.
+func LoadKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the key file again if it has been modified since it was last
+// loaded. The current keys are kept if the file cannot be read, or is
+// invalid.
+func (s *KeyStore) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	modTime := s.modTime
+	s.mu.RUnlock()
+	if fi.ModTime().Equal(modTime) {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var f keyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+
+	s.set(f.Keys)
+	s.mu.Lock()
+	s.modTime = fi.ModTime()
+	s.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the key file at every interval until done is closed. Errors
+// are logged.
+func (s *KeyStore) Watch(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.Reload(); err != nil {
+				log.Printf("unable to reload keys from %s: %+v\n", s.path, err)
+			}
+		}
+	}
+}
+
+// Lookup returns the enabled key matching a secret. Every key is compared
+// in constant time so that the secret cannot be guessed from the response
+// time.
+func (s *KeyStore) Lookup(secret string) (Key, bool) {
+	d := sha256.Sum256([]byte(secret))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := -1
+	for i := range s.keys {
+		if subtle.ConstantTimeCompare(d[:], s.digests[i][:]) == 1 {
+			found = i
+		}
+	}
+	if found < 0 || !s.keys[found].Enabled {
+		return Key{}, false
+	}
+	return s.keys[found], true
+}
+
+// LookupID returns the enabled key with an ID.
+func (s *KeyStore) LookupID(id string) (Key, bool) {
+	if id == "" {
+		return Key{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.ID == id {
+			return k, k.Enabled
+		}
+	}
+	return Key{}, false
+}
+
+// Len returns the number of keys (including disabled ones).
+func (s *KeyStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys)
+}
+
+func (s *KeyStore) set(keys []Key) {
+	var (
+		valid   []Key
+		digests [][sha256.Size]byte
+	)
+	for _, k := range keys {
+		// An empty key would match a request without a key
+		if k.Key == "" {
+			continue
+		}
+		valid = append(valid, k)
+		digests = append(digests, sha256.Sum256([]byte(k.Key)))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = valid
+	s.digests = digests
+}
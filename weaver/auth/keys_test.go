@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyAllowed(t *testing.T) {
+	k := Key{Routes: []string{"GET /convert", "/jobs"}}
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{"GET", "/convert", true},
+		{"POST", "/convert", false},
+		{"POST", "/jobs", true},
+		{"GET", "/jobs/123/result", true},
+		{"GET", "/jobsx", false},
+		{"POST", "/v2/convert", false},
+	}
+	for _, tt := range tests {
+		if got := k.Allowed(tt.method, tt.path); got != tt.want {
+			t.Errorf("expected %s %s to be allowed: %t, got %t", tt.method, tt.path, tt.want, got)
+		}
+	}
+
+	if !(Key{}).Allowed("POST", "/v2/convert") {
+		t.Errorf("expected a key without routes to be allowed on any route")
+	}
+}
+
+func TestKeyStoreLookup(t *testing.T) {
+	s := NewKeyStore(
+		Key{Key: "abc", Tenant: "a", Enabled: true},
+		Key{Key: "def", Tenant: "b", Enabled: false},
+		Key{Key: "", Tenant: "c", Enabled: true},
+	)
+
+	k, ok := s.Lookup("abc")
+	if !ok {
+		t.Fatalf("expected key to be found")
+	}
+	if got, want := k.Tenant, "a"; got != want {
+		t.Errorf("expected tenant to be %s, got %s", want, got)
+	}
+	if _, ok := s.Lookup("def"); ok {
+		t.Errorf("expected a disabled key to be rejected")
+	}
+	if _, ok := s.Lookup(""); ok {
+		t.Errorf("expected an empty key to be rejected")
+	}
+	if _, ok := s.Lookup("ab"); ok {
+		t.Errorf("expected an unknown key to be rejected")
+	}
+}
+
+func TestLoadKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weaver-keys")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	write := func(content string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("unable to write key file: %+v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("unable to change key file times: %+v", err)
+		}
+	}
+
+	now := time.Now()
+	write(`{"keys": [{"key": "abc", "tenant": "a", "enabled": true, "quota": {"daily": 10}}]}`, now.Add(-time.Minute))
+	s, err := LoadKeyStore(path)
+	if err != nil {
+		t.Fatalf("LoadKeyStore returned an unexpected error: %+v", err)
+	}
+	k, ok := s.Lookup("abc")
+	if !ok {
+		t.Fatalf("expected key to be found")
+	}
+	if got, want := k.Quota.Daily, 10; got != want {
+		t.Errorf("expected daily quota to be %d, got %d", want, got)
+	}
+
+	// Rotate the key
+	write(`{"keys": [{"key": "xyz", "tenant": "a", "enabled": true}]}`, now)
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload returned an unexpected error: %+v", err)
+	}
+	if _, ok := s.Lookup("abc"); ok {
+		t.Errorf("expected the old key to be rejected after reload")
+	}
+	if _, ok := s.Lookup("xyz"); !ok {
+		t.Errorf("expected the new key to be found after reload")
+	}
+
+	// An invalid file keeps the current keys
+	write(`{"keys": [`, now.Add(time.Minute))
+	if err := s.Reload(); err == nil {
+		t.Errorf("expected Reload to return an error for an invalid file")
+	}
+	if _, ok := s.Lookup("xyz"); !ok {
+		t.Errorf("expected the current keys to be kept")
+	}
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// usageBucket is the BoltDB bucket of the usage of the keys.
+var usageBucket = []byte("usage")
+
+var (
+	// ErrRateLimited is returned when a key has exceeded its rate limit.
+	ErrRateLimited = errors.New("rate limit exceeded, try again later")
+	// ErrDailyQuota is returned when a key has used its daily quota.
+	ErrDailyQuota = errors.New("daily conversion quota exceeded")
+	// ErrMonthlyQuota is returned when a key has used its monthly quota.
+	ErrMonthlyQuota = errors.New("monthly conversion quota exceeded")
+)
+
+// Decision is the outcome of a request to a Limiter.
+type Decision struct {
+	// Err is ErrRateLimited, ErrDailyQuota, or ErrMonthlyQuota if the request
+	// has been rejected.
+	Err error
+	// Limit, Remaining, and Reset describe the most restrictive limit of the
+	// key (or the one which rejected the request). Limit is 0 if the key has
+	// no limits.
+	Limit     int
+	Remaining int
+	// Reset is when the limit will be replenished.
+	Reset time.Time
+}
+
+// Limiter enforces the quota of every key: a token bucket for the rate
+// limit, and counters of the conversions of the current day, and month (UTC).
+// It is safe for concurrent use. The usage is held in memory, and the
+// counters can be persisted (see OpenLimiter). Either way, the usage is per
+// process: it is not shared between instances.
+type Limiter struct {
+	mu    sync.Mutex
+	usage map[string]*usage
+	now   func() time.Time
+
+	db *bolt.DB
+	// dirty are the IDs of the usage changed since the last flush.
+	dirty map[string]bool
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// usage of a key. Only the counters are persisted (the token bucket is full
+// after a restart).
+type usage struct {
+	tokens float64
+	last   time.Time
+
+	Day     string `json:"day"`
+	Daily   int    `json:"daily"`
+	Month   string `json:"month"`
+	Monthly int    `json:"monthly"`
+}
+
+// NewLimiter creates, and returns a new Limiter.
+func NewLimiter() *Limiter {
+	return &Limiter{usage: make(map[string]*usage), dirty: make(map[string]bool), now: time.Now}
+}
+
+// OpenLimiter creates, and returns a new Limiter whose counters are persisted
+// in a BoltDB file (it is created if it does not exist), so that the daily,
+// and monthly quotas survive restarts. The counters are written every
+// interval (outside of the requests), and on Close, so the last interval may
+// be lost if the process crashes.
+func OpenLimiter(path string, interval time.Duration) (*Limiter, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	l := NewLimiter()
+	l.db = db
+	month := l.now().UTC().Format("2006-01")
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(usageBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			u := new(usage)
+			if err := json.Unmarshal(v, u); err != nil {
+				return err
+			}
+			// The counters of past months have expired
+			if u.Month != month {
+				return b.Delete(k)
+			}
+			l.usage[string(k)] = u
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	l.stop = make(chan struct{})
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := l.flush(); err != nil {
+					log.Println(err)
+				}
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+	return l, nil
+}
+
+// flush writes the changed counters to the BoltDB file.
+func (l *Limiter) flush() error {
+	l.mu.Lock()
+	records := make(map[string][]byte, len(l.dirty))
+	for id := range l.dirty {
+		b, err := json.Marshal(l.usage[id])
+		if err != nil {
+			l.mu.Unlock()
+			return err
+		}
+		records[id] = b
+	}
+	l.dirty = make(map[string]bool)
+	l.mu.Unlock()
+
+	if len(records) == 0 {
+		return nil
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usageBucket)
+		for id, v := range records {
+			if err := b.Put([]byte(id), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close writes the counters, and closes the BoltDB file of a persisted
+// Limiter.
+func (l *Limiter) Close() error {
+	if l.db == nil {
+		return nil
+	}
+	close(l.stop)
+	l.wg.Wait()
+	err := l.flush()
+	if cerr := l.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// usageID returns the ID of the usage of a key: its ID, so that the usage is
+// kept when its secret is rotated. The secret of a key without an ID is
+// hashed, so that it is not written to disk.
+func usageID(k Key) string {
+	if k.ID != "" {
+		return "id:" + k.ID
+	}
+	h := sha256.Sum256([]byte(k.Key))
+	return hex.EncodeToString(h[:])
+}
+
+// burst returns the capacity of the token bucket of a quota.
+func (q Quota) burst() float64 {
+	if q.Burst > 0 {
+		return float64(q.Burst)
+	}
+	return math.Max(1, math.Ceil(q.RequestsPerSecond))
+}
+
+// Allow counts a request against the quota of a key, unless it exceeds one
+// of its limits.
+func (l *Limiter) Allow(k Key) Decision {
+	q := k.Quota
+	if q == (Quota{}) {
+		return Decision{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now().UTC()
+	id := usageID(k)
+	u, ok := l.usage[id]
+	if !ok {
+		u = &usage{}
+		l.usage[id] = u
+	}
+
+	// Refill the bucket (it is full if it has never been used), and start new
+	// periods
+	if u.last.IsZero() {
+		u.tokens = q.burst()
+	} else if q.RequestsPerSecond > 0 {
+		u.tokens = math.Min(q.burst(), u.tokens+now.Sub(u.last).Seconds()*q.RequestsPerSecond)
+	}
+	u.last = now
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day, u.Daily = day, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.Monthly = month, 0
+	}
+
+	nextDay := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+
+	switch {
+	case q.RequestsPerSecond > 0 && u.tokens < 1:
+		wait := time.Duration((1 - u.tokens) / q.RequestsPerSecond * float64(time.Second))
+		return Decision{Err: ErrRateLimited, Limit: int(q.burst()), Reset: now.Add(wait)}
+	case q.Daily > 0 && u.Daily >= q.Daily:
+		return Decision{Err: ErrDailyQuota, Limit: q.Daily, Reset: nextDay}
+	case q.Monthly > 0 && u.Monthly >= q.Monthly:
+		return Decision{Err: ErrMonthlyQuota, Limit: q.Monthly, Reset: nextMonth}
+	}
+
+	var limits []Decision
+	if q.RequestsPerSecond > 0 {
+		u.tokens--
+		full := time.Duration((q.burst() - u.tokens) / q.RequestsPerSecond * float64(time.Second))
+		limits = append(limits, Decision{Limit: int(q.burst()), Remaining: int(u.tokens), Reset: now.Add(full)})
+	}
+	u.Daily++
+	if q.Daily > 0 {
+		limits = append(limits, Decision{Limit: q.Daily, Remaining: q.Daily - u.Daily, Reset: nextDay})
+	}
+	u.Monthly++
+	if q.Monthly > 0 {
+		limits = append(limits, Decision{Limit: q.Monthly, Remaining: q.Monthly - u.Monthly, Reset: nextMonth})
+	}
+	l.dirty[id] = true
+
+	// e.g. a burst without a rate
+	if len(limits) == 0 {
+		return Decision{}
+	}
+	d := limits[0]
+	for _, v := range limits[1:] {
+		if v.Remaining < d.Remaining {
+			d = v
+		}
+	}
+	return d
+}
+
+// Refund gives back a conversion counted by Allow against the daily, and
+// monthly quotas of a key, e.g. if it has been rejected before it was run.
+// The rate limit is not refunded.
+func (l *Limiter) Refund(k Key) {
+	if k.Quota == (Quota{}) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := usageID(k)
+	u, ok := l.usage[id]
+	if !ok {
+		return
+	}
+	// The conversion was counted in a period which has ended
+	now := l.now().UTC()
+	if u.Day == now.Format("2006-01-02") && u.Daily > 0 {
+		u.Daily--
+	}
+	if u.Month == now.Format("2006-01") && u.Monthly > 0 {
+		u.Monthly--
+	}
+	l.dirty[id] = true
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mockLimiter(now *time.Time) *Limiter {
+	l := NewLimiter()
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestLimiterAllow_rate(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	l := mockLimiter(&now)
+	k := Key{Key: "abc", Quota: Quota{RequestsPerSecond: 1, Burst: 2}}
+
+	for i := 0; i < 2; i++ {
+		if d := l.Allow(k); d.Err != nil {
+			t.Fatalf("expected request #%d to be allowed, got %+v", i, d.Err)
+		}
+	}
+	d := l.Allow(k)
+	if d.Err != ErrRateLimited {
+		t.Fatalf("expected error to be %+v, got %+v", ErrRateLimited, d.Err)
+	}
+	if got, want := d.Reset, now.Add(time.Second); !got.Equal(want) {
+		t.Errorf("expected reset to be %s, got %s", want, got)
+	}
+
+	now = now.Add(time.Second)
+	d = l.Allow(k)
+	if d.Err != nil {
+		t.Fatalf("expected request to be allowed after refill, got %+v", d.Err)
+	}
+	if d.Limit != 2 || d.Remaining != 0 {
+		t.Errorf("expected limit 2, and 0 remaining, got %+v", d)
+	}
+}
+
+func TestLimiterAllow_quotas(t *testing.T) {
+	now := time.Date(2018, 5, 31, 23, 0, 0, 0, time.UTC)
+	l := mockLimiter(&now)
+	k := Key{Key: "abc", Quota: Quota{Daily: 2, Monthly: 3}}
+
+	d := l.Allow(k)
+	if d.Err != nil {
+		t.Fatalf("expected request to be allowed, got %+v", d.Err)
+	}
+	// The daily quota is the most restrictive
+	if d.Limit != 2 || d.Remaining != 1 {
+		t.Errorf("expected limit 2, and 1 remaining, got %+v", d)
+	}
+	l.Allow(k)
+	d = l.Allow(k)
+	if d.Err != ErrDailyQuota {
+		t.Fatalf("expected error to be %+v, got %+v", ErrDailyQuota, d.Err)
+	}
+	if want := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC); !d.Reset.Equal(want) {
+		t.Errorf("expected reset to be %s, got %s", want, d.Reset)
+	}
+
+	k2 := Key{Key: "def", Quota: Quota{Daily: 10, Monthly: 1}}
+	l.Allow(k2)
+	if d := l.Allow(k2); d.Err != ErrMonthlyQuota {
+		t.Errorf("expected error to be %+v, got %+v", ErrMonthlyQuota, d.Err)
+	}
+
+	// Quotas are reset in a new month
+	now = time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	if d := l.Allow(k); d.Err != nil {
+		t.Errorf("expected request to be allowed in a new month, got %+v", d.Err)
+	}
+}
+
+func TestLimiterAllow_rotation(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	l := mockLimiter(&now)
+	k := Key{ID: "web", Key: "abc", Quota: Quota{Daily: 1}}
+	l.Allow(k)
+
+	// The usage is kept when the secret of the key is rotated
+	k.Key = "def"
+	if d := l.Allow(k); d.Err != ErrDailyQuota {
+		t.Errorf("expected error to be %+v, got %+v", ErrDailyQuota, d.Err)
+	}
+}
+
+func TestLimiterRefund(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	l := mockLimiter(&now)
+	k := Key{Key: "abc", Quota: Quota{Daily: 1, Monthly: 1}}
+
+	l.Allow(k)
+	l.Refund(k)
+	if d := l.Allow(k); d.Err != nil {
+		t.Fatalf("expected refunded request to be allowed, got %+v", d.Err)
+	}
+	if d := l.Allow(k); d.Err != ErrDailyQuota {
+		t.Errorf("expected error to be %+v, got %+v", ErrDailyQuota, d.Err)
+	}
+
+	// A refund does not go below zero
+	l.Refund(k)
+	l.Refund(k)
+	if d := l.Allow(k); d.Remaining != 0 {
+		t.Errorf("expected 0 remaining, got %+v", d)
+	}
+}
+
+func TestLimiterAllow_noQuota(t *testing.T) {
+	l := NewLimiter()
+	for i := 0; i < 100; i++ {
+		if d := l.Allow(Key{Key: "abc"}); d.Err != nil || d.Limit != 0 {
+			t.Fatalf("expected a key without quota to be unlimited, got %+v", d)
+		}
+	}
+}
+
+func TestOpenLimiter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "limiter")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory for testing: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "quota.db")
+	k := Key{Key: "abc", Quota: Quota{Monthly: 2}}
+
+	l, err := OpenLimiter(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenLimiter returned an unexpected error: %+v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if d := l.Allow(k); d.Err != nil {
+			t.Fatalf("expected request #%d to be allowed, got %+v", i, d.Err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close returned an unexpected error: %+v", err)
+	}
+
+	// The usage is restored after a restart
+	l, err = OpenLimiter(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenLimiter returned an unexpected error: %+v", err)
+	}
+	defer l.Close()
+	if d := l.Allow(k); d.Err != ErrMonthlyQuota {
+		t.Errorf("expected error to be %+v, got %+v", ErrMonthlyQuota, d.Err)
+	}
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters of a signed URL.
+const (
+	// KeyIDParam is the ID of the key used for signing (HMAC).
+	KeyIDParam = "key_id"
+	// ExpiresParam is the Unix time after which the URL is rejected (HMAC).
+	ExpiresParam = "expires"
+	// SignatureParam is the hex encoded HMAC-SHA256 of the URL (see Sign).
+	SignatureParam = "signature"
+	// TokenParam is a JWT signed with the private key of the verifier. It is
+	// not named 'token' as /convert already uses it (see need_login).
+	TokenParam = "jwt"
+)
+
+var (
+	// ErrNoSignature is returned when a URL is not signed.
+	ErrNoSignature = errors.New("URL is not signed")
+	// ErrSignatureInvalid is returned when the signature of a URL does not
+	// match, or its key is unknown.
+	ErrSignatureInvalid = errors.New("invalid URL signature provided")
+	// ErrSignatureExpired is returned when a signed URL has expired.
+	ErrSignatureExpired = errors.New("signed URL has expired")
+)
+
+// CanonicalRequest returns the string covered by the signature of a URL: the
+// method, the path, and every query parameter (sorted), except the signature
+// itself (SignatureParam, or TokenParam).
+func CanonicalRequest(method, path string, q url.Values) string {
+	v := make(url.Values, len(q))
+	for k, vs := range q {
+		if k == SignatureParam || k == TokenParam {
+			continue
+		}
+		v[k] = vs
+	}
+	return strings.ToUpper(method) + "\n" + path + "\n" + v.Encode()
+}
+
+// Sign returns the signature of a URL for the SignatureParam. The query must
+// contain the KeyIDParam, and the ExpiresParam.
+func Sign(secret, method, path string, q url.Values) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(CanonicalRequest(method, path, q)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParsePublicKey parses a PEM encoded RSA, or ECDSA (P-256) public key.
+func ParsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("unsupported ECDSA curve (only P-256 is supported)")
+		}
+	default:
+		return nil, errors.New("unsupported public key type")
+	}
+	return pub, nil
+}
+
+// claims are the claims of a JWT used for signing a URL.
+type claims struct {
+	// Sub is the ID of the key the URL is issued for.
+	Sub string `json:"sub"`
+	Exp int64  `json:"exp"`
+	// Req is the hex encoded SHA-256 of the CanonicalRequest.
+	Req string `json:"req"`
+}
+
+// Verifier verifies signed URLs, so that clients can be given a URL which
+// runs a single conversion without ever seeing an API key. A URL is either
+// signed with the secret of a key (HMAC-SHA256), or with a JWT verified with
+// a public key (RS256, or ES256). Either way, the signature covers the whole
+// URL so that it cannot be reused for another request.
+type Verifier struct {
+	keys      *KeyStore
+	publicKey crypto.PublicKey
+	now       func() time.Time
+}
+
+// NewVerifier creates, and returns a Verifier for the keys in a key store.
+// JWTs are rejected if the public key is nil.
+func NewVerifier(ks *KeyStore, pub crypto.PublicKey) *Verifier {
+	return &Verifier{keys: ks, publicKey: pub, now: time.Now}
+}
+
+// Verify returns the key a signed URL has been issued for. It returns
+// ErrNoSignature if the URL is not signed.
+func (v *Verifier) Verify(method, path string, q url.Values) (Key, error) {
+	switch {
+	case q.Get(SignatureParam) != "":
+		return v.verifySignature(method, path, q)
+	case q.Get(TokenParam) != "":
+		return v.verifyToken(method, path, q)
+	}
+	return Key{}, ErrNoSignature
+}
+
+func (v *Verifier) verifySignature(method, path string, q url.Values) (Key, error) {
+	expires, err := strconv.ParseInt(q.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return Key{}, ErrSignatureInvalid
+	}
+	k, ok := v.keys.LookupID(q.Get(KeyIDParam))
+	if !ok {
+		return Key{}, ErrSignatureInvalid
+	}
+	if !hmac.Equal([]byte(q.Get(SignatureParam)), []byte(Sign(k.Key, method, path, q))) {
+		return Key{}, ErrSignatureInvalid
+	}
+	if v.now().Unix() > expires {
+		return Key{}, ErrSignatureExpired
+	}
+	return k, nil
+}
+
+func (v *Verifier) verifyToken(method, path string, q url.Values) (Key, error) {
+	c, err := v.parseToken(q.Get(TokenParam))
+	if err != nil {
+		return Key{}, ErrSignatureInvalid
+	}
+	req := sha256.Sum256([]byte(CanonicalRequest(method, path, q)))
+	if !hmac.Equal([]byte(c.Req), []byte(hex.EncodeToString(req[:]))) {
+		return Key{}, ErrSignatureInvalid
+	}
+	k, ok := v.keys.LookupID(c.Sub)
+	if !ok {
+		return Key{}, ErrSignatureInvalid
+	}
+	if c.Exp == 0 || v.now().Unix() > c.Exp {
+		return Key{}, ErrSignatureExpired
+	}
+	return k, nil
+}
+
+// parseToken verifies the signature of a JWT, and returns its claims. The
+// algorithm must match the type of the public key (so that the public key
+// can never be used as an HMAC secret).
+func (v *Verifier) parseToken(token string) (claims, error) {
+	var c claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return c, ErrSignatureInvalid
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return c, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return c, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := v.publicKey.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return c, ErrSignatureInvalid
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return c, err
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 {
+			return c, ErrSignatureInvalid
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return c, ErrSignatureInvalid
+		}
+	default:
+		return c, ErrSignatureInvalid
+	}
+
+	err = decodeSegment(parts[1], &c)
+	return c, err
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a JWT.
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
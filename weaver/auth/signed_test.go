@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func mockVerifier(pub interface{}, now time.Time) *Verifier {
+	ks := NewKeyStore(
+		Key{ID: "web", Key: "abc", Tenant: "acme", Enabled: true},
+		Key{ID: "old", Key: "def", Tenant: "acme", Enabled: false},
+	)
+	v := NewVerifier(ks, pub)
+	v.now = func() time.Time { return now }
+	return v
+}
+
+func mockSignedQuery(id, secret string, expires time.Time) url.Values {
+	q := url.Values{}
+	q.Set("url", "http://www.example.com")
+	q.Set("page_size", "A4")
+	q.Set(KeyIDParam, id)
+	q.Set(ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	q.Set(SignatureParam, Sign(secret, "GET", "/convert", q))
+	return q
+}
+
+func TestVerifierVerify_signature(t *testing.T) {
+	now := time.Now()
+	v := mockVerifier(nil, now)
+
+	q := mockSignedQuery("web", "abc", now.Add(time.Minute))
+	k, err := v.Verify("GET", "/convert", q)
+	if err != nil {
+		t.Fatalf("Verify returned an unexpected error: %+v", err)
+	}
+	if got, want := k.Tenant, "acme"; got != want {
+		t.Errorf("expected tenant to be %s, got %s", want, got)
+	}
+
+	// The signature cannot be reused for another target
+	q.Set("url", "http://www.evil.com")
+	if _, err := v.Verify("GET", "/convert", q); err != ErrSignatureInvalid {
+		t.Errorf("expected error to be %+v, got %+v", ErrSignatureInvalid, err)
+	}
+
+	q = mockSignedQuery("web", "abc", now.Add(-time.Minute))
+	if _, err := v.Verify("GET", "/convert", q); err != ErrSignatureExpired {
+		t.Errorf("expected error to be %+v, got %+v", ErrSignatureExpired, err)
+	}
+
+	// The session token of /convert is covered by the signature
+	q = mockSignedQuery("web", "abc", now.Add(time.Minute))
+	q.Set("token", "session")
+	if _, err := v.Verify("GET", "/convert", q); err != ErrSignatureInvalid {
+		t.Errorf("expected error to be %+v, got %+v", ErrSignatureInvalid, err)
+	}
+
+	q = mockSignedQuery("old", "def", now.Add(time.Minute))
+	if _, err := v.Verify("GET", "/convert", q); err != ErrSignatureInvalid {
+		t.Errorf("expected a revoked key to be rejected, got %+v", err)
+	}
+
+	if _, err := v.Verify("GET", "/convert", url.Values{}); err != ErrNoSignature {
+		t.Errorf("expected error to be %+v, got %+v", ErrNoSignature, err)
+	}
+}
+
+func mockToken(t *testing.T, pk *ecdsa.PrivateKey, alg string, c claims) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(c)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, pk, digest[:])
+	if err != nil {
+		t.Fatalf("unable to sign token: %+v", err)
+	}
+	sig := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(sig[32-len(rb):32], rb)
+	copy(sig[64-len(sb):], sb)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifierVerify_token(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %+v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&pk.PublicKey)
+	if err != nil {
+		t.Fatalf("unable to marshal public key: %+v", err)
+	}
+	pub, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParsePublicKey returned an unexpected error: %+v", err)
+	}
+	now := time.Now()
+	v := mockVerifier(pub, now)
+
+	q := url.Values{}
+	q.Set("url", "http://www.example.com")
+	q.Set("token", "session")
+	req := sha256.Sum256([]byte(CanonicalRequest("GET", "/convert", q)))
+	c := claims{Sub: "web", Exp: now.Add(time.Minute).Unix(), Req: hex.EncodeToString(req[:])}
+
+	q.Set(TokenParam, mockToken(t, pk, "ES256", c))
+	k, err := v.Verify("GET", "/convert", q)
+	if err != nil {
+		t.Fatalf("Verify returned an unexpected error: %+v", err)
+	}
+	if got, want := k.ID, "web"; got != want {
+		t.Errorf("expected key to be %s, got %s", want, got)
+	}
+
+	q.Set("url", "http://www.evil.com")
+	if _, err := v.Verify("GET", "/convert", q); err != ErrSignatureInvalid {
+		t.Errorf("expected error to be %+v, got %+v", ErrSignatureInvalid, err)
+	}
+
+	// The session token of /convert is covered by the JWT
+	q.Set("url", "http://www.example.com")
+	q.Set("token", "other")
+	if _, err := v.Verify("GET", "/convert", q); err != ErrSignatureInvalid {
+		t.Errorf("expected error to be %+v, got %+v", ErrSignatureInvalid, err)
+	}
+	q.Set("token", "session")
+
+	// The algorithm must match the public key
+	q.Set("url", "http://www.example.com")
+	q.Set(TokenParam, mockToken(t, pk, "HS256", c))
+	if _, err := v.Verify("GET", "/convert", q); err != ErrSignatureInvalid {
+		t.Errorf("expected error to be %+v, got %+v", ErrSignatureInvalid, err)
+	}
+
+	c.Exp = now.Add(-time.Minute).Unix()
+	q.Set(TokenParam, mockToken(t, pk, "ES256", c))
+	if _, err := v.Verify("GET", "/convert", q); err != ErrSignatureExpired {
+		t.Errorf("expected error to be %+v, got %+v", ErrSignatureExpired, err)
+	}
+}
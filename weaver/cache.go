@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/cache"
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/gin-gonic/gin"
+)
+
+// cacheable returns true if the output of a conversion request can be cached,
+// i.e. it is returned to the client (instead of being uploaded to a storage
+// backend, or sent to a callback).
+func cacheable(req conversionRequest) bool {
+	return req.destination.Storage == nil && req.callbackURL == ""
+}
+
+// cacheKey returns the key of the output of a conversion request in the
+// result cache. It is a hash of the tenant, the source (its URL, or its
+// content if it is local), the normalised options, and the headers, and
+// cookies sent when fetching the source, so that equivalent requests share the same key.
+func cacheKey(req conversionRequest) (string, error) {
+	h := sha256.New()
+
+	var headers []string
+	for name, values := range req.source.Header {
+		headers = append(headers, http.CanonicalHeaderKey(name)+": "+strings.Join(values, ", "))
+	}
+	sort.Strings(headers)
+	var cookies []string
+	for _, ck := range req.source.Cookies {
+		cookies = append(cookies, ck.String())
+	}
+	sort.Strings(cookies)
+
+	// Local sources are identified by their content (see below)
+	var uri string
+	if !req.source.IsLocal {
+		uri = req.source.URI
+	}
+
+	// JSON encoding is unambiguous (unlike joining the fields)
+	err := json.NewEncoder(h).Encode(struct {
+		Tenant     string
+		URI        string `json:",omitempty"`
+		Mime       string
+		Options    converter.ConversionOptions
+		Aggressive bool
+		Converter  string
+		Headers    []string
+		Cookies    []string
+	}{
+		Tenant:     req.class.Tenant,
+		URI:        uri,
+		Mime:       req.source.Mime,
+		Options:    req.source.Options.Normalize(),
+		Aggressive: req.aggressive,
+		Converter:  req.converter,
+		Headers:    headers,
+		Cookies:    cookies,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if req.source.IsLocal {
+		f, err := os.Open(req.source.URI)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// noCache returns true if the client has asked for a fresh conversion.
+func noCache(c *gin.Context) bool {
+	return strings.Contains(c.Request.Header.Get("Cache-Control"), "no-cache") ||
+		c.Request.Header.Get("Pragma") == "no-cache"
+}
+
+// etagMatch returns true if an If-None-Match header matches an ETag.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveEntry responds with the output of a cached conversion, or with 304 Not
+// Modified if the client already has it (If-None-Match).
+func serveEntry(c *gin.Context, e cache.Entry) {
+	maxAge := int(time.Until(e.Expires).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	c.Header("ETag", e.ETag)
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+
+	if inm := c.Request.Header.Get("If-None-Match"); inm != "" && etagMatch(inm, e.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/pdf", e.Data)
+}
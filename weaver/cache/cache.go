@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Entry is the cached output of a conversion.
+type Entry struct {
+	Data []byte
+	// ETag identifies the content of Data (see NewEntry).
+	ETag string
+	// Expires is the time after which the entry is no longer returned.
+	Expires time.Time
+}
+
+// NewEntry creates, and returns an Entry for the data which expires after
+// ttl.
+func NewEntry(b []byte, ttl time.Duration) Entry {
+	return Entry{Data: b, ETag: ETag(b), Expires: time.Now().Add(ttl)}
+}
+
+// ETag returns a strong entity tag of the data (quoted).
+func ETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Cache is a backend for storing the output of conversions. Implementations
+// must be safe for concurrent use, and must not return expired entries.
+type Cache interface {
+	// Get returns the entry stored under a key.
+	Get(key string) (Entry, bool)
+	// Put stores an entry under a key, replacing any existing entry.
+	Put(key string, e Entry) error
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestETag(t *testing.T) {
+	if ETag([]byte("a")) == ETag([]byte("b")) {
+		t.Errorf("expected different data to have different ETags")
+	}
+	if got, want := ETag([]byte("a")), ETag([]byte("a")); got != want {
+		t.Errorf("expected ETag to be %s, got %s", want, got)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(10)
+	m.Put("a", NewEntry([]byte("12345"), time.Minute))
+	m.Put("b", NewEntry([]byte("12345"), time.Minute))
+	// a is now the most recently used
+	if _, ok := m.Get("a"); !ok {
+		t.Fatalf("expected entry a to be found")
+	}
+
+	m.Put("c", NewEntry([]byte("123"), time.Minute))
+	if _, ok := m.Get("b"); ok {
+		t.Errorf("expected the least recently used entry to be evicted")
+	}
+	e, ok := m.Get("a")
+	if !ok {
+		t.Fatalf("expected entry a to be found")
+	}
+	if !bytes.Equal(e.Data, []byte("12345")) {
+		t.Errorf("expected data to be 12345, got %s", e.Data)
+	}
+
+	m.Put("d", NewEntry([]byte("1"), -time.Second))
+	if _, ok := m.Get("d"); ok {
+		t.Errorf("expected an expired entry not to be returned")
+	}
+
+	m.Put("e", NewEntry(make([]byte, 11), time.Minute))
+	if _, ok := m.Get("e"); ok {
+		t.Errorf("expected an entry larger than the cache not to be stored")
+	}
+	if got, want := m.Len(), 2; got != want {
+		t.Errorf("expected %d entries, got %d", want, got)
+	}
+}
+
+func TestDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weaver-cache")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := NewDisk(dir)
+	if err != nil {
+		t.Fatalf("NewDisk returned an unexpected error: %+v", err)
+	}
+
+	want := NewEntry([]byte("%PDF"), time.Minute)
+	if err := d.Put("abc", want); err != nil {
+		t.Fatalf("Put returned an unexpected error: %+v", err)
+	}
+	got, ok := d.Get("abc")
+	if !ok {
+		t.Fatalf("expected entry to be found")
+	}
+	if !bytes.Equal(got.Data, want.Data) || got.ETag != want.ETag {
+		t.Errorf("expected entry to be %+v, got %+v", want, got)
+	}
+
+	if err := d.Put("../abc", want); err != ErrKeyInvalid {
+		t.Errorf("expected error to be %+v, got %+v", ErrKeyInvalid, err)
+	}
+
+	d.Put("old", NewEntry([]byte("%PDF"), -time.Second))
+	if err := d.Prune(); err != nil {
+		t.Fatalf("Prune returned an unexpected error: %+v", err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if got, want := len(files), 1; got != want {
+		t.Errorf("expected %d files after prune, got %d", want, got)
+	}
+}
+
+func TestDiskPruneEvery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weaver-cache")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := NewDisk(dir)
+	if err != nil {
+		t.Fatalf("NewDisk returned an unexpected error: %+v", err)
+	}
+	d.Put("old", NewEntry([]byte("%PDF"), -time.Second))
+
+	done := make(chan struct{})
+	defer close(done)
+	go d.PruneEvery(10*time.Millisecond, done)
+
+	for i := 0; i < 100; i++ {
+		if files, _ := ioutil.ReadDir(dir); len(files) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected the expired entry to be pruned")
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrKeyInvalid is returned when a key cannot be used as a file name.
+var ErrKeyInvalid = errors.New("invalid cache key")
+
+// Disk is a cache stored in a directory (one file per entry). It can be
+// shared by several processes on the same host, and it survives restarts.
+// The modification time of a file is set to the expiry of its entry, so that
+// expired entries can be removed by Prune without reading them.
+type Disk struct {
+	Dir string
+}
+
+// NewDisk creates the directory if it does not exist, and returns a Disk
+// cache stored in it.
+func NewDisk(dir string) (Disk, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Disk{}, err
+	}
+	return Disk{Dir: dir}, nil
+}
+
+func (d Disk) path(key string) (string, error) {
+	if key == "" || filepath.Base(key) != key || key[0] == '.' {
+		return "", ErrKeyInvalid
+	}
+	return filepath.Join(d.Dir, key), nil
+}
+
+// Get returns the entry stored under a key.
+func (d Disk) Get(key string) (Entry, bool) {
+	p, err := d.path(key)
+	if err != nil {
+		return Entry{}, false
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return Entry{}, false
+	}
+	defer f.Close()
+
+	var e Entry
+	if err := gob.NewDecoder(f).Decode(&e); err != nil {
+		return Entry{}, false
+	}
+	if time.Now().After(e.Expires) {
+		os.Remove(p)
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Put stores an entry under a key. The entry is written to a temporary file
+// first so that a concurrent Get never reads a partial entry.
+func (d Disk) Put(key string, e Entry) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(d.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(e); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chtimes(f.Name(), e.Expires, e.Expires); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Prune removes the expired entries.
+func (d Disk) Prune() error {
+	files, err := ioutil.ReadDir(d.Dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, fi := range files {
+		if fi.IsDir() || fi.Name()[0] == '.' {
+			continue
+		}
+		if fi.ModTime().Before(now) {
+			os.Remove(filepath.Join(d.Dir, fi.Name()))
+		}
+	}
+	return nil
+}
+
+// PruneEvery prunes the cache at every interval until done is closed (see
+// Prune).
+func (d Disk) PruneEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := d.Prune(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Memory is a cache held in memory. The least recently used entries are
+// evicted once the total size of the entries exceeds MaxSize.
+type Memory struct {
+	maxSize int64
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type memoryItem struct {
+	key string
+	e   Entry
+}
+
+// NewMemory creates, and returns an empty Memory cache which can hold up to
+// maxSize bytes.
+func NewMemory(maxSize int64) *Memory {
+	return &Memory{
+		maxSize: maxSize,
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+// Get returns the entry stored under a key, and marks it as recently used.
+func (m *Memory) Get(key string) (Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return Entry{}, false
+	}
+	item := el.Value.(*memoryItem)
+	if time.Now().After(item.e.Expires) {
+		m.remove(el)
+		return Entry{}, false
+	}
+	m.lru.MoveToFront(el)
+	return item.e, true
+}
+
+// Put stores an entry under a key. Entries larger than the cache are not
+// stored.
+func (m *Memory) Put(key string, e Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+	if int64(len(e.Data)) > m.maxSize {
+		return nil
+	}
+
+	m.items[key] = m.lru.PushFront(&memoryItem{key: key, e: e})
+	m.size += int64(len(e.Data))
+	for m.size > m.maxSize {
+		m.remove(m.lru.Back())
+	}
+	return nil
+}
+
+// Len returns the number of entries (including expired ones).
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+// remove deletes an element. The caller must hold the lock.
+func (m *Memory) remove(el *list.Element) {
+	item := m.lru.Remove(el).(*memoryItem)
+	delete(m.items, item.key)
+	m.size -= int64(len(item.e.Data))
+}
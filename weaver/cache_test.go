@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/cache"
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCacheKey(t *testing.T) {
+	mockRequest := func(tenant string, o converter.ConversionOptions) conversionRequest {
+		return conversionRequest{
+			source: converter.ConversionSource{
+				URI:     "http://www.example.com",
+				Header:  http.Header{"Cookie": {"a=1"}},
+				Options: o,
+			},
+			class: converter.Class{Tenant: tenant},
+		}
+	}
+
+	a, err := cacheKey(mockRequest("acme", converter.ConversionOptions{}))
+	if err != nil {
+		t.Fatalf("cacheKey returned an unexpected error: %+v", err)
+	}
+	b, _ := cacheKey(mockRequest("acme", converter.ConversionOptions{PageSize: "a4", Orientation: "Portrait"}))
+	if a != b {
+		t.Errorf("expected equivalent options to have the same key, got %s, and %s", a, b)
+	}
+	c, _ := cacheKey(mockRequest("globex", converter.ConversionOptions{}))
+	if a == c {
+		t.Errorf("expected different tenants to have different keys")
+	}
+	d, _ := cacheKey(mockRequest("acme", converter.ConversionOptions{Orientation: "landscape"}))
+	if a == d {
+		t.Errorf("expected different options to have different keys")
+	}
+}
+
+func TestEtagMatch(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{`"abc"`, true},
+		{`"xyz", W/"abc"`, true},
+		{`*`, true},
+		{`"xyz"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatch(tt.ifNoneMatch, `"abc"`); got != tt.want {
+			t.Errorf("expected %s to match: %t, got %t", tt.ifNoneMatch, tt.want, got)
+		}
+	}
+}
+
+func TestServeEntry(t *testing.T) {
+	e := cache.NewEntry([]byte("%PDF"), time.Minute)
+	r := gin.Default()
+	r.GET("/", func(c *gin.Context) { serveEntry(c, e) })
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	if got, want := res.Header().Get("ETag"), e.ETag; got != want {
+		t.Errorf("expected ETag header to be %s, got %s", want, got)
+	}
+	if got, want := res.Body.String(), "%PDF"; got != want {
+		t.Errorf("expected response body to be %s, got %s", want, got)
+	}
+
+	res = httptest.NewRecorder()
+	req.Header.Set("If-None-Match", e.ETag)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusNotModified; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	if res.Body.Len() != 0 {
+		t.Errorf("expected an empty response body, got %s", res.Body.String())
+	}
+}
@@ -0,0 +1,115 @@
+package callback
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader is the HTTP header containing the signature of a callback
+// body. Its value is in the form of 'sha256=<hex encoded HMAC>'.
+const SignatureHeader = "X-Weaver-Signature"
+
+// Payload is the JSON document sent to a callback URL when a conversion job
+// has finished.
+type Payload struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Location is set if the conversion has been uploaded.
+	Location string `json:"location,omitempty"`
+	// Data contains the output of the conversion (base64 encoded in JSON) if
+	// it has not been uploaded.
+	Data []byte `json:"data,omitempty"`
+	// Timestamp is the Unix time of the delivery attempt. It is part of the
+	// signed body, so receivers can reject replayed callbacks by discarding
+	// the ones which are too old.
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Sign returns the signature of a callback body using a HMAC-SHA256 of the
+// key. Receivers can verify a callback by computing the same signature, and
+// comparing it against the SignatureHeader.
+func Sign(key string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Client delivers signed callbacks with retries.
+type Client struct {
+	HTTPClient *http.Client
+	// Key used to sign the callback body.
+	Key string
+	// MaxAttempts is the maximum number of delivery attempts.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It is doubled after every
+	// failed attempt.
+	Backoff time.Duration
+}
+
+// post sends a single callback request. It returns a boolean indicating if
+// the delivery can be retried, and an error if it has failed.
+func (c Client) post(url string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(c.Key, body))
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	res, err := hc.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("[Callback] did not receive HTTP 2xx, response: %s", res.Status)
+	// Client errors will not go away by retrying (except rate limiting)
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+// Deliver POSTs the payload to a callback URL. It retries with an exponential
+// backoff until it succeeds, it receives a non-retryable response, or the
+// maximum number of attempts has been reached. The timestamp of the payload
+// is set (and signed) again for every attempt.
+func (c Client) Deliver(url string, p Payload) error {
+	backoff := c.Backoff
+	for attempt := 1; ; attempt++ {
+		p.Timestamp = time.Now().Unix()
+		body, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+
+		retry, err := c.post(url, body)
+		if err == nil {
+			log.Printf("[Callback] delivered job %s to %s\n", p.ID, url)
+			return nil
+		}
+
+		if !retry || attempt >= c.MaxAttempts {
+			return err
+		}
+
+		log.Printf("[Callback] attempt #%d for job %s failed, retrying in %s: %+v\n", attempt, p.ID, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,102 @@
+package callback
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("test key", []byte("test body"))
+	want := "sha256=e327344d7533dfd50ceed6541c3ba412b06cc52b0cdfc900fcaf58992b470617"
+	if got != want {
+		t.Errorf("expected signature to be %s, got %s", want, got)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	p := Payload{ID: "123", Status: "succeeded", Data: []byte("%PDF")}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("unable to read request body: %+v", err)
+		}
+		if got, want := r.Header.Get(SignatureHeader), Sign("test key", b); got != want {
+			t.Errorf("expected signature header to be %s, got %s", want, got)
+		}
+		var got Payload
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatalf("unable to json decode request body: %+v", err)
+		}
+		if d := time.Now().Unix() - got.Timestamp; d < 0 || d > 5 {
+			t.Errorf("expected callback timestamp to be the current time, got %d", got.Timestamp)
+		}
+		got.Timestamp = 0
+		if !reflect.DeepEqual(got, p) {
+			t.Errorf("expected callback payload to be %+v, got %+v", p, got)
+		}
+	}))
+	defer ts.Close()
+
+	c := Client{Key: "test key", MaxAttempts: 1}
+	if err := c.Deliver(ts.URL, p); err != nil {
+		t.Fatalf("deliver returned an unexpected error: %+v", err)
+	}
+}
+
+func TestDeliver_retry(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	c := Client{Key: "test key", MaxAttempts: 5, Backoff: time.Millisecond}
+	if err := c.Deliver(ts.URL, Payload{ID: "123"}); err != nil {
+		t.Fatalf("deliver returned an unexpected error: %+v", err)
+	}
+	if got, want := atomic.LoadInt32(&attempts), int32(3); got != want {
+		t.Errorf("expected %d delivery attempts, got %d", want, got)
+	}
+}
+
+func TestDeliver_maxAttempts(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	c := Client{Key: "test key", MaxAttempts: 2, Backoff: time.Millisecond}
+	if err := c.Deliver(ts.URL, Payload{ID: "123"}); err == nil {
+		t.Fatalf("expected error to be returned")
+	}
+	if got, want := atomic.LoadInt32(&attempts), int32(2); got != want {
+		t.Errorf("expected %d delivery attempts, got %d", want, got)
+	}
+}
+
+func TestDeliver_clientError(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	c := Client{Key: "test key", MaxAttempts: 5, Backoff: time.Millisecond}
+	if err := c.Deliver(ts.URL, Payload{ID: "123"}); err == nil {
+		t.Fatalf("expected error to be returned")
+	}
+	if got, want := atomic.LoadInt32(&attempts), int32(1); got != want {
+		t.Errorf("expected client errors to not be retried, got %d attempts", got)
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
 )
 
 // CloudConvert configuration.
@@ -24,6 +28,63 @@ type Statsd struct {
 	Prefix  string
 }
 
+// S3 storage configuration.
+// The 's3' storage backend is only registered if a bucket is set.
+type S3 struct {
+	// Defaults to 'us-east-1'.
+	Region       string
+	AccessKey    string
+	AccessSecret string
+	Bucket       string
+	// Defaults to 'public-read'.
+	ACL string
+	// Prepended to every key.
+	KeyPrefix string
+	// Endpoint of an S3-compatible object store (e.g. MinIO).
+	// Defaults to AWS.
+	Endpoint string
+	// Use path-style addressing (required by most S3-compatible stores).
+	// Defaults to false.
+	PathStyle bool
+	// Use HTTP if the endpoint does not specify a scheme.
+	// Defaults to false.
+	DisableSSL bool
+	// AWS signature version: 'v4' or 'v2'.
+	// Defaults to 'v4'.
+	SignatureVersion string
+}
+
+// Qiniu (七牛) storage configuration.
+// The 'qiniu' storage backend is only registered if a bucket is set.
+type Qiniu struct {
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	// Download domain bound to the bucket, e.g. 'https://cdn.example.com'.
+	Domain string
+	// Prepended to every key.
+	KeyPrefix string
+	// Sign download URLs for private buckets.
+	// Defaults to false.
+	Private bool
+	// Upload host of the bucket's region.
+	// Defaults to 'https://upload.qiniup.com'.
+	UpHost string
+	// Resource management host.
+	// Defaults to 'https://rs.qiniu.com'.
+	RSHost string
+}
+
+// LocalStorage configuration.
+// The 'local' storage backend is only registered if a directory is set.
+type LocalStorage struct {
+	// Directory to store conversions in (e.g. a mounted volume).
+	Dir string
+	// Base URL used to build the location of a stored conversion.
+	// Defaults to a 'file://' URL.
+	BaseURL string
+}
+
 // Config for Weaver.
 // It contains all the configuration variables that will be used by the
 // microservice.
@@ -31,6 +92,25 @@ type Config struct {
 	CloudConvert
 	// Defaults to none.
 	Statsd
+	// Toggles the Prometheus metrics endpoint (/metrics).
+	// Defaults to false.
+	Prometheus bool
+	// Requires an API key for the stats endpoint (/stats), so that tenants
+	// can see their own conversions. It is public, and every conversion is
+	// redacted otherwise.
+	// Defaults to false.
+	StatsAuth bool
+	// Defaults to none.
+	S3 S3
+	// Defaults to none.
+	Qiniu Qiniu
+	// Defaults to none.
+	LocalStorage LocalStorage
+	// The name of the storage backend used when a conversion request does
+	// not specify one. The output of a conversion is returned to the client
+	// if there is no storage backend.
+	// Defaults to none.
+	Storage string
 	// The address:port for the HTTP server to listen on.
 	// Defaults to ':8080'
 	HTTPAddr string
@@ -47,9 +127,32 @@ type Config struct {
 	// It will be used to protect all conversion routes.
 	// Defaults to 'arachnys-weaver'.
 	AuthKey string
+	// JSON file of API keys (with their tenant, routes, and quota) used
+	// instead of AuthKey. It is reloaded when modified, so that keys can be
+	// rotated, or revoked without restarting.
+	// Defaults to none.
+	AuthKeysFile string
+	// PEM encoded public key (RSA, or ECDSA P-256) used for verifying the
+	// JWTs of signed URLs.
+	// Defaults to none (only URLs signed with the secret of a key are
+	// accepted).
+	JWTPublicKeyFile string
 	// See AthenaPDF CMD.
 	// Defaults to 'athenapdf -S'.
 	AthenaCMD string
+	// The base command launching the headless Chromium used by the chromium
+	// converter. It is launched on the first conversion, and shared by the
+	// following ones.
+	// Defaults to 'chromium --headless --disable-gpu'.
+	ChromiumCMD string
+	// The DevTools endpoint of a running Chromium (e.g.
+	// 'http://chromium:9222'), used by the chromium converter instead of
+	// launching ChromiumCMD.
+	// Defaults to none.
+	ChromiumURL string
+	// See WkHTMLToPDF CMD.
+	// Defaults to 'wkhtmltopdf -q'.
+	WkHTMLToPDFCMD string
 	// The maximum number of workers / concurrent conversions that can be
 	// running at any one time.
 	// Defaults to 10.
@@ -58,10 +161,88 @@ type Config struct {
 	// any one time without blocking a NewWork Goroutine.
 	// Defaults to 50.
 	MaxConversionQueue int
+	// Shares the workers between priorities (interactive, and bulk), and
+	// tenants, with weights, and per-tenant concurrency, and queue limits.
+	// Defaults to a weight of 4 for interactive, 1 for bulk, 1 for every
+	// tenant, and no limits.
+	Scheduler converter.SchedulerOptions
+	// Seconds to wait for a free slot in the work queue when it is full,
+	// before rejecting a conversion with 503 Service Unavailable.
+	// Defaults to 0 (conversions are rejected straight away).
+	QueueWait int
 	// Seconds until a conversion job is terminated, and a handler is returned.
 	// Defaults to 90.
 	WorkerTimeout int
-	// Toggles falling back to CloudConvert if athenapdf CLI fails to convert.
+	// Seconds given to in-flight conversions to finish on shutdown (SIGTERM)
+	// before they are aborted.
+	// Defaults to 120.
+	ShutdownTimeout int
+	// Directory where asynchronous jobs (and their local sources) are
+	// persisted, so that unfinished jobs are resumed after a restart.
+	// Defaults to none (the jobs are only held in memory).
+	JobDir string
+	// BoltDB file where the daily, and monthly usage of the API keys is
+	// persisted, so that their quotas are not reset by a restart.
+	// Defaults to none (the usage is only held in memory).
+	QuotaFile string
+	// Backend of the result cache ('memory', or 'disk'), which serves
+	// identical conversions without running them again. Only the conversions
+	// returned to the client are cached.
+	// Defaults to none (disabled).
+	Cache string
+	// Seconds until a cached conversion expires. It must be positive if the
+	// cache is enabled.
+	// Defaults to 300.
+	CacheTTL int
+	// Directory of the disk cache.
+	// Defaults to none.
+	CacheDir string
+	// Maximum size in bytes of the memory cache.
+	// Defaults to 268435456 (256 MB).
+	CacheMaxSize int64
+	// Seconds until a finished asynchronous job (and its output) is removed
+	// from the job store.
+	// Defaults to 3600.
+	JobTTL int
+	// Maximum number of attempts to deliver a job callback.
+	// Defaults to 5.
+	CallbackMaxAttempts int
+	// Seconds before retrying a failed callback. It is doubled after every
+	// failed attempt.
+	// Defaults to 1.
+	CallbackBackoff int
+	// Seconds until a callback request is terminated.
+	// Defaults to 10.
+	CallbackTimeout int
+	// Sign the callbacks with the API key which has submitted the job instead
+	// of AuthKey, so that tenants can only verify their own callbacks.
+	// Defaults to false.
+	CallbackSignWithAPIKey bool
+	// Restricts the URLs that can be converted (to prevent SSRF).
+	// Defaults to http, and https URLs which do not resolve to private IPs.
+	URLPolicy converter.URLPolicy
+	// Restricts the size, and the content type of the conversion sources,
+	// and how long they take to fetch.
+	// Defaults to 50 MB, a 30 seconds fetch timeout, 10 redirects, and any
+	// content type.
+	Limits converter.Limits
+	// Bytes which must be available in /tmp for the readiness check to pass.
+	// Defaults to 100 MB.
+	MinFreeSpace int64
+	// Toggles running a canary conversion in the background, whose result is
+	// reported by the readiness check.
+	// Defaults to false.
+	ReadinessCanary bool
+	// Seconds between two canary conversions.
+	// Defaults to 300.
+	ReadinessCanaryInterval int
+	// Ordered chain of converters: the first one which supports a
+	// conversion is used, and the following ones are tried in order if it
+	// fails (see ConversionFallback).
+	// Defaults to 'athenapdf,cloudconvert'.
+	Converters []string
+	// Toggles falling back to the next converter in the chain (e.g.
+	// CloudConvert) if a converter fails to convert.
 	// The failure may also be due to a timeout.
 	// Defaults to false.
 	ConversionFallback bool
@@ -70,19 +251,89 @@ type Config struct {
 	SentryDSN string
 }
 
+// redactedValue replaces the secrets of a Config in the logs.
+const redactedValue = "[redacted]"
+
+// redact returns redactedValue if a secret is set (so that it is still
+// visible whether it is set).
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// Redacted returns a copy of the config without its secrets (e.g. for
+// logging).
+func (c Config) Redacted() Config {
+	c.CloudConvert.APIKey = redact(c.CloudConvert.APIKey)
+	c.S3.AccessSecret = redact(c.S3.AccessSecret)
+	c.Qiniu.SecretKey = redact(c.Qiniu.SecretKey)
+	c.AuthKey = redact(c.AuthKey)
+	// The DSN contains the secret key of the Sentry project
+	c.SentryDSN = redact(c.SentryDSN)
+	return c
+}
+
+// splitList splits a comma-separated list, and removes empty items.
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// splitMap splits a comma-separated list of 'key=number' pairs, and ignores
+// invalid pairs.
+func splitMap(s string) map[string]int {
+	m := make(map[string]int)
+	for _, v := range splitList(s) {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			continue
+		}
+		m[strings.TrimSpace(kv[0])] = n
+	}
+	return m
+}
+
 // NewEnvConfig initialises configuration variables from the environment.
 func NewEnvConfig() Config {
 	// Set defaults
 	cloudconvert := CloudConvert{APIUrl: "https://api.cloudconvert.com"}
 	conf := Config{
-		CloudConvert:       cloudconvert,
-		HTTPAddr:           ":8080",
-		AuthKey:            "smm-pdfcenter",
-		AthenaCMD:          "athenapdf -S",
-		MaxWorkers:         10,
-		MaxConversionQueue: 50,
-		WorkerTimeout:      90,
-		ConversionFallback: false,
+		CloudConvert:        cloudconvert,
+		HTTPAddr:            ":8080",
+		AuthKey:             "smm-pdfcenter",
+		AthenaCMD:           "athenapdf -S",
+		ChromiumCMD:         "chromium --headless --disable-gpu",
+		WkHTMLToPDFCMD:      "wkhtmltopdf -q",
+		MaxWorkers:          10,
+		MaxConversionQueue:  50,
+		WorkerTimeout:       90,
+		ShutdownTimeout:     120,
+		JobTTL:              3600,
+		CacheTTL:            300,
+		CacheMaxSize:        256 << 20,
+		CallbackMaxAttempts: 5,
+		CallbackBackoff:     1,
+		CallbackTimeout:     10,
+		Limits: converter.Limits{
+			MaxSize:      50 << 20,
+			FetchTimeout: 30 * time.Second,
+			MaxRedirects: 10,
+		},
+		MinFreeSpace:            100 << 20,
+		ReadinessCanaryInterval: 300,
+		Converters:              []string{"athenapdf", "cloudconvert"},
+		ConversionFallback:      false,
 	}
 
 	if httpAddr := os.Getenv("WEAVER_HTTP_ADDR"); httpAddr != "" {
@@ -105,10 +356,30 @@ func NewEnvConfig() Config {
 		conf.AuthKey = authKey
 	}
 
+	if authKeysFile := os.Getenv("WEAVER_AUTH_KEYS_FILE"); authKeysFile != "" {
+		conf.AuthKeysFile = authKeysFile
+	}
+
+	if jwtPublicKeyFile := os.Getenv("WEAVER_JWT_PUBLIC_KEY_FILE"); jwtPublicKeyFile != "" {
+		conf.JWTPublicKeyFile = jwtPublicKeyFile
+	}
+
 	if athenaCMD := os.Getenv("WEAVER_ATHENA_CMD"); athenaCMD != "" {
 		conf.AthenaCMD = athenaCMD
 	}
 
+	if chromiumCMD := os.Getenv("WEAVER_CHROMIUM_CMD"); chromiumCMD != "" {
+		conf.ChromiumCMD = chromiumCMD
+	}
+
+	if chromiumURL := os.Getenv("WEAVER_CHROMIUM_URL"); chromiumURL != "" {
+		conf.ChromiumURL = chromiumURL
+	}
+
+	if wkhtmltopdfCMD := os.Getenv("WEAVER_WKHTMLTOPDF_CMD"); wkhtmltopdfCMD != "" {
+		conf.WkHTMLToPDFCMD = wkhtmltopdfCMD
+	}
+
 	// NOTE: we aren't handle the _unlikely_ event of errors properly (they are being suppressed)
 	if maxWorkers := os.Getenv("WEAVER_MAX_WORKERS"); maxWorkers != "" {
 		conf.MaxWorkers, _ = strconv.Atoi(maxWorkers)
@@ -118,14 +389,134 @@ func NewEnvConfig() Config {
 		conf.MaxConversionQueue, _ = strconv.Atoi(maxConversionQueue)
 	}
 
+	if priorityWeights := os.Getenv("WEAVER_PRIORITY_WEIGHTS"); priorityWeights != "" {
+		conf.Scheduler.PriorityWeights = make(map[converter.Priority]int)
+		for p, w := range splitMap(priorityWeights) {
+			conf.Scheduler.PriorityWeights[converter.Priority(p)] = w
+		}
+	}
+
+	if tenantWeights := os.Getenv("WEAVER_TENANT_WEIGHTS"); tenantWeights != "" {
+		conf.Scheduler.TenantWeights = splitMap(tenantWeights)
+	}
+
+	if tenantMaxWorkers := os.Getenv("WEAVER_TENANT_MAX_WORKERS"); tenantMaxWorkers != "" {
+		conf.Scheduler.TenantMaxWorkers = splitMap(tenantMaxWorkers)
+	}
+
+	if tenantMaxQueued := os.Getenv("WEAVER_TENANT_MAX_QUEUED"); tenantMaxQueued != "" {
+		conf.Scheduler.TenantMaxQueued = splitMap(tenantMaxQueued)
+	}
+
+	if queueWait := os.Getenv("WEAVER_QUEUE_WAIT"); queueWait != "" {
+		conf.QueueWait, _ = strconv.Atoi(queueWait)
+	}
+
 	if workerTimeout := os.Getenv("WEAVER_WORKER_TIMEOUT"); workerTimeout != "" {
 		conf.WorkerTimeout, _ = strconv.Atoi(workerTimeout)
 	}
 
+	if shutdownTimeout := os.Getenv("WEAVER_SHUTDOWN_TIMEOUT"); shutdownTimeout != "" {
+		conf.ShutdownTimeout, _ = strconv.Atoi(shutdownTimeout)
+	}
+
+	if jobDir := os.Getenv("WEAVER_JOB_DIR"); jobDir != "" {
+		conf.JobDir = jobDir
+	}
+
+	if quotaFile := os.Getenv("WEAVER_QUOTA_FILE"); quotaFile != "" {
+		conf.QuotaFile = quotaFile
+	}
+
+	if cache := os.Getenv("WEAVER_CACHE"); cache != "" {
+		conf.Cache = cache
+	}
+
+	if cacheTTL := os.Getenv("WEAVER_CACHE_TTL"); cacheTTL != "" {
+		conf.CacheTTL, _ = strconv.Atoi(cacheTTL)
+	}
+
+	if cacheDir := os.Getenv("WEAVER_CACHE_DIR"); cacheDir != "" {
+		conf.CacheDir = cacheDir
+	}
+
+	if cacheMaxSize := os.Getenv("WEAVER_CACHE_MAX_SIZE"); cacheMaxSize != "" {
+		conf.CacheMaxSize, _ = strconv.ParseInt(cacheMaxSize, 10, 64)
+	}
+
+	if jobTTL := os.Getenv("WEAVER_JOB_TTL"); jobTTL != "" {
+		conf.JobTTL, _ = strconv.Atoi(jobTTL)
+	}
+
+	if callbackMaxAttempts := os.Getenv("WEAVER_CALLBACK_MAX_ATTEMPTS"); callbackMaxAttempts != "" {
+		conf.CallbackMaxAttempts, _ = strconv.Atoi(callbackMaxAttempts)
+	}
+
+	if callbackBackoff := os.Getenv("WEAVER_CALLBACK_BACKOFF"); callbackBackoff != "" {
+		conf.CallbackBackoff, _ = strconv.Atoi(callbackBackoff)
+	}
+
+	if callbackTimeout := os.Getenv("WEAVER_CALLBACK_TIMEOUT"); callbackTimeout != "" {
+		conf.CallbackTimeout, _ = strconv.Atoi(callbackTimeout)
+	}
+
+	if callbackSignWithAPIKey := os.Getenv("WEAVER_CALLBACK_SIGN_WITH_API_KEY"); callbackSignWithAPIKey != "" {
+		conf.CallbackSignWithAPIKey, _ = strconv.ParseBool(callbackSignWithAPIKey)
+	}
+
+	if converters := os.Getenv("WEAVER_CONVERTERS"); converters != "" {
+		conf.Converters = splitList(converters)
+	}
+
 	if conversionFallback := os.Getenv("WEAVER_CONVERSION_FALLBACK"); conversionFallback != "" {
 		conf.ConversionFallback, _ = strconv.ParseBool(conversionFallback)
 	}
 
+	if urlSchemes := os.Getenv("WEAVER_URL_SCHEMES"); urlSchemes != "" {
+		conf.URLPolicy.Schemes = splitList(urlSchemes)
+	}
+
+	if urlAllowHosts := os.Getenv("WEAVER_URL_ALLOW_HOSTS"); urlAllowHosts != "" {
+		conf.URLPolicy.AllowHosts = splitList(urlAllowHosts)
+	}
+
+	if urlDenyHosts := os.Getenv("WEAVER_URL_DENY_HOSTS"); urlDenyHosts != "" {
+		conf.URLPolicy.DenyHosts = splitList(urlDenyHosts)
+	}
+
+	if urlAllowPrivate := os.Getenv("WEAVER_URL_ALLOW_PRIVATE"); urlAllowPrivate != "" {
+		conf.URLPolicy.AllowPrivate, _ = strconv.ParseBool(urlAllowPrivate)
+	}
+
+	if maxSourceSize := os.Getenv("WEAVER_MAX_SOURCE_SIZE"); maxSourceSize != "" {
+		conf.Limits.MaxSize, _ = strconv.ParseInt(maxSourceSize, 10, 64)
+	}
+
+	if fetchTimeout := os.Getenv("WEAVER_FETCH_TIMEOUT"); fetchTimeout != "" {
+		t, _ := strconv.Atoi(fetchTimeout)
+		conf.Limits.FetchTimeout = time.Second * time.Duration(t)
+	}
+
+	if maxRedirects := os.Getenv("WEAVER_MAX_REDIRECTS"); maxRedirects != "" {
+		conf.Limits.MaxRedirects, _ = strconv.Atoi(maxRedirects)
+	}
+
+	if mimeTypes := os.Getenv("WEAVER_MIME_TYPES"); mimeTypes != "" {
+		conf.Limits.MimeTypes = splitList(mimeTypes)
+	}
+
+	if minFreeSpace := os.Getenv("WEAVER_MIN_FREE_SPACE"); minFreeSpace != "" {
+		conf.MinFreeSpace, _ = strconv.ParseInt(minFreeSpace, 10, 64)
+	}
+
+	if readinessCanary := os.Getenv("WEAVER_READINESS_CANARY"); readinessCanary != "" {
+		conf.ReadinessCanary, _ = strconv.ParseBool(readinessCanary)
+	}
+
+	if readinessCanaryInterval := os.Getenv("WEAVER_READINESS_CANARY_INTERVAL"); readinessCanaryInterval != "" {
+		conf.ReadinessCanaryInterval, _ = strconv.Atoi(readinessCanaryInterval)
+	}
+
 	if cloudConvertAPI := os.Getenv("CLOUDCONVERT_API"); cloudConvertAPI != "" {
 		conf.CloudConvert.APIUrl = cloudConvertAPI
 	}
@@ -142,11 +533,103 @@ func NewEnvConfig() Config {
 		conf.Statsd.Prefix = statsdPrefix
 	}
 
+	if prometheus := os.Getenv("WEAVER_PROMETHEUS"); prometheus != "" {
+		conf.Prometheus, _ = strconv.ParseBool(prometheus)
+	}
+
+	if statsAuth := os.Getenv("WEAVER_STATS_AUTH"); statsAuth != "" {
+		conf.StatsAuth, _ = strconv.ParseBool(statsAuth)
+	}
+
+	if storage := os.Getenv("WEAVER_STORAGE"); storage != "" {
+		conf.Storage = storage
+	}
+
+	if s3Region := os.Getenv("WEAVER_S3_REGION"); s3Region != "" {
+		conf.S3.Region = s3Region
+	}
+
+	if s3AccessKey := os.Getenv("WEAVER_S3_ACCESS_KEY"); s3AccessKey != "" {
+		conf.S3.AccessKey = s3AccessKey
+	}
+
+	if s3AccessSecret := os.Getenv("WEAVER_S3_ACCESS_SECRET"); s3AccessSecret != "" {
+		conf.S3.AccessSecret = s3AccessSecret
+	}
+
+	if s3Bucket := os.Getenv("WEAVER_S3_BUCKET"); s3Bucket != "" {
+		conf.S3.Bucket = s3Bucket
+	}
+
+	if s3ACL := os.Getenv("WEAVER_S3_ACL"); s3ACL != "" {
+		conf.S3.ACL = s3ACL
+	}
+
+	if s3KeyPrefix := os.Getenv("WEAVER_S3_KEY_PREFIX"); s3KeyPrefix != "" {
+		conf.S3.KeyPrefix = s3KeyPrefix
+	}
+
+	if s3Endpoint := os.Getenv("WEAVER_S3_ENDPOINT"); s3Endpoint != "" {
+		conf.S3.Endpoint = s3Endpoint
+	}
+
+	if s3PathStyle := os.Getenv("WEAVER_S3_PATH_STYLE"); s3PathStyle != "" {
+		conf.S3.PathStyle, _ = strconv.ParseBool(s3PathStyle)
+	}
+
+	if s3DisableSSL := os.Getenv("WEAVER_S3_DISABLE_SSL"); s3DisableSSL != "" {
+		conf.S3.DisableSSL, _ = strconv.ParseBool(s3DisableSSL)
+	}
+
+	if s3SignatureVersion := os.Getenv("WEAVER_S3_SIGNATURE_VERSION"); s3SignatureVersion != "" {
+		conf.S3.SignatureVersion = s3SignatureVersion
+	}
+
+	if qiniuAccessKey := os.Getenv("QINIU_ACCESS_KEY"); qiniuAccessKey != "" {
+		conf.Qiniu.AccessKey = qiniuAccessKey
+	}
+
+	if qiniuSecretKey := os.Getenv("QINIU_SECRET_KEY"); qiniuSecretKey != "" {
+		conf.Qiniu.SecretKey = qiniuSecretKey
+	}
+
+	if qiniuBucket := os.Getenv("QINIU_BUCKET"); qiniuBucket != "" {
+		conf.Qiniu.Bucket = qiniuBucket
+	}
+
+	if qiniuDomain := os.Getenv("QINIU_DOMAIN"); qiniuDomain != "" {
+		conf.Qiniu.Domain = qiniuDomain
+	}
+
+	if qiniuKeyPrefix := os.Getenv("QINIU_KEY_PREFIX"); qiniuKeyPrefix != "" {
+		conf.Qiniu.KeyPrefix = qiniuKeyPrefix
+	}
+
+	if qiniuPrivate := os.Getenv("QINIU_PRIVATE"); qiniuPrivate != "" {
+		conf.Qiniu.Private, _ = strconv.ParseBool(qiniuPrivate)
+	}
+
+	if qiniuUpHost := os.Getenv("QINIU_UP_HOST"); qiniuUpHost != "" {
+		conf.Qiniu.UpHost = qiniuUpHost
+	}
+
+	if qiniuRSHost := os.Getenv("QINIU_RS_HOST"); qiniuRSHost != "" {
+		conf.Qiniu.RSHost = qiniuRSHost
+	}
+
+	if localStorageDir := os.Getenv("WEAVER_LOCAL_STORAGE_DIR"); localStorageDir != "" {
+		conf.LocalStorage.Dir = localStorageDir
+	}
+
+	if localStorageURL := os.Getenv("WEAVER_LOCAL_STORAGE_URL"); localStorageURL != "" {
+		conf.LocalStorage.BaseURL = localStorageURL
+	}
+
 	if sentryDSN := os.Getenv("SENTRY_DSN"); sentryDSN != "" {
 		conf.SentryDSN = sentryDSN
 	}
 
-	log.Printf("PDF 转换服务基础配置: %+v", conf)
+	log.Printf("PDF 转换服务基础配置: %+v", conf.Redacted())
 
 	return conf
 }
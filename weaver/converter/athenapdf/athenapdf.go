@@ -2,6 +2,9 @@ package athenapdf
 
 import (
 	"log"
+	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/arachnys/athenapdf/weaver/converter"
@@ -11,11 +14,9 @@ import (
 // AthenaPDF represents a conversion job for athenapdf CLI.
 // AthenaPDF implements the Converter interface with a custom Convert method.
 type AthenaPDF struct {
-	// AthenaPDF inherits properties from UploadConversion, and as such,
-	// it supports uploading of its results to S3
-	// (if the necessary credentials are given).
-	// See UploadConversion for more information.
-	converter.UploadConversion
+	// AthenaPDF inherits properties from Conversion.
+	// See Conversion for more information.
+	converter.Conversion
 	// CMD is the base athenapdf CLI command that will be executed.
 	// e.g. 'athenapdf -S -T 120'
 	CMD string
@@ -23,28 +24,102 @@ type AthenaPDF struct {
 	// an '-A' command-line flag to indicate aggressive content extraction
 	// (ideal for a clutter-free reading experience).
 	Aggressive bool
+	// Proxy (optional) is the URL of the proxy through which athenapdf CLI
+	// loads the page, and its resources (see converter.PolicyProxy).
+	Proxy string
+}
+
+// Capabilities of athenapdf CLI: it renders anything Electron (Chromium) can
+// display, and it supports every option.
+var Capabilities = converter.Capabilities{
+	Options: []string{"page_size", "margins", "orientation", "zoom", "delay", "no_background", "aggressive"},
+	Headers: true,
+}
+
+// optionArgs returns the athenapdf CLI flags for the conversion options.
+// Options which are not set are omitted so that the CLI defaults are used.
+// The values are normalized, as the CLI is case-sensitive.
+func optionArgs(o converter.ConversionOptions) []string {
+	var args []string
+	if o.PageSize != "" {
+		args = append(args, "-P", o.Normalize().PageSize)
+	}
+	if o.Margins != "" {
+		args = append(args, "-M", o.Normalize().Margins)
+	}
+	if o.Landscape() {
+		args = append(args, "--no-portrait")
+	}
+	if o.Zoom > 0 {
+		args = append(args, "-Z", strconv.Itoa(o.Zoom))
+	}
+	if o.Delay > 0 {
+		args = append(args, "-D", strconv.Itoa(o.Delay))
+	}
+	if o.NoBackground {
+		args = append(args, "--no-background")
+	}
+	return args
+}
+
+// headerArgs returns an '-H' athenapdf CLI flag for every header value.
+// Cookie headers are left out, as they would be sent to every host (see
+// cookieArgs).
+// The headers are sorted so that the command is deterministic.
+func headerArgs(h http.Header) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		if http.CanonicalHeaderKey(k) != "Cookie" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		for _, v := range h[k] {
+			// -H, --http-header <key:value>  add custom headers to request (default: )
+			args = append(args, "-H", k+": "+v)
+		}
+	}
+	return args
+}
+
+// cookieArgs returns a '--cookie' athenapdf CLI flag for every cookie, in the
+// Set-Cookie format (e.g. 'session=a; Path=/; Domain=example.com'), so that
+// it is only sent to its domain, and path.
+func cookieArgs(cookies []*http.Cookie) []string {
+	var args []string
+	for _, c := range cookies {
+		// --cookie <cookie>  set a cookie before loading the URI (default: )
+		args = append(args, "--cookie", c.String())
+	}
+	return args
 }
 
 // constructCMD returns a string array containing the AthenaPDF command to be
 // executed by Go's os/exec Output. It does this using a base command, and path
 // string.
-// It will set an additional '-A' flag if aggressive is set to true.
+// The conversion options are mapped onto their equivalent CLI flags.
+// It will set an additional '-A' flag if aggressive is set to true, and an
+// '-H' flag for every header, a '--cookie' flag for every cookie, and a
+// '--proxy' flag if proxy is not empty.
 // See athenapdf CLI for more information regarding the aggressive mode.
-func constructCMD(base string, path string, aggressive bool, headerKV string) []string {
+func constructCMD(base string, path string, aggressive bool, proxy string, header http.Header, cookies []*http.Cookie, o converter.ConversionOptions) []string {
 	args := strings.Fields(base)
 	args = append(args, path)
+	args = append(args, optionArgs(o)...)
 	if aggressive {
 		args = append(args, "-A")
 	}
-
-	if len(headerKV) > 0 {
-		// -H, --http-header <key:value>  add custom headers to request (default: )
-		args = append(args, "-H")
-		args = append(args, headerKV)
+	if proxy != "" {
+		args = append(args, "--proxy", proxy)
 	}
 
+	// Headers, and cookies may contain credentials, so they are not logged
 	log.Println("最终执行完整命令: ", args)
-	return args
+	args = append(args, headerArgs(header)...)
+	return append(args, cookieArgs(cookies)...)
 }
 
 // Convert returns a byte slice containing a PDF converted from HTML
@@ -52,10 +127,9 @@ func constructCMD(base string, path string, aggressive bool, headerKV string) []
 // See the Convert method for Conversion for more information.
 func (c AthenaPDF) Convert(s converter.ConversionSource, done <-chan struct{}) ([]byte, error) {
 	log.Printf("[AthenaPDF] 命令正在转换 PDF: %s\n", s.GetActualURI())
-	log.Printf("[AthenaPDF] 命令请求 headerKV: %+v\n", s.HeaderKV)
 
 	// Construct the command to execute
-	cmd := constructCMD(c.CMD, s.URI, c.Aggressive, s.HeaderKV)
+	cmd := constructCMD(c.CMD, s.URI, c.Aggressive, c.Proxy, s.Header, s.Cookies, s.Options)
 	out, err := gcmd.Execute(cmd, done)
 	if err != nil {
 		return nil, err
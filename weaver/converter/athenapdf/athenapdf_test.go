@@ -2,6 +2,7 @@ package athenapdf
 
 import (
 	"io/ioutil"
+	"net/http"
 	"path/filepath"
 	"reflect"
 	"testing"
@@ -11,7 +12,7 @@ import (
 )
 
 func TestConstructCMD(t *testing.T) {
-	got := constructCMD("athenapdf -S -T 120", "test_file.html", false, "")
+	got := constructCMD("athenapdf -S -T 120", "test_file.html", false, "", nil, nil, converter.ConversionOptions{})
 	want := []string{"athenapdf", "-S", "-T", "120", "test_file.html"}
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("expected constructed athenapdf command to be %+v, got %+v", want, got)
@@ -19,12 +20,59 @@ func TestConstructCMD(t *testing.T) {
 }
 
 func TestConstructCMD_aggressive(t *testing.T) {
-	cmd := constructCMD("athenapdf -S -T 60", "test_file.html", true, "")
+	cmd := constructCMD("athenapdf -S -T 60", "test_file.html", true, "", nil, nil, converter.ConversionOptions{})
 	if got, want := cmd[len(cmd)-1], "-A"; got != want {
 		t.Errorf("expected last argument of constructed athenapdf command to be %s, got %+v", want, got)
 	}
 }
 
+func TestConstructCMD_proxy(t *testing.T) {
+	got := constructCMD("athenapdf -S", "test_file.html", false, "http://127.0.0.1:3128", nil, nil, converter.ConversionOptions{})
+	want := []string{"athenapdf", "-S", "test_file.html", "--proxy", "http://127.0.0.1:3128"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected constructed athenapdf command to be %+v, got %+v", want, got)
+	}
+}
+
+func TestConstructCMD_options(t *testing.T) {
+	o := converter.ConversionOptions{
+		PageSize:     "letter",
+		Margins:      "None",
+		Orientation:  "landscape",
+		Zoom:         2,
+		Delay:        500,
+		NoBackground: true,
+	}
+	got := constructCMD("athenapdf -S", "test_file.html", false, "", nil, nil, o)
+	want := []string{
+		"athenapdf", "-S", "test_file.html",
+		"-P", "Letter", "-M", "none", "--no-portrait", "-Z", "2", "-D", "500", "--no-background",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected constructed athenapdf command to be %+v, got %+v", want, got)
+	}
+}
+
+func TestConstructCMD_headers(t *testing.T) {
+	h := http.Header{
+		"Cookie":        {"session=a; token=b"},
+		"Authorization": {"Bearer test"},
+	}
+	cookies := []*http.Cookie{
+		{Name: "session", Value: "a", Path: "/", Domain: "example.com"},
+		{Name: "token", Value: "b", Path: "/"},
+	}
+	got := constructCMD("athenapdf -S", "test_file.html", false, "", h, cookies, converter.ConversionOptions{})
+	want := []string{
+		"athenapdf", "-S", "test_file.html",
+		"-H", "Authorization: Bearer test",
+		"--cookie", "session=a; Path=/; Domain=example.com", "--cookie", "token=b; Path=/",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected constructed athenapdf command to be %+v, got %+v", want, got)
+	}
+}
+
 func mockConversion(path string, tmp bool, cmd string) ([]byte, error) {
 	c := AthenaPDF{}
 	c.CMD = cmd
@@ -0,0 +1,220 @@
+package chromium
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// launchTimeout is the maximum time taken by Chromium to start listening.
+const launchTimeout = 30 * time.Second
+
+var (
+	// ErrBrowserClosed is returned when converting with a closed Browser.
+	ErrBrowserClosed = errors.New("chromium: browser closed")
+	// ErrLaunchTimeout is returned when Chromium has not started listening in
+	// time.
+	ErrLaunchTimeout = errors.New("chromium: timed out launching the browser")
+)
+
+// Browser is a long-running headless Chromium shared by the conversions. It is
+// launched on the first conversion, and it is launched again if it crashes.
+// Every conversion uses its own browser context (i.e. incognito profile),
+// which is disposed of afterwards. It is safe for concurrent use.
+type Browser struct {
+	// cmd is the base Chromium command, e.g. 'chromium --headless'.
+	cmd string
+	// url (optional) is the DevTools endpoint of a running Chromium, which is
+	// used instead of launching one.
+	url string
+	// proxy (optional) is the URL of the proxy through which a launched
+	// browser loads the pages, and their resources (see
+	// converter.PolicyProxy).
+	proxy string
+
+	mu     sync.Mutex
+	conn   *conn
+	proc   *exec.Cmd
+	dir    string
+	closed bool
+}
+
+// NewBrowser creates a Browser launched using a command, or connected to the
+// DevTools endpoint of a running Chromium if url is not empty (e.g.
+// 'ws://chromium:9222/devtools/browser/<id>', or 'http://chromium:9222').
+// A launched browser goes through proxy if it is not empty. A running
+// Chromium is used as is.
+func NewBrowser(cmd, url, proxy string) *Browser {
+	return &Browser{cmd: cmd, url: url, proxy: proxy}
+}
+
+// connect returns the connection to the browser, and it launches (or dials)
+// the browser if it is not running.
+func (b *Browser) connect() (*conn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBrowserClosed
+	}
+	if b.conn != nil && b.conn.alive() {
+		return b.conn, nil
+	}
+
+	// The browser has crashed (or it has never been launched)
+	b.stop()
+
+	url := b.url
+	if url == "" {
+		var err error
+		if url, err = b.launch(); err != nil {
+			b.stop()
+			return nil, err
+		}
+	} else if strings.HasPrefix(url, "http") {
+		var err error
+		if url, err = debuggerURL(url); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := dial(url)
+	if err != nil {
+		b.stop()
+		return nil, err
+	}
+	b.conn = c
+	return c, nil
+}
+
+// launch starts Chromium with a temporary profile, and returns its DevTools
+// endpoint. It must be called with mu held.
+func (b *Browser) launch() (string, error) {
+	dir, err := ioutil.TempDir("", "chromium")
+	if err != nil {
+		return "", err
+	}
+	b.dir = dir
+
+	args := strings.Fields(b.cmd)
+	args = append(args,
+		"--remote-debugging-port=0",
+		"--remote-allow-origins=http://localhost",
+		"--user-data-dir="+dir,
+	)
+	if b.proxy != "" {
+		// Chromium does not proxy loopback hosts unless they are removed
+		// from the bypass list
+		args = append(args, "--proxy-server="+b.proxy, "--proxy-bypass-list=<-loopback>")
+	}
+	args = append(args, "about:blank")
+	cmd := exec.Command(args[0], args[1:]...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	b.proc = cmd
+	log.Printf("[Chromium] launched %s (pid %d)\n", args[0], cmd.Process.Pid)
+
+	// Chromium prints its endpoint once it is listening, e.g.
+	// 'DevTools listening on ws://127.0.0.1:36775/devtools/browser/<id>'
+	found := make(chan string, 1)
+	go func() {
+		s := bufio.NewScanner(stderr)
+		for s.Scan() {
+			if i := strings.Index(s.Text(), "ws://"); i >= 0 && strings.HasPrefix(s.Text(), "DevTools listening on") {
+				found <- s.Text()[i:]
+				break
+			}
+		}
+		close(found)
+		// Keep reading so that Chromium does not block on a full pipe
+		io.Copy(ioutil.Discard, stderr)
+		cmd.Wait()
+	}()
+
+	select {
+	case url, ok := <-found:
+		if !ok {
+			return "", errors.New("chromium: the browser exited before listening")
+		}
+		return url, nil
+	case <-time.After(launchTimeout):
+		return "", ErrLaunchTimeout
+	}
+}
+
+// debuggerURL returns the DevTools endpoint of a running Chromium from its
+// HTTP endpoint (e.g. 'http://chromium:9222').
+func debuggerURL(base string) (string, error) {
+	res, err := http.Get(strings.TrimSuffix(base, "/") + "/json/version")
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var v struct {
+		WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return "", err
+	}
+	if v.WebSocketDebuggerURL == "" {
+		return "", errors.New("chromium: no DevTools endpoint at " + base)
+	}
+	return v.WebSocketDebuggerURL, nil
+}
+
+// stop closes the connection, kills the browser (if it has been launched),
+// and removes its profile. It must be called with mu held.
+func (b *Browser) stop() {
+	if b.conn != nil {
+		b.conn.close()
+		b.conn = nil
+	}
+	if b.proc != nil {
+		b.proc.Process.Kill()
+		b.proc = nil
+	}
+	if b.dir != "" {
+		os.RemoveAll(b.dir)
+		b.dir = ""
+	}
+}
+
+// acquire creates a browser context for a conversion.
+func (b *Browser) acquire(c *conn, done <-chan struct{}) (string, error) {
+	var res struct {
+		BrowserContextID string `json:"browserContextId"`
+	}
+	err := c.call("", "Target.createBrowserContext", nil, &res, done)
+	return res.BrowserContextID, err
+}
+
+// release disposes of a browser context, along with its cookies, storage,
+// and cache, so that nothing is shared with the following conversions.
+func (b *Browser) release(c *conn, id string) {
+	params := map[string]interface{}{"browserContextId": id}
+	if err := c.call("", "Target.disposeBrowserContext", params, nil, deadline(cleanupTimeout)); err != nil {
+		log.Println(err)
+	}
+}
+
+// Close kills the browser (if it has been launched). The conversions in
+// progress fail, and the following ones fail with ErrBrowserClosed.
+func (b *Browser) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.stop()
+}
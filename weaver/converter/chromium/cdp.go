@@ -0,0 +1,222 @@
+package chromium
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+var (
+	// ErrTerminated is returned when a conversion is terminated through its
+	// done channel.
+	ErrTerminated = errors.New("chromium: conversion terminated")
+	// ErrClosed is returned when the connection to Chromium has been lost
+	// (e.g. it has crashed, or it has been closed).
+	ErrClosed = errors.New("chromium: connection closed")
+)
+
+// Error is an error returned by Chromium for a DevTools Protocol command.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return "chromium: " + e.Message + " (" + strconv.Itoa(e.Code) + ")"
+}
+
+// request is a DevTools Protocol command.
+type request struct {
+	ID        int64       `json:"id"`
+	SessionID string      `json:"sessionId,omitempty"`
+	Method    string      `json:"method"`
+	Params    interface{} `json:"params,omitempty"`
+}
+
+// message is a DevTools Protocol response (if it has an ID), or event.
+type message struct {
+	ID        int64           `json:"id"`
+	SessionID string          `json:"sessionId"`
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params"`
+	Result    json.RawMessage `json:"result"`
+	Error     *Error          `json:"error"`
+}
+
+// sessionEvents are the events forwarded to the sessions (see subscribe).
+var sessionEvents = map[string]bool{
+	"Page.loadEventFired":     true,
+	"Inspector.targetCrashed": true,
+	"Inspector.detached":      true,
+}
+
+// conn is a connection to the DevTools Protocol endpoint of a browser. It
+// uses flat sessions: the commands sent to a page are multiplexed on the same
+// connection using their session ID.
+type conn struct {
+	ws     *websocket.Conn
+	sendMu sync.Mutex
+
+	mu       sync.Mutex
+	next     int64
+	pending  map[int64]chan message
+	sessions map[string]chan message
+	paused   map[string]func(message)
+	closed   chan struct{}
+}
+
+// dial connects to a DevTools Protocol endpoint (e.g.
+// 'ws://127.0.0.1:9222/devtools/browser/<id>').
+func dial(url string) (*conn, error) {
+	// Chromium only accepts the origins allowed by --remote-allow-origins
+	ws, err := websocket.Dial(url, "", "http://localhost")
+	if err != nil {
+		return nil, err
+	}
+	// The output is read in chunks (see IO.read), but a page may still send
+	// large events
+	ws.MaxPayloadBytes = 64 << 20
+
+	c := &conn{
+		ws:       ws,
+		pending:  make(map[int64]chan message),
+		sessions: make(map[string]chan message),
+		paused:   make(map[string]func(message)),
+		closed:   make(chan struct{}),
+	}
+	go c.read()
+	return c, nil
+}
+
+// read dispatches the responses, and the events until the connection is
+// closed.
+func (c *conn) read() {
+	defer close(c.closed)
+	for {
+		var msg message
+		if err := websocket.JSON.Receive(c.ws, &msg); err != nil {
+			c.ws.Close()
+			return
+		}
+
+		c.mu.Lock()
+		if msg.ID != 0 {
+			if ch, ok := c.pending[msg.ID]; ok {
+				delete(c.pending, msg.ID)
+				ch <- msg
+			}
+		} else if msg.Method == "Target.detachedFromTarget" {
+			var p struct {
+				SessionID string `json:"sessionId"`
+			}
+			json.Unmarshal(msg.Params, &p)
+			msg.Method = "Inspector.detached"
+			c.notify(p.SessionID, msg)
+		} else if msg.Method == "Fetch.requestPaused" {
+			// The request hangs until it is continued, so it is never dropped
+			if h, ok := c.paused[msg.SessionID]; ok {
+				go h(msg)
+			}
+		} else if sessionEvents[msg.Method] {
+			c.notify(msg.SessionID, msg)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// notify forwards an event to a session. It must be called with mu held.
+// The events are only used to wait for a page, so they are dropped rather
+// than blocking the connection if the session is not keeping up.
+func (c *conn) notify(sessionID string, msg message) {
+	if ch, ok := c.sessions[sessionID]; ok {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel receiving the events of a session (see
+// sessionEvents).
+func (c *conn) subscribe(sessionID string) <-chan message {
+	ch := make(chan message, 8)
+	c.mu.Lock()
+	c.sessions[sessionID] = ch
+	c.mu.Unlock()
+	return ch
+}
+
+func (c *conn) unsubscribe(sessionID string) {
+	c.mu.Lock()
+	delete(c.sessions, sessionID)
+	delete(c.paused, sessionID)
+	c.mu.Unlock()
+}
+
+// intercept calls h (in its own goroutine) for every request paused by the
+// Fetch domain in a session. h must continue the request.
+func (c *conn) intercept(sessionID string, h func(message)) {
+	c.mu.Lock()
+	c.paused[sessionID] = h
+	c.mu.Unlock()
+}
+
+// call sends a command (to a session, or to the browser if sessionID is
+// empty), and it decodes its result into v (if not nil). It stops waiting
+// with ErrTerminated when done is closed.
+func (c *conn) call(sessionID, method string, params, v interface{}, done <-chan struct{}) error {
+	ch := make(chan message, 1)
+	c.mu.Lock()
+	c.next++
+	req := request{ID: c.next, SessionID: sessionID, Method: method, Params: params}
+	c.pending[req.ID] = ch
+	c.mu.Unlock()
+
+	c.sendMu.Lock()
+	err := websocket.JSON.Send(c.ws, req)
+	c.sendMu.Unlock()
+	if err != nil {
+		c.forget(req.ID)
+		return err
+	}
+
+	select {
+	case msg := <-ch:
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if v != nil {
+			return json.Unmarshal(msg.Result, v)
+		}
+		return nil
+	case <-c.closed:
+		return ErrClosed
+	case <-done:
+		c.forget(req.ID)
+		return ErrTerminated
+	}
+}
+
+func (c *conn) forget(id int64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// alive returns false once the connection has been closed.
+func (c *conn) alive() bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// close closes the connection.
+func (c *conn) close() error {
+	return c.ws.Close()
+}
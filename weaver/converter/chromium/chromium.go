@@ -0,0 +1,369 @@
+package chromium
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
+)
+
+// cleanupTimeout is the maximum time taken to close a page, and clear its
+// browser context after a conversion.
+const cleanupTimeout = 10 * time.Second
+
+// Capabilities of Chromium: it renders anything it can display, and it
+// supports every option except the zoom factor, and the aggressive content
+// extraction of athenapdf.
+var Capabilities = converter.Capabilities{
+	Options: []string{"page_size", "margins", "orientation", "delay", "no_background"},
+	Headers: true,
+}
+
+// paperSizes are the dimensions (width, height) of the page sizes in inches.
+var paperSizes = map[string][2]float64{
+	"A3":      {11.69, 16.54},
+	"A4":      {8.27, 11.69},
+	"A5":      {5.83, 8.27},
+	"Legal":   {8.5, 14},
+	"Letter":  {8.5, 11},
+	"Tabloid": {11, 17},
+}
+
+// marginSizes are the margins of the margin types in inches. They are close to
+// the margins used by athenapdf (Electron).
+var marginSizes = map[string]float64{
+	"standard": 0.4,
+	"none":     0,
+	"minimal":  0.2,
+}
+
+// Chromium represents a conversion job for a headless Chromium driven through
+// the DevTools Protocol (see Browser).
+// Chromium implements the Converter interface with a custom Convert method.
+type Chromium struct {
+	// Chromium inherits properties from Conversion.
+	// See Conversion for more information.
+	converter.Conversion
+	// Browser used for the conversion. It is shared by the conversions.
+	Browser *Browser
+	// Policy (optional) is checked against the URL of a remote source, and
+	// against every request of the page (e.g. its resources, and
+	// redirects). The requests which are not allowed fail.
+	Policy *converter.URLPolicy
+}
+
+// printParams returns the parameters of Page.printToPDF for the conversion
+// options.
+func printParams(o converter.ConversionOptions) map[string]interface{} {
+	o = o.Normalize()
+	size := paperSizes[o.PageSize]
+	margin := marginSizes[o.Margins]
+	return map[string]interface{}{
+		"landscape":       o.Landscape(),
+		"printBackground": !o.NoBackground,
+		"paperWidth":      size[0],
+		"paperHeight":     size[1],
+		"marginTop":       margin,
+		"marginBottom":    margin,
+		"marginLeft":      margin,
+		"marginRight":     margin,
+		"transferMode":    "ReturnAsStream",
+	}
+}
+
+// pageURL returns the URL of a conversion source (local sources are opened
+// from the file system).
+func pageURL(s converter.ConversionSource) string {
+	if s.IsLocal {
+		p, _ := filepath.Abs(s.URI)
+		return "file://" + filepath.ToSlash(p)
+	}
+	return s.URI
+}
+
+// extraHeaders returns the headers sent with the requests to the origin of
+// the page (see continueParams). Cookie headers are left out: the cookies of
+// the source are set for their domain instead (see cookieParams).
+func extraHeaders(h http.Header) map[string]string {
+	extra := make(map[string]string)
+	for k, v := range h {
+		if http.CanonicalHeaderKey(k) != "Cookie" {
+			extra[k] = strings.Join(v, ", ")
+		}
+	}
+	return extra
+}
+
+// cookieParams returns the parameters of Network.setCookie for a cookie of
+// the page. A cookie without a domain is set for the host of the page.
+func cookieParams(c *http.Cookie, pageURL string) map[string]interface{} {
+	path := c.Path
+	if path == "" {
+		path = "/"
+	}
+	params := map[string]interface{}{
+		"name":  c.Name,
+		"value": c.Value,
+		"url":   pageURL,
+		"path":  path,
+	}
+	if c.Domain != "" {
+		params["domain"] = c.Domain
+	}
+	return params
+}
+
+// origin returns the origin of a URL, e.g. 'https://www.example.com:8443'.
+func origin(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// continueParams returns the parameters of Fetch.continueRequest for a paused
+// request. The extra headers are only added to the requests to the origin of
+// the page, so that credentials are not sent to third-party resources.
+func continueParams(paused json.RawMessage, pageOrigin string, extra map[string]string) map[string]interface{} {
+	var p struct {
+		RequestID string `json:"requestId"`
+		Request   struct {
+			URL     string            `json:"url"`
+			Headers map[string]string `json:"headers"`
+		} `json:"request"`
+	}
+	json.Unmarshal(paused, &p)
+	params := map[string]interface{}{"requestId": p.RequestID}
+	if pageOrigin == "" || origin(p.Request.URL) != pageOrigin {
+		return params
+	}
+
+	h := make(map[string]string)
+	for k, v := range p.Request.Headers {
+		h[http.CanonicalHeaderKey(k)] = v
+	}
+	for k, v := range extra {
+		h[http.CanonicalHeaderKey(k)] = v
+	}
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	headers := make([]map[string]string, 0, len(keys))
+	for _, k := range keys {
+		headers = append(headers, map[string]string{"name": k, "value": h[k]})
+	}
+	params["headers"] = headers
+	return params
+}
+
+// failParams returns the parameters of Fetch.failRequest for a paused request
+// which is not allowed by the policy, or nil if it is allowed. The page itself
+// is always allowed, so that local sources can be opened, but they cannot load
+// other local files.
+func failParams(paused json.RawMessage, pageURL string, policy *converter.URLPolicy) map[string]interface{} {
+	var p struct {
+		RequestID string `json:"requestId"`
+		Request   struct {
+			URL string `json:"url"`
+		} `json:"request"`
+	}
+	json.Unmarshal(paused, &p)
+	if policy == nil || p.Request.URL == pageURL {
+		return nil
+	}
+	if u, err := url.Parse(p.Request.URL); err == nil && policy.CheckURL(u) == nil {
+		return nil
+	}
+	log.Printf("[Chromium] blocked by the URL policy: %s\n", p.Request.URL)
+	return map[string]interface{}{"requestId": p.RequestID, "errorReason": "AccessDenied"}
+}
+
+// deadline returns a channel which is closed after d.
+func deadline(d time.Duration) <-chan struct{} {
+	ch := make(chan struct{})
+	time.AfterFunc(d, func() { close(ch) })
+	return ch
+}
+
+// Convert returns a byte slice containing a PDF converted from HTML
+// using a page of the shared headless Chromium. The page is closed when the
+// done channel is closed.
+// See the Convert method for Conversion for more information.
+func (c Chromium) Convert(s converter.ConversionSource, done <-chan struct{}) ([]byte, error) {
+	log.Printf("[Chromium] 正在转换 PDF: %s\n", s.GetActualURI())
+
+	if !s.IsLocal && c.Policy != nil {
+		u, err := url.Parse(s.URI)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Policy.CheckURL(u); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := c.Browser.connect()
+	if err != nil {
+		return nil, err
+	}
+	contextID, err := c.Browser.acquire(conn, done)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Browser.release(conn, contextID)
+
+	var target struct {
+		TargetID string `json:"targetId"`
+	}
+	err = conn.call("", "Target.createTarget", map[string]interface{}{
+		"url":              "about:blank",
+		"browserContextId": contextID,
+	}, &target, done)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.call("", "Target.closeTarget", map[string]interface{}{"targetId": target.TargetID}, nil, deadline(cleanupTimeout))
+
+	var session struct {
+		SessionID string `json:"sessionId"`
+	}
+	err = conn.call("", "Target.attachToTarget", map[string]interface{}{
+		"targetId": target.TargetID,
+		"flatten":  true,
+	}, &session, done)
+	if err != nil {
+		return nil, err
+	}
+	events := conn.subscribe(session.SessionID)
+	defer conn.unsubscribe(session.SessionID)
+
+	return c.print(conn, session.SessionID, events, s, done)
+}
+
+// print loads a conversion source in a page, and prints it.
+func (c Chromium) print(conn *conn, sessionID string, events <-chan message, s converter.ConversionSource, done <-chan struct{}) ([]byte, error) {
+	u := pageURL(s)
+	call := func(method string, params, v interface{}) error {
+		return conn.call(sessionID, method, params, v, done)
+	}
+
+	var (
+		extra   map[string]string
+		cookies []*http.Cookie
+	)
+	if !s.IsLocal {
+		extra, cookies = extraHeaders(s.Header), s.Cookies
+	}
+
+	// Requests are intercepted to check them against the policy, and to add
+	// the extra headers
+	if len(extra) > 0 || c.Policy != nil {
+		pageOrigin := origin(u)
+		conn.intercept(sessionID, func(e message) {
+			if params := failParams(e.Params, u, c.Policy); params != nil {
+				conn.call(sessionID, "Fetch.failRequest", params, nil, done)
+				return
+			}
+			conn.call(sessionID, "Fetch.continueRequest", continueParams(e.Params, pageOrigin, extra), nil, done)
+		})
+		err := call("Fetch.enable", map[string]interface{}{
+			"patterns": []map[string]string{{"urlPattern": "*", "requestStage": "Request"}},
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, cookie := range cookies {
+		if err := call("Network.setCookie", cookieParams(cookie, u), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := call("Page.enable", nil, nil); err != nil {
+		return nil, err
+	}
+	var nav struct {
+		ErrorText string `json:"errorText"`
+	}
+	if err := call("Page.navigate", map[string]interface{}{"url": u}, &nav); err != nil {
+		return nil, err
+	}
+	if nav.ErrorText != "" {
+		return nil, errors.New("chromium: " + nav.ErrorText)
+	}
+
+	// Wait for the page to load, and for the delay to elapse
+	for loaded := false; !loaded; {
+		select {
+		case e := <-events:
+			switch e.Method {
+			case "Page.loadEventFired":
+				loaded = true
+			case "Inspector.targetCrashed":
+				return nil, errors.New("chromium: the page has crashed")
+			default:
+				return nil, errors.New("chromium: the page has been closed")
+			}
+		case <-conn.closed:
+			return nil, ErrClosed
+		case <-done:
+			return nil, ErrTerminated
+		}
+	}
+	select {
+	case <-time.After(time.Duration(s.Options.Normalize().Delay) * time.Millisecond):
+	case <-done:
+		return nil, ErrTerminated
+	}
+
+	var pdf struct {
+		Stream string `json:"stream"`
+	}
+	if err := call("Page.printToPDF", printParams(s.Options), &pdf); err != nil {
+		return nil, err
+	}
+	return readStream(call, pdf.Stream)
+}
+
+// readStream reads, and closes a stream returned by Chromium (in chunks, so
+// that a large PDF does not have to fit in a single message).
+func readStream(call func(method string, params, v interface{}) error, handle string) ([]byte, error) {
+	defer call("IO.close", map[string]interface{}{"handle": handle}, nil)
+
+	var out []byte
+	for {
+		var chunk struct {
+			Data          string `json:"data"`
+			Base64Encoded bool   `json:"base64Encoded"`
+			EOF           bool   `json:"eof"`
+		}
+		err := call("IO.read", map[string]interface{}{"handle": handle, "size": 1 << 20}, &chunk)
+		if err != nil {
+			return nil, err
+		}
+		if chunk.Base64Encoded {
+			b, err := base64.StdEncoding.DecodeString(chunk.Data)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, b...)
+		} else {
+			out = append(out, chunk.Data...)
+		}
+		if chunk.EOF {
+			return out, nil
+		}
+	}
+}
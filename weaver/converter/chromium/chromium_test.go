@@ -0,0 +1,307 @@
+package chromium
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"golang.org/x/net/websocket"
+)
+
+// mockBrowser is a DevTools Protocol endpoint which records the commands it
+// receives. Pages load instantly unless hang is true. If the Fetch domain is
+// enabled, the navigation requests the page, and a third-party script, and it
+// waits for both requests to be continued.
+type mockBrowser struct {
+	mu        sync.Mutex
+	methods   []string
+	contexts  int
+	hang      bool
+	fetch     bool
+	continued []json.RawMessage
+}
+
+func (b *mockBrowser) serve(ws *websocket.Conn) {
+	// nav is the navigation waiting for its requests to be continued
+	var nav struct {
+		id        int64
+		sessionID string
+		paused    int
+	}
+	for {
+		var req struct {
+			ID        int64           `json:"id"`
+			SessionID string          `json:"sessionId"`
+			Method    string          `json:"method"`
+			Params    json.RawMessage `json:"params"`
+		}
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.methods = append(b.methods, req.Method)
+		var result interface{} = struct{}{}
+		switch req.Method {
+		case "Target.createBrowserContext":
+			b.contexts++
+			result = map[string]string{"browserContextId": "context"}
+		case "Target.createTarget":
+			result = map[string]string{"targetId": "target"}
+		case "Target.attachToTarget":
+			result = map[string]string{"sessionId": "session"}
+		case "Fetch.enable":
+			b.fetch = true
+		case "Fetch.continueRequest":
+			b.continued = append(b.continued, req.Params)
+		case "Page.printToPDF":
+			result = map[string]string{"stream": "stream"}
+		case "IO.read":
+			result = map[string]interface{}{
+				"data":          base64.StdEncoding.EncodeToString([]byte("%PDF-1.4")),
+				"base64Encoded": true,
+				"eof":           true,
+			}
+		}
+		hang, fetch := b.hang, b.fetch
+		b.mu.Unlock()
+
+		if req.Method == "Page.navigate" && fetch {
+			nav.id, nav.sessionID, nav.paused = req.ID, req.SessionID, 2
+			for i, u := range []string{"http://www.example.com/", "http://cdn.example.net/app.js"} {
+				websocket.JSON.Send(ws, map[string]interface{}{
+					"method":    "Fetch.requestPaused",
+					"sessionId": req.SessionID,
+					"params": map[string]interface{}{
+						"requestId": strconv.Itoa(i),
+						"request":   map[string]interface{}{"url": u, "headers": map[string]string{"Accept": "*/*"}},
+					},
+				})
+			}
+			continue
+		}
+		websocket.JSON.Send(ws, map[string]interface{}{"id": req.ID, "result": result})
+		if req.Method == "Fetch.continueRequest" {
+			if nav.paused--; nav.paused > 0 {
+				continue
+			}
+			websocket.JSON.Send(ws, map[string]interface{}{"id": nav.id, "result": struct{}{}})
+			req.Method, req.SessionID = "Page.navigate", nav.sessionID
+		}
+		if req.Method == "Page.navigate" && !hang {
+			websocket.JSON.Send(ws, map[string]interface{}{"method": "Page.loadEventFired", "sessionId": req.SessionID, "params": struct{}{}})
+		}
+	}
+}
+
+func (b *mockBrowser) called(method string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func mockServer(b *mockBrowser) (*httptest.Server, string) {
+	ts := httptest.NewServer(websocket.Handler(b.serve))
+	return ts, "ws://" + strings.TrimPrefix(ts.URL, "http://") + "/devtools/browser/test"
+}
+
+func TestPrintParams(t *testing.T) {
+	o := converter.ConversionOptions{
+		PageSize:     "letter",
+		Margins:      "none",
+		Orientation:  "landscape",
+		NoBackground: true,
+	}
+	p := printParams(o)
+	if got, want := p["paperWidth"], 8.5; got != want {
+		t.Errorf("expected paper width to be %v, got %v", want, got)
+	}
+	if got, want := p["marginTop"], 0.0; got != want {
+		t.Errorf("expected top margin to be %v, got %v", want, got)
+	}
+	if p["landscape"] != true || p["printBackground"] != false {
+		t.Errorf("expected a landscape page without backgrounds, got %+v", p)
+	}
+}
+
+func TestExtraHeaders(t *testing.T) {
+	h := http.Header{
+		"Cookie":        {"session=a; token=b"},
+		"Authorization": {"Bearer test"},
+	}
+	extra := extraHeaders(h)
+	if want := map[string]string{"Authorization": "Bearer test"}; !reflect.DeepEqual(extra, want) {
+		t.Errorf("expected extra headers to be %+v, got %+v", want, extra)
+	}
+}
+
+func TestCookieParams(t *testing.T) {
+	got := cookieParams(&http.Cookie{Name: "session", Value: "a", Domain: "example.com"}, "https://www.example.com/page")
+	want := map[string]interface{}{
+		"name":   "session",
+		"value":  "a",
+		"url":    "https://www.example.com/page",
+		"path":   "/",
+		"domain": "example.com",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected cookie parameters to be %+v, got %+v", want, got)
+	}
+
+	// A cookie without a domain is only set for the host of the page
+	got = cookieParams(&http.Cookie{Name: "session", Value: "a", Path: "/app"}, "https://www.example.com/page")
+	if _, ok := got["domain"]; ok || got["path"] != "/app" {
+		t.Errorf("expected a host-only cookie for /app, got %+v", got)
+	}
+}
+
+func TestContinueParams(t *testing.T) {
+	paused := json.RawMessage(`{"requestId": "1", "request": {"url": "https://www.example.com/page", "headers": {"accept": "*/*"}}}`)
+	extra := map[string]string{"Authorization": "Bearer test"}
+	got := continueParams(paused, "https://www.example.com", extra)
+	want := map[string]interface{}{
+		"requestId": "1",
+		"headers": []map[string]string{
+			{"name": "Accept", "value": "*/*"},
+			{"name": "Authorization", "value": "Bearer test"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected continue parameters to be %+v, got %+v", want, got)
+	}
+
+	// Requests to other origins are continued unchanged
+	for _, o := range []string{"https://cdn.example.com", "http://www.example.com", "https://www.example.com:8443"} {
+		got := continueParams(paused, o, extra)
+		if want := map[string]interface{}{"requestId": "1"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected continue parameters for %s to be %+v, got %+v", o, want, got)
+		}
+	}
+}
+
+func TestFailParams(t *testing.T) {
+	policy := &converter.URLPolicy{}
+	for uri, want := range map[string]bool{
+		"https://www.example.com/page":            false,
+		"file:///tmp/source":                      false,
+		"http://169.254.169.254/latest/meta-data": true,
+		"http://10.0.0.1/":                        true,
+		"file:///etc/passwd":                      true,
+	} {
+		paused := json.RawMessage(`{"requestId": "1", "request": {"url": "` + uri + `"}}`)
+		got := failParams(paused, "file:///tmp/source", policy)
+		if blocked := got != nil; blocked != want {
+			t.Errorf("expected %s to be blocked to be %v, got %v", uri, want, blocked)
+		}
+		if got != nil && got["errorReason"] != "AccessDenied" {
+			t.Errorf("expected error reason to be AccessDenied, got %+v", got["errorReason"])
+		}
+	}
+
+	paused := json.RawMessage(`{"requestId": "1", "request": {"url": "http://10.0.0.1/"}}`)
+	if got := failParams(paused, "https://www.example.com", nil); got != nil {
+		t.Errorf("expected requests to be allowed without a policy, got %+v", got)
+	}
+}
+
+func TestConvert_policy(t *testing.T) {
+	b := NewBrowser("chromium-broken", "", "")
+	defer b.Close()
+	c := Chromium{Browser: b, Policy: &converter.URLPolicy{}}
+	_, err := c.Convert(converter.ConversionSource{URI: "http://169.254.169.254/latest/meta-data"}, make(chan struct{}))
+	if err != converter.ErrURLForbidden {
+		t.Errorf("expected error to be %+v, got %+v", converter.ErrURLForbidden, err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	mb := &mockBrowser{}
+	ts, url := mockServer(mb)
+	defer ts.Close()
+	b := NewBrowser("", url, "")
+	defer b.Close()
+
+	s := converter.ConversionSource{URI: "http://www.example.com", Header: http.Header{"Authorization": {"Bearer test"}}, Cookies: []*http.Cookie{{Name: "session", Value: "a"}}}
+	for i := 0; i < 2; i++ {
+		got, err := Chromium{Browser: b}.Convert(s, make(chan struct{}))
+		if err != nil {
+			t.Fatalf("convert returned an unexpected error: %+v", err)
+		}
+		if want := []byte("%PDF-1.4"); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected output of chromium conversion to be %s, got %s", want, got)
+		}
+	}
+
+	mb.mu.Lock()
+	if mb.contexts != 2 {
+		t.Errorf("expected a browser context per conversion, got %d contexts", mb.contexts)
+	}
+	// The Authorization header is only sent to the origin of the page
+	for _, p := range mb.continued {
+		var params struct {
+			RequestID string `json:"requestId"`
+		}
+		json.Unmarshal(p, &params)
+		if sent := strings.Contains(string(p), "Bearer test"); sent != (params.RequestID == "0") {
+			t.Errorf("expected the Authorization header to be sent to the page only, got %s", p)
+		}
+	}
+	if len(mb.continued) != 4 {
+		t.Errorf("expected 4 requests to be continued, got %d", len(mb.continued))
+	}
+	mb.mu.Unlock()
+	for _, method := range []string{"Network.setCookie", "Target.closeTarget", "Target.disposeBrowserContext"} {
+		if !mb.called(method) {
+			t.Errorf("expected %s to be called", method)
+		}
+	}
+}
+
+func TestConvert_done(t *testing.T) {
+	mb := &mockBrowser{hang: true}
+	ts, url := mockServer(mb)
+	defer ts.Close()
+	b := NewBrowser("", url, "")
+	defer b.Close()
+
+	done := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(done) })
+	got, err := Chromium{Browser: b}.Convert(converter.ConversionSource{URI: "http://www.example.com"}, done)
+	if err != ErrTerminated {
+		t.Fatalf("expected error to be %+v, got %+v", ErrTerminated, err)
+	}
+	if got != nil {
+		t.Errorf("expected output of chromium conversion to be nil, got %s", got)
+	}
+	if !mb.called("Target.closeTarget") {
+		t.Errorf("expected the page to be closed")
+	}
+}
+
+func TestConvert_closed(t *testing.T) {
+	b := NewBrowser("chromium-broken", "", "")
+	b.Close()
+	if _, err := (Chromium{Browser: b}).Convert(converter.ConversionSource{}, make(chan struct{})); err != ErrBrowserClosed {
+		t.Errorf("expected error to be %+v, got %+v", ErrBrowserClosed, err)
+	}
+}
+
+func TestConvert_badCMD(t *testing.T) {
+	b := NewBrowser("chromium-broken --headless", "", "")
+	defer b.Close()
+	if _, err := (Chromium{Browser: b}).Convert(converter.ConversionSource{}, make(chan struct{})); err == nil {
+		t.Fatalf("expected error to be returned")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/arachnys/athenapdf/weaver/converter"
@@ -20,7 +21,7 @@ import (
 
 // CloudConvert 在线转换?
 type CloudConvert struct {
-	converter.UploadConversion
+	converter.Conversion
 	Client
 }
 
@@ -62,10 +63,53 @@ type Conversion struct {
 	Wait         bool   `json:"wait"`
 	Download     string `json:"download,omitempty"`
 	*Output      `json:"output,omitempty"`
+	// ConverterOptions are passed to the underlying converter.
+	ConverterOptions map[string]string `json:"converteroptions,omitempty"`
+}
+
+// Capabilities of CloudConvert: it only converts HTML (the headers of a
+// remote source are not sent), and only the options with an equivalent in
+// converterOptions.
+var Capabilities = converter.Capabilities{
+	MimeTypes: []string{"text/html", "application/xhtml+xml"},
+	Options:   []string{"page_size", "margins", "orientation", "zoom"},
+}
+
+// marginSizes are the margins of the margin types in millimetres. They are
+// the margins used by the wkhtmltopdf converter.
+var marginSizes = map[string]string{
+	"standard": "10",
+	"none":     "0",
+	"minimal":  "5",
+}
+
+// converterOptions maps the conversion options onto their CloudConvert
+// equivalent (where one exists). The page size, and the margins are
+// normalized.
+func converterOptions(o converter.ConversionOptions) map[string]string {
+	opts := make(map[string]string)
+	if o.PageSize != "" {
+		opts["page_size"] = o.Normalize().PageSize
+	}
+	if o.Orientation != "" {
+		opts["page_orientation"] = strings.ToLower(o.Orientation)
+	}
+	if size, ok := marginSizes[o.Normalize().Margins]; ok && o.Margins != "" {
+		for _, side := range []string{"top", "bottom", "left", "right"} {
+			opts["margin_"+side] = size
+		}
+	}
+	if o.Zoom > 0 {
+		opts["zoom"] = strconv.Itoa(o.Zoom)
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	return opts
 }
 
 // QuickConversion 快速转换
-func (c Client) QuickConversion(path string, awsS3 converter.AWSS3, inputFormat string, outputFormat string) ([]byte, error) {
+func (c Client) QuickConversion(path string, inputFormat string, outputFormat string, opts converter.ConversionOptions) ([]byte, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -84,6 +128,9 @@ func (c Client) QuickConversion(path string, awsS3 converter.AWSS3, inputFormat
 		"inputformat":  inputFormat,
 		"outputformat": outputFormat,
 	}
+	for k, v := range converterOptions(opts) {
+		params["converteroptions["+k+"]"] = v
+	}
 
 	part, err := bw.CreateFormFile("file", filepath.Base(path))
 	if err != nil {
@@ -195,13 +242,13 @@ func (p Process) StartConversion(c Conversion) ([]byte, error) {
 }
 
 // Convert 执行转换
+// The output is always downloaded inline. It is uploaded by the worker if the
+// conversion has a destination.
 func (c CloudConvert) Convert(s converter.ConversionSource, done <-chan struct{}) ([]byte, error) {
 	log.Printf("[CloudConvert] converting to PDF: %s\n", s.GetActualURI())
 
-	var b []byte
-
 	if s.IsLocal {
-		b, err := c.Client.QuickConversion(s.URI, c.AWSS3, "html", "pdf")
+		b, err := c.Client.QuickConversion(s.URI, "html", "pdf", s.Options)
 		if err != nil {
 			return nil, err
 		}
@@ -213,54 +260,20 @@ func (c CloudConvert) Convert(s converter.ConversionSource, done <-chan struct{}
 		return nil, err
 	}
 
-	conv := Conversion{
-		Input:        "download",
-		File:         s.URI,
-		Filename:     c.AWSS3.S3Key + ".html",
-		OutputFormat: "pdf",
-		Wait:         true,
-	}
-
 	u, err := uuid.NewV4()
 	if err != nil {
 		return nil, err
 	}
 
-	if c.AWSS3.S3Bucket == "" || c.AWSS3.S3Key == "" {
-		conv.Download = "inline"
-		conv.Filename = u.String() + ".html"
-	} else {
-		conv.Output = &Output{
-			S3{
-				c.AWSS3.AccessKey,
-				c.AWSS3.AccessSecret,
-				c.AWSS3.S3Bucket,
-				c.AWSS3.S3Key,
-				"public-read",
-			},
-		}
-		log.Printf("[CloudConvert] uploading conversion to S3: %s\n", c.AWSS3.S3Key)
-	}
-
-	b, err = p.StartConversion(conv)
-	if err != nil {
-		return nil, err
-	}
-
-	return b, nil
-}
-
-// Upload 云端上传??
-func (c CloudConvert) Upload(b []byte) (bool, error) {
-	if c.AWSS3.S3Bucket == "" || c.AWSS3.S3Key == "" {
-		return false, nil
-	}
-
-	if b != nil {
-		if _, err := c.UploadConversion.UploadAWSS3(b); err != nil {
-			return false, err
-		}
+	conv := Conversion{
+		Input:            "download",
+		File:             s.URI,
+		Filename:         u.String() + ".html",
+		OutputFormat:     "pdf",
+		Wait:             true,
+		Download:         "inline",
+		ConverterOptions: converterOptions(s.Options),
 	}
 
-	return true, nil
+	return p.StartConversion(conv)
 }
@@ -7,6 +7,8 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"testing"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
 )
 
 func TestNewProcess(t *testing.T) {
@@ -93,36 +95,44 @@ func TestStartConversion(t *testing.T) {
 	}
 }
 
-func expectUploadStatus(t *testing.T, c CloudConvert, want bool) {
-	got, err := c.Upload(nil)
-	if err != nil {
-		t.Fatalf("upload returned an unexpected error: %+v", err)
+func TestConverterOptions(t *testing.T) {
+	o := converter.ConversionOptions{PageSize: "A3", Orientation: "Landscape", Margins: "none", Zoom: 2}
+	got := converterOptions(o)
+	want := map[string]string{
+		"page_size":        "A3",
+		"page_orientation": "landscape",
+		"margin_top":       "0",
+		"margin_bottom":    "0",
+		"margin_left":      "0",
+		"margin_right":     "0",
+		"zoom":             "2",
 	}
-	if got != want {
-		t.Errorf("expected status of upload to be %+v, got %+v", want, got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected cloudconvert converter options to be %+v, got %+v", want, got)
 	}
 }
 
-func TestUpload(t *testing.T) {
-	c := CloudConvert{}
-	expectUploadStatus(t, c, false)
-}
-
-func TestUpload_noS3Bucket(t *testing.T) {
-	c := CloudConvert{}
-	c.AWSS3.S3Key = "s3-key-123456"
-	expectUploadStatus(t, c, false)
-}
+func TestConverterOptions_normalized(t *testing.T) {
+	o := converter.ConversionOptions{PageSize: "letter", Margins: "Minimal"}
+	got := converterOptions(o)
+	want := map[string]string{
+		"page_size":     "Letter",
+		"margin_top":    "5",
+		"margin_bottom": "5",
+		"margin_left":   "5",
+		"margin_right":  "5",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected cloudconvert converter options to be %+v, got %+v", want, got)
+	}
 
-func TestUpload_noS3Key(t *testing.T) {
-	c := CloudConvert{}
-	c.AWSS3.S3Bucket = "s3-bucket-123456"
-	expectUploadStatus(t, c, false)
+	if got := converterOptions(converter.ConversionOptions{Margins: "standard"}); got["margin_top"] != "10" {
+		t.Errorf("expected standard top margin to be 10, got %+v", got)
+	}
 }
 
-func TestUpload_s3(t *testing.T) {
-	c := CloudConvert{}
-	c.AWSS3.S3Bucket = "s3-bucket-123456"
-	c.AWSS3.S3Key = "s3-key-123456"
-	expectUploadStatus(t, c, true)
+func TestConverterOptions_empty(t *testing.T) {
+	if got := converterOptions(converter.ConversionOptions{}); got != nil {
+		t.Errorf("expected cloudconvert converter options to be nil, got %+v", got)
+	}
 }
@@ -1,5 +1,9 @@
 package converter
 
+import (
+	"github.com/arachnys/athenapdf/weaver/storage"
+)
+
 // Conversion represents the most basic, skeleton conversion job.
 // Currently, it is assumed that the input is a HTML (or similar) resource,
 // and the output is a PDF.
@@ -15,16 +19,35 @@ func (c Conversion) Convert(s ConversionSource, done <-chan struct{}) ([]byte, e
 	return []byte{}, nil
 }
 
-// UploadAWSS3 should take a byte slice, and return a boolean indicating if the data
-// was used for post-processing, e.g. uploading to a remote host like S3.
-// It should always return false if there is an error.
-func (c Conversion) UploadAWSS3(b []byte) (bool, error) {
-	return false, nil
+// Destination is where the output of a conversion will be stored.
+// The output is returned to the client if no storage backend is set.
+type Destination struct {
+	Storage storage.Storage
+	Key     string
+	// Name of the storage backend in the registry. It is empty for ad hoc
+	// storage backends (e.g. the legacy S3 query parameters).
+	Name string
+}
+
+// UploadError is returned when the output of a conversion could not be
+// stored.
+type UploadError struct {
+	Err error
+}
+
+func (e *UploadError) Error() string {
+	return "upload failed: " + e.Err.Error()
 }
 
-// UploadQiniu should take a byte slice, and return a boolean indicating if the data
-// was used for post-processing, e.g. uploading to a remote host like S3.
-// It should always return false if there is an error.
-func (c Conversion) UploadQiniu(b []byte) (bool, string, error) {
-	return false, "", nil
+// store saves the output of a conversion to the destination, and returns its
+// location.
+func (d Destination) store(b []byte) (string, error) {
+	if err := d.Storage.Put(d.Key, b); err != nil {
+		return "", &UploadError{err}
+	}
+	u, err := d.Storage.URL(d.Key)
+	if err != nil {
+		return "", &UploadError{err}
+	}
+	return u, nil
 }
@@ -0,0 +1,307 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// jobsBucket is the BoltDB bucket containing the persisted jobs.
+	jobsBucket = []byte("jobs")
+	// outputsBucket is the BoltDB bucket containing the outputs of the
+	// persisted jobs, so that they are not rewritten with every update of
+	// their job.
+	outputsBucket = []byte("outputs")
+)
+
+// JobStatus represents the state of an asynchronous conversion job.
+type JobStatus string
+
+// 异步任务状态
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is an asynchronous conversion job. It is created as soon as a job is
+// submitted, and it is updated as the underlying Work progresses.
+type Job struct {
+	ID     string    `json:"id"`
+	Status JobStatus `json:"status"`
+	// Tenant is the tenant of the API key which has submitted the job. The
+	// job is only visible to the same tenant.
+	Tenant string `json:"-"`
+	// Error contains the error message if the job has failed.
+	Error string `json:"error,omitempty"`
+	// Output contains the raw bytes of the conversion if it has succeeded,
+	// and it has not been uploaded.
+	Output []byte `json:"-"`
+	// Location contains the URL of the conversion if it has been uploaded.
+	Location string    `json:"location,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	// Request is the serialised conversion request (opaque to the store). It
+	// is persisted with the job so that it can be resumed after a restart.
+	Request []byte `json:"-"`
+}
+
+// Finished returns true if the job has either succeeded or failed.
+func (j Job) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+// JobStore is an in-memory store of asynchronous conversion jobs.
+// Finished jobs are removed once they are older than the TTL (see Prune).
+// If it has been opened with OpenJobStore, every change is also persisted to
+// a BoltDB file.
+type JobStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+	ttl  time.Duration
+	db   *bolt.DB
+	// dbMu serialises the writes to the file, so that mu is not held while
+	// writing.
+	dbMu sync.Mutex
+}
+
+// NewJobStore creates, and returns a new JobStore.
+// ttl: 已完成任务的保留时间
+func NewJobStore(ttl time.Duration) *JobStore {
+	return &JobStore{jobs: make(map[string]*Job), ttl: ttl}
+}
+
+// OpenJobStore creates, and returns a new JobStore persisted in a BoltDB file
+// (it is created if it does not exist). The jobs saved in the file are
+// loaded, and unfinished ones can be resumed (see Unfinished).
+func OpenJobStore(path string, ttl time.Duration) (*JobStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewJobStore(ttl)
+	s.db = db
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(jobsBucket)
+		if err != nil {
+			return err
+		}
+		outputs, err := tx.CreateBucketIfNotExists(outputsBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			j := new(Job)
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(j); err != nil {
+				return err
+			}
+			if out := outputs.Get(k); out != nil {
+				j.Output = append([]byte(nil), out...)
+			}
+			s.jobs[j.ID] = j
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Persisted returns true if the jobs are persisted to a file.
+func (s *JobStore) Persisted() bool {
+	return s.db != nil
+}
+
+// Close closes the BoltDB file of a persisted store.
+func (s *JobStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	return s.db.Close()
+}
+
+// Create adds a new queued job of a tenant for a serialised conversion
+// request to the store, and returns a copy of it.
+func (s *JobStore) Create(tenant string, request []byte) (Job, error) {
+	u, err := uuid.NewV4()
+	if err != nil {
+		return Job{}, err
+	}
+
+	now := time.Now()
+	j := &Job{ID: u.String(), Status: JobQueued, Tenant: tenant, Created: now, Updated: now, Request: request}
+
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+	if err := s.save(j.ID); err != nil {
+		s.mu.Lock()
+		delete(s.jobs, j.ID)
+		s.mu.Unlock()
+		return Job{}, err
+	}
+	return *j, nil
+}
+
+// Get returns a copy of the job with the given ID, and a boolean indicating
+// if it exists.
+func (s *JobStore) Get(id string) (Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// Update applies fn to the job with the given ID. It does nothing if the job
+// does not exist.
+func (s *JobStore) Update(id string, fn func(*Job)) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	if ok {
+		fn(j)
+		j.Updated = time.Now()
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := s.save(id); err != nil {
+		log.Printf("[Job] unable to save %s: %+v\n", id, err)
+	}
+}
+
+// Unfinished returns a copy of the jobs which have not finished (oldest
+// first).
+func (s *JobStore) Unfinished() []Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var jobs []Job
+	for _, j := range s.jobs {
+		if !j.Finished() {
+			jobs = append(jobs, *j)
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Created.Before(jobs[k].Created)
+	})
+	return jobs
+}
+
+// Len returns the number of jobs currently held in the store.
+func (s *JobStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.jobs)
+}
+
+// Prune removes finished jobs which have expired.
+func (s *JobStore) Prune() {
+	if s.ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	var expired []string
+	s.mu.Lock()
+	for id, j := range s.jobs {
+		if j.Finished() && now.Sub(j.Updated) > s.ttl {
+			delete(s.jobs, id)
+			expired = append(expired, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range expired {
+		if err := s.delete(id); err != nil {
+			log.Printf("[Job] unable to delete %s: %+v\n", id, err)
+		}
+	}
+}
+
+// PruneEvery prunes the store at every interval until done is closed (see
+// Prune).
+func (s *JobStore) PruneEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.Prune()
+		}
+	}
+}
+
+// save persists the current state of a job (if the store is persisted). The
+// output is saved apart from the job. The state is copied once the previous
+// writes have completed, so that the file never goes back to an older state.
+func (s *JobStore) save(id string) error {
+	if s.db == nil {
+		return nil
+	}
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	s.mu.RLock()
+	j, ok := s.jobs[id]
+	var job Job
+	if ok {
+		job = *j
+	}
+	s.mu.RUnlock()
+	// The job has been pruned in the meantime
+	if !ok {
+		return nil
+	}
+
+	out := job.Output
+	job.Output = nil
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(job); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if out != nil {
+			// The output is only set once (when the job has succeeded)
+			outputs := tx.Bucket(outputsBucket)
+			if outputs.Get([]byte(id)) == nil {
+				if err := outputs.Put([]byte(id), out); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Bucket(jobsBucket).Put([]byte(id), b.Bytes())
+	})
+}
+
+// delete removes a persisted job, and its output (if the store is
+// persisted).
+func (s *JobStore) delete(id string) error {
+	if s.db == nil {
+		return nil
+	}
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(outputsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(jobsBucket).Delete([]byte(id))
+	})
+}
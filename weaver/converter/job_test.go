@@ -0,0 +1,175 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestJobStore_Create(t *testing.T) {
+	js := NewJobStore(time.Hour)
+	j, err := js.Create("", nil)
+	if err != nil {
+		t.Fatalf("create returned an unexpected error: %+v", err)
+	}
+	if j.ID == "" {
+		t.Fatalf("expected job ID to be set")
+	}
+	if got, want := j.Status, JobQueued; got != want {
+		t.Errorf("expected status of new job to be %s, got %s", want, got)
+	}
+	if _, ok := js.Get(j.ID); !ok {
+		t.Errorf("expected job %s to be in the store", j.ID)
+	}
+}
+
+func TestJobStore_Get_notFound(t *testing.T) {
+	js := NewJobStore(time.Hour)
+	if _, ok := js.Get("does-not-exist"); ok {
+		t.Errorf("expected job to not be found")
+	}
+}
+
+func TestJobStore_Update(t *testing.T) {
+	js := NewJobStore(time.Hour)
+	j, err := js.Create("", nil)
+	if err != nil {
+		t.Fatalf("create returned an unexpected error: %+v", err)
+	}
+	js.Update(j.ID, func(j *Job) {
+		j.Status = JobSucceeded
+		j.Output = []byte("test output")
+	})
+	got, _ := js.Get(j.ID)
+	if got.Status != JobSucceeded || string(got.Output) != "test output" {
+		t.Errorf("expected job to be updated, got %+v", got)
+	}
+	if !got.Finished() {
+		t.Errorf("expected succeeded job to be finished")
+	}
+}
+
+func TestJobStore_prune(t *testing.T) {
+	js := NewJobStore(time.Millisecond)
+	finished, _ := js.Create("", nil)
+	js.Update(finished.ID, func(j *Job) {
+		j.Status = JobFailed
+	})
+	running, _ := js.Create("", nil)
+	js.Update(running.ID, func(j *Job) {
+		j.Status = JobRunning
+	})
+	time.Sleep(time.Millisecond * 5)
+	js.Create("", nil)
+	js.Prune()
+	if _, ok := js.Get(finished.ID); ok {
+		t.Errorf("expected expired finished job to be removed")
+	}
+	if _, ok := js.Get(running.ID); !ok {
+		t.Errorf("expected running job to be kept")
+	}
+	if got, want := js.Len(), 2; got != want {
+		t.Errorf("expected %d jobs in the store, got %d", want, got)
+	}
+}
+
+func mockJobStorePath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %+v", err)
+	}
+	return filepath.Join(dir, "jobs.db")
+}
+
+func TestOpenJobStore(t *testing.T) {
+	p := mockJobStorePath(t)
+	defer os.RemoveAll(filepath.Dir(p))
+
+	js, err := OpenJobStore(p, time.Hour)
+	if err != nil {
+		t.Fatalf("openjobstore returned an unexpected error: %+v", err)
+	}
+	queued, err := js.Create("", []byte("test request"))
+	if err != nil {
+		t.Fatalf("create returned an unexpected error: %+v", err)
+	}
+	finished, _ := js.Create("", nil)
+	js.Update(finished.ID, func(j *Job) {
+		j.Status = JobSucceeded
+		j.Output = []byte("test output")
+	})
+	if err := js.Close(); err != nil {
+		t.Fatalf("close returned an unexpected error: %+v", err)
+	}
+
+	// The output is not saved in the job record
+	db, err := bolt.Open(p, 0600, nil)
+	if err != nil {
+		t.Fatalf("unable to open jobs file: %+v", err)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		j := new(Job)
+		gob.NewDecoder(bytes.NewReader(tx.Bucket(jobsBucket).Get([]byte(finished.ID)))).Decode(j)
+		if j.Output != nil {
+			t.Errorf("expected output to be saved apart from the job, got %s", j.Output)
+		}
+		if got, want := string(tx.Bucket(outputsBucket).Get([]byte(finished.ID))), "test output"; got != want {
+			t.Errorf("expected saved output to be %s, got %s", want, got)
+		}
+		return nil
+	})
+	db.Close()
+
+	// Reopen the store (e.g. after a restart)
+	js, err = OpenJobStore(p, time.Hour)
+	if err != nil {
+		t.Fatalf("openjobstore returned an unexpected error: %+v", err)
+	}
+	defer js.Close()
+
+	if got, want := js.Len(), 2; got != want {
+		t.Errorf("expected %d jobs in the store, got %d", want, got)
+	}
+	if got, _ := js.Get(finished.ID); string(got.Output) != "test output" {
+		t.Errorf("expected finished job to be persisted, got %+v", got)
+	}
+	unfinished := js.Unfinished()
+	if len(unfinished) != 1 || unfinished[0].ID != queued.ID {
+		t.Fatalf("expected only job %s to be unfinished, got %+v", queued.ID, unfinished)
+	}
+	if got, want := string(unfinished[0].Request), "test request"; got != want {
+		t.Errorf("expected request of unfinished job to be %s, got %s", want, got)
+	}
+}
+
+func TestOpenJobStore_prune(t *testing.T) {
+	p := mockJobStorePath(t)
+	defer os.RemoveAll(filepath.Dir(p))
+
+	js, err := OpenJobStore(p, time.Millisecond)
+	if err != nil {
+		t.Fatalf("openjobstore returned an unexpected error: %+v", err)
+	}
+	finished, _ := js.Create("", nil)
+	js.Update(finished.ID, func(j *Job) {
+		j.Status = JobFailed
+	})
+	time.Sleep(time.Millisecond * 5)
+	js.Prune()
+	js.Close()
+
+	js, err = OpenJobStore(p, time.Millisecond)
+	if err != nil {
+		t.Fatalf("openjobstore returned an unexpected error: %+v", err)
+	}
+	defer js.Close()
+	if _, ok := js.Get(finished.ID); ok {
+		t.Errorf("expected expired finished job to be removed from the file")
+	}
+}
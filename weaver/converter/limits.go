@@ -0,0 +1,70 @@
+package converter
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSourceTooLarge is returned when a conversion source is larger than
+	// the maximum size.
+	ErrSourceTooLarge = errors.New("source is too large")
+	// ErrSourceType is returned when the content type of a conversion source
+	// is not allowed.
+	ErrSourceType = errors.New("source content type is not allowed")
+	// ErrFetchTimeout is returned when a remote conversion source could not be
+	// fetched in time.
+	ErrFetchTimeout = errors.New("timed out fetching the source")
+	// ErrTooManyRedirects is returned when a remote conversion source
+	// redirects more than the maximum number of times.
+	ErrTooManyRedirects = errors.New("source redirected too many times")
+)
+
+// Limits restricts the conversion sources that are accepted, and how they are
+// fetched. The zero value does not restrict anything.
+type Limits struct {
+	// MaxSize is the maximum size (in bytes) of an uploaded file, or a fetched
+	// source. 0 means unlimited.
+	MaxSize int64
+	// FetchTimeout is the maximum time taken to fetch a remote source.
+	// 0 means no timeout.
+	FetchTimeout time.Duration
+	// MaxRedirects is the maximum number of redirects followed when fetching
+	// a remote source. 0 means the default of the HTTP client (10).
+	MaxRedirects int
+	// MimeTypes (optional) is the list of allowed content types, as
+	// determined by readerContentType (e.g. 'text/html', or 'image/*').
+	MimeTypes []string
+}
+
+// AllowedType returns true if a content type is allowed. The parameters
+// (e.g. charset) are ignored.
+func (l Limits) AllowedType(t string) bool {
+	return matchType(l.MimeTypes, t)
+}
+
+// matchType returns true if a content type matches one of the patterns
+// (e.g. 'text/html', or 'image/*'), or if there is no pattern. The
+// parameters (e.g. charset) are ignored.
+func matchType(patterns []string, t string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	t = strings.ToLower(strings.TrimSpace(strings.Split(t, ";")[0]))
+	for _, m := range patterns {
+		m = strings.ToLower(m)
+		if m == t || (strings.HasSuffix(m, "/*") && strings.HasPrefix(t, strings.TrimSuffix(m, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxRedirects returns the maximum number of redirects to follow.
+func (l Limits) maxRedirects() int {
+	if l.MaxRedirects > 0 {
+		return l.MaxRedirects
+	}
+	return 10
+}
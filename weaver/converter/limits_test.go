@@ -0,0 +1,121 @@
+package converter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitsAllowedType(t *testing.T) {
+	l := Limits{MimeTypes: []string{"text/html", "image/*"}}
+	for mime, want := range map[string]bool{
+		"text/html; charset=utf-8": true,
+		"TEXT/HTML":                true,
+		"image/png":                true,
+		"text/plain":               false,
+		"application/pdf":          false,
+	} {
+		if got := l.AllowedType(mime); got != want {
+			t.Errorf("expected allowed type for %s to be %t, got %t", mime, want, got)
+		}
+	}
+
+	if !(Limits{}).AllowedType("application/pdf") {
+		t.Errorf("expected any type to be allowed without a list of MIME types")
+	}
+}
+
+func TestReaderTmpFile_tooLarge(t *testing.T) {
+	mockReader := strings.NewReader("<!DOCTYPE HTML>")
+	_, _, err := readerTmpFile(mockReader, 5)
+	if err != ErrSourceTooLarge {
+		t.Fatalf("expected error to be %+v, got %+v", ErrSourceTooLarge, err)
+	}
+}
+
+func TestRawSource_type(t *testing.T) {
+	s := new(ConversionSource)
+	mockReader := strings.NewReader("<!DOCTYPE HTML>")
+	err := rawSource(s, mockReader, Limits{MimeTypes: []string{"application/pdf"}})
+	if err != ErrSourceType {
+		t.Fatalf("expected error to be %+v, got %+v", ErrSourceType, err)
+	}
+	if s.URI != "" {
+		os.Remove(s.URI)
+		t.Errorf("expected conversion source URI to be empty, got %s", s.URI)
+	}
+}
+
+func TestUriSource_tooLarge(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<!DOCTYPE HTML>"))
+	}))
+	defer ts.Close()
+
+	s := new(ConversionSource)
+	err := uriSource(s, ts.URL, FetchOptions{}, Limits{MaxSize: 5})
+	if err != ErrSourceTooLarge {
+		t.Fatalf("expected error to be %+v, got %+v", ErrSourceTooLarge, err)
+	}
+}
+
+func TestUriSource_tooLargeOctet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		// Flush to send the body without a content length
+		w.Write([]byte("<!DOCTYPE HTML>"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("<html></html>"))
+	}))
+	defer ts.Close()
+
+	s := new(ConversionSource)
+	err := uriSource(s, ts.URL, FetchOptions{}, Limits{MaxSize: 20})
+	if err != ErrSourceTooLarge {
+		t.Fatalf("expected error to be %+v, got %+v", ErrSourceTooLarge, err)
+	}
+}
+
+func TestUriSource_type(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<!DOCTYPE HTML>"))
+	}))
+	defer ts.Close()
+
+	s := new(ConversionSource)
+	err := uriSource(s, ts.URL, FetchOptions{}, Limits{MimeTypes: []string{"image/*"}})
+	if err != ErrSourceType {
+		t.Fatalf("expected error to be %+v, got %+v", ErrSourceType, err)
+	}
+}
+
+func TestUriSource_timeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("<!DOCTYPE HTML>"))
+	}))
+	defer ts.Close()
+
+	s := new(ConversionSource)
+	err := uriSource(s, ts.URL, FetchOptions{}, Limits{FetchTimeout: 10 * time.Millisecond})
+	if err != ErrFetchTimeout {
+		t.Fatalf("expected error to be %+v, got %+v", ErrFetchTimeout, err)
+	}
+}
+
+func TestUriSource_redirects(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, ts.URL, http.StatusFound)
+	}))
+	defer ts.Close()
+
+	s := new(ConversionSource)
+	err := uriSource(s, ts.URL, FetchOptions{}, Limits{MaxRedirects: 2})
+	if err != ErrTooManyRedirects {
+		t.Fatalf("expected error to be %+v, got %+v", ErrTooManyRedirects, err)
+	}
+}
@@ -0,0 +1,168 @@
+package converter
+
+import (
+	"strings"
+)
+
+// Supported values of the conversion options. They match the values
+// accepted by athenapdf CLI (case-insensitive).
+var (
+	PageSizes    = []string{"A3", "A4", "A5", "Legal", "Letter", "Tabloid"}
+	MarginTypes  = []string{"standard", "none", "minimal"}
+	Orientations = []string{"portrait", "landscape"}
+)
+
+// Limits of the numeric conversion options.
+const (
+	MaxZoom  = 10
+	MaxDelay = 60000
+	// MaxTemplateSize is the maximum size (in bytes) of the header, and
+	// footer HTML.
+	MaxTemplateSize = 64 << 10
+)
+
+// OptionError is returned when a conversion option is invalid.
+type OptionError struct {
+	Option string
+}
+
+func (e *OptionError) Error() string {
+	return "invalid " + e.Option + " provided"
+}
+
+// ConversionOptions controls the layout of a conversion. The zero value
+// uses the defaults of the converter.
+type ConversionOptions struct {
+	// PageSize is one of PageSizes.
+	// Defaults to A4.
+	PageSize string `json:"page_size,omitempty"`
+	// Margins is one of MarginTypes.
+	// Defaults to standard.
+	Margins string `json:"margins,omitempty"`
+	// Orientation is one of Orientations.
+	// Defaults to portrait.
+	Orientation string `json:"orientation,omitempty"`
+	// Zoom is the zoom factor (1 represents 100%).
+	// Defaults to 1.
+	Zoom int `json:"zoom,omitempty"`
+	// Delay is the number of milliseconds to wait before saving.
+	// Defaults to 200.
+	Delay int `json:"delay,omitempty"`
+	// NoBackground omits CSS backgrounds.
+	NoBackground bool `json:"no_background,omitempty"`
+	// HeaderHTML is a HTML document printed at the top of every page.
+	HeaderHTML string `json:"header_html,omitempty"`
+	// FooterHTML is a HTML document printed at the bottom of every page.
+	FooterHTML string `json:"footer_html,omitempty"`
+	// TOC prepends a table of contents (generated from the headings).
+	TOC bool `json:"toc,omitempty"`
+}
+
+// oneOf returns true if v is empty or it matches one of the values
+// (case-insensitive).
+func oneOf(v string, values []string) bool {
+	if v == "" {
+		return true
+	}
+	for _, value := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an OptionError for the first invalid option (if any).
+func (o ConversionOptions) Validate() error {
+	if !oneOf(o.PageSize, PageSizes) {
+		return &OptionError{"page_size"}
+	}
+	if !oneOf(o.Margins, MarginTypes) {
+		return &OptionError{"margins"}
+	}
+	if !oneOf(o.Orientation, Orientations) {
+		return &OptionError{"orientation"}
+	}
+	if o.Zoom < 0 || o.Zoom > MaxZoom {
+		return &OptionError{"zoom"}
+	}
+	if o.Delay < 0 || o.Delay > MaxDelay {
+		return &OptionError{"delay"}
+	}
+	if len(o.HeaderHTML) > MaxTemplateSize {
+		return &OptionError{"header_html"}
+	}
+	if len(o.FooterHTML) > MaxTemplateSize {
+		return &OptionError{"footer_html"}
+	}
+	return nil
+}
+
+// Names returns the names of the options which are set (as in the query
+// parameters, e.g. 'page_size').
+func (o ConversionOptions) Names() []string {
+	var names []string
+	if o.PageSize != "" {
+		names = append(names, "page_size")
+	}
+	if o.Margins != "" {
+		names = append(names, "margins")
+	}
+	if o.Orientation != "" {
+		names = append(names, "orientation")
+	}
+	if o.Zoom != 0 {
+		names = append(names, "zoom")
+	}
+	if o.Delay != 0 {
+		names = append(names, "delay")
+	}
+	if o.NoBackground {
+		names = append(names, "no_background")
+	}
+	if o.HeaderHTML != "" {
+		names = append(names, "header_html")
+	}
+	if o.FooterHTML != "" {
+		names = append(names, "footer_html")
+	}
+	if o.TOC {
+		names = append(names, "toc")
+	}
+	return names
+}
+
+// canonical returns the value matching v (case-insensitive) as it is spelled
+// in values, or def if v is empty.
+func canonical(v string, values []string, def string) string {
+	for _, value := range values {
+		if strings.EqualFold(v, value) {
+			return value
+		}
+	}
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// Normalize returns the options with the defaults filled in, and the values
+// spelled as in PageSizes, MarginTypes, and Orientations, so that equivalent
+// options compare equal.
+func (o ConversionOptions) Normalize() ConversionOptions {
+	o.PageSize = canonical(o.PageSize, PageSizes, "A4")
+	o.Margins = canonical(o.Margins, MarginTypes, "standard")
+	o.Orientation = canonical(o.Orientation, Orientations, "portrait")
+	if o.Zoom == 0 {
+		o.Zoom = 1
+	}
+	if o.Delay == 0 {
+		o.Delay = 200
+	}
+	return o
+}
+
+// Landscape returns true if the orientation is landscape.
+func (o ConversionOptions) Landscape() bool {
+	return strings.EqualFold(o.Orientation, "landscape")
+}
@@ -0,0 +1,68 @@
+package converter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConversionOptions_Validate(t *testing.T) {
+	valid := []ConversionOptions{
+		{},
+		{PageSize: "A3", Margins: "none", Orientation: "landscape", Zoom: 2, Delay: 1000, NoBackground: true},
+		{PageSize: "letter", Margins: "MINIMAL", Orientation: "Portrait"},
+	}
+	for _, o := range valid {
+		if err := o.Validate(); err != nil {
+			t.Errorf("expected options %+v to be valid, got %+v", o, err)
+		}
+	}
+}
+
+func TestConversionOptions_Validate_invalid(t *testing.T) {
+	invalid := map[string]ConversionOptions{
+		"page_size":   {PageSize: "B5"},
+		"margins":     {Margins: "wide"},
+		"orientation": {Orientation: "sideways"},
+		"zoom":        {Zoom: MaxZoom + 1},
+		"delay":       {Delay: -1},
+		"header_html": {HeaderHTML: strings.Repeat("a", MaxTemplateSize+1)},
+	}
+	for option, o := range invalid {
+		err := o.Validate()
+		if err == nil {
+			t.Errorf("expected options %+v to be invalid", o)
+			continue
+		}
+		if got, want := err.Error(), "invalid "+option+" provided"; got != want {
+			t.Errorf("expected error to be %s, got %s", want, got)
+		}
+	}
+}
+
+func TestConversionOptions_Landscape(t *testing.T) {
+	if (ConversionOptions{}).Landscape() {
+		t.Errorf("expected default orientation to be portrait")
+	}
+	if !(ConversionOptions{Orientation: "LANDSCAPE"}).Landscape() {
+		t.Errorf("expected orientation to be landscape")
+	}
+}
+
+func TestConversionOptions_Normalize(t *testing.T) {
+	got := ConversionOptions{PageSize: "letter", Orientation: "LANDSCAPE", Zoom: 2}.Normalize()
+	want := ConversionOptions{PageSize: "Letter", Margins: "standard", Orientation: "landscape", Zoom: 2, Delay: 200}
+	if got != want {
+		t.Errorf("expected options to be %+v, got %+v", want, got)
+	}
+	if a, b := (ConversionOptions{}).Normalize(), (ConversionOptions{PageSize: "A4", Delay: 200}).Normalize(); a != b {
+		t.Errorf("expected default options to be equal, got %+v, and %+v", a, b)
+	}
+}
+
+func TestConversionOptions_Names(t *testing.T) {
+	o := ConversionOptions{PageSize: "A4", Delay: 100, FooterHTML: "<p>1</p>", TOC: true}
+	if got, want := o.Names(), []string{"page_size", "delay", "footer_html", "toc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected option names to be %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,175 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrURLForbidden is returned when a URL (or one of its redirects) is not
+// allowed by the URL policy.
+var ErrURLForbidden = errors.New("URL is not allowed")
+
+// privateNetworks contains the IP ranges which are not reachable from the
+// internet (loopback, RFC1918, link-local, multicast, reserved, etc.).
+var privateNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"fec0::/10",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// URLPolicy restricts the remote resources that can be fetched for a
+// conversion (to prevent SSRF).
+type URLPolicy struct {
+	// Schemes allowed.
+	// Defaults to http, and https.
+	Schemes []string
+	// AllowHosts (optional) restricts URLs to these hosts, and their
+	// subdomains.
+	AllowHosts []string
+	// DenyHosts rejects these hosts, and their subdomains.
+	DenyHosts []string
+	// AllowPrivate allows hosts resolving to private IPs (see
+	// privateNetworks).
+	AllowPrivate bool
+}
+
+// matchHost returns true if the host is one of the domains or a subdomain of
+// one of them.
+func matchHost(host string, domains []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL returns ErrURLForbidden if the scheme or the host of a URL is not
+// allowed. Hostnames are resolved (and checked) when they are dialed.
+func (p URLPolicy) CheckURL(u *url.URL) error {
+	schemes := p.Schemes
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	allowed := false
+	for _, s := range schemes {
+		if strings.EqualFold(u.Scheme, s) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return ErrURLForbidden
+	}
+
+	host := u.Hostname()
+	if host == "" || matchHost(host, p.DenyHosts) {
+		return ErrURLForbidden
+	}
+	if len(p.AllowHosts) > 0 && !matchHost(host, p.AllowHosts) {
+		return ErrURLForbidden
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIP(ip)
+	}
+	return nil
+}
+
+// CheckIP returns ErrURLForbidden if the IP is private, and private IPs are
+// not allowed.
+func (p URLPolicy) CheckIP(ip net.IP) error {
+	if p.AllowPrivate {
+		return nil
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return ErrURLForbidden
+		}
+	}
+	return nil
+}
+
+// dialContext resolves the host, and checks every IP before a connection is
+// established, so that DNS records pointing to private IPs are rejected.
+// The connection is made to a checked IP, so a connection made with this
+// dialer cannot be rebound to another IP. It only covers the connections
+// made by Weaver: the renderers resolve hosts themselves, unless they go
+// through a PolicyProxy.
+func (p URLPolicy) dialContext(dialer *net.Dialer) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if err := p.CheckIP(ip.IP); err != nil {
+				return nil, err
+			}
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+	}
+}
+
+// Client returns an HTTP client enforcing the policy on every redirect, and
+// every dialed IP. It does not use the proxy in the environment as it would
+// bypass the IP checks.
+// The client has its own transport, so its connections are not kept alive
+// (they would be leaked once the client is dropped). It does not limit the
+// number of redirects: callers must wrap CheckRedirect to set one.
+func (p URLPolicy) Client(jar http.CookieJar) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Jar: jar,
+		Transport: &http.Transport{
+			DialContext:           p.dialContext(dialer),
+			DisableKeepAlives:     true,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return p.CheckURL(req.URL)
+		},
+	}
+}
@@ -0,0 +1,164 @@
+package converter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestURLPolicyCheckURL(t *testing.T) {
+	p := URLPolicy{
+		DenyHosts: []string{"internal.example.com"},
+	}
+	for uri, want := range map[string]error{
+		"http://example.com":                      nil,
+		"https://www.example.com/path":            nil,
+		"HTTPS://example.com":                     nil,
+		"file:///etc/passwd":                      ErrURLForbidden,
+		"ftp://example.com":                       ErrURLForbidden,
+		"http://internal.example.com":             ErrURLForbidden,
+		"http://a.internal.example.com":           ErrURLForbidden,
+		"http://169.254.169.254/latest/meta-data": ErrURLForbidden,
+		"http://127.0.0.1:8080":                   ErrURLForbidden,
+		"http://10.0.0.1":                         ErrURLForbidden,
+		"http://[::1]":                            ErrURLForbidden,
+		"http://8.8.8.8":                          nil,
+	} {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatalf("failed to parse url: %+v", err)
+		}
+		if got := p.CheckURL(u); got != want {
+			t.Errorf("expected result of checkurl for %s to be %+v, got %+v", uri, want, got)
+		}
+	}
+}
+
+func TestURLPolicyCheckURL_allowHosts(t *testing.T) {
+	p := URLPolicy{
+		Schemes:      []string{"https"},
+		AllowHosts:   []string{"example.com"},
+		AllowPrivate: true,
+	}
+	for uri, want := range map[string]error{
+		"https://example.com":     nil,
+		"https://www.example.com": nil,
+		"http://example.com":      ErrURLForbidden,
+		"https://example.org":     ErrURLForbidden,
+		"https://badexample.com":  ErrURLForbidden,
+	} {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatalf("failed to parse url: %+v", err)
+		}
+		if got := p.CheckURL(u); got != want {
+			t.Errorf("expected result of checkurl for %s to be %+v, got %+v", uri, want, got)
+		}
+	}
+}
+
+func TestURLPolicyCheckIP(t *testing.T) {
+	p := URLPolicy{}
+	for ip, want := range map[string]error{
+		"192.168.1.1":        ErrURLForbidden,
+		"172.31.255.255":     ErrURLForbidden,
+		"::ffff:127.0.0.1":   ErrURLForbidden,
+		"fd00::1":            ErrURLForbidden,
+		"198.18.0.1":         ErrURLForbidden,
+		"192.0.0.170":        ErrURLForbidden,
+		"224.0.0.1":          ErrURLForbidden,
+		"255.255.255.255":    ErrURLForbidden,
+		"64:ff9b::a9fe:a9fe": ErrURLForbidden,
+		"fec0::1":            ErrURLForbidden,
+		"172.32.0.1":         nil,
+		"2001:4860::8888":    nil,
+	} {
+		if got := p.CheckIP(net.ParseIP(ip)); got != want {
+			t.Errorf("expected result of checkip for %s to be %+v, got %+v", ip, want, got)
+		}
+	}
+
+	p.AllowPrivate = true
+	if err := p.CheckIP(net.ParseIP("127.0.0.1")); err != nil {
+		t.Errorf("expected private IPs to be allowed, got %+v", err)
+	}
+}
+
+func TestUriSource_policy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<!DOCTYPE HTML>"))
+	}))
+	defer ts.Close()
+
+	// The mock server is on a loopback IP
+	s := new(ConversionSource)
+	err := uriSource(s, ts.URL, FetchOptions{Policy: &URLPolicy{}}, Limits{})
+	if err != ErrURLForbidden {
+		t.Fatalf("expected error to be %+v, got %+v", ErrURLForbidden, err)
+	}
+
+	err = uriSource(s, ts.URL, FetchOptions{Policy: &URLPolicy{AllowPrivate: true}}, Limits{})
+	if err != nil {
+		t.Fatalf("urisource returned an unexpected error: %+v", err)
+	}
+	expectRemoteConversion(t, s, ts.URL, "text/html; charset=utf-8")
+}
+
+func TestUriSource_policyResolved(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<!DOCTYPE HTML>"))
+	}))
+	defer ts.Close()
+
+	// localhost is only rejected once it has been resolved
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("failed to parse mock server url: %+v", err)
+	}
+	u.Host = "localhost:" + u.Port()
+
+	s := new(ConversionSource)
+	err = uriSource(s, u.String(), FetchOptions{Policy: &URLPolicy{}}, Limits{})
+	if err != ErrURLForbidden {
+		t.Fatalf("expected error to be %+v, got %+v", ErrURLForbidden, err)
+	}
+}
+
+func TestUriSource_policyRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.RedirectHandler("file:///etc/passwd", http.StatusFound))
+	defer ts.Close()
+
+	s := new(ConversionSource)
+	p := &URLPolicy{AllowPrivate: true}
+	err := uriSource(s, ts.URL, FetchOptions{Policy: p}, Limits{})
+	if err != ErrURLForbidden {
+		t.Fatalf("expected error to be %+v, got %+v", ErrURLForbidden, err)
+	}
+}
+
+func TestUriSource_policyRedirects(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, ts.URL, http.StatusFound)
+	}))
+	defer ts.Close()
+
+	// The redirects are limited by the limits, not by the policy
+	s := new(ConversionSource)
+	err := uriSource(s, ts.URL, FetchOptions{Policy: &URLPolicy{AllowPrivate: true}}, Limits{MaxRedirects: 12})
+	if err != ErrTooManyRedirects {
+		t.Fatalf("expected error to be %+v, got %+v", ErrTooManyRedirects, err)
+	}
+}
+
+func TestNewConversionSource_policy(t *testing.T) {
+	s, err := NewConversionSource("file:///etc/passwd", FetchOptions{Policy: &URLPolicy{}}, Limits{}, "", nil)
+	if err != ErrURLForbidden {
+		t.Fatalf("expected error to be %+v, got %+v", ErrURLForbidden, err)
+	}
+	if s != nil {
+		t.Fatalf("expected result of newconversionsource to be nil, got %+v", s)
+	}
+}
@@ -0,0 +1,175 @@
+package converter
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// hopHeaders are the hop-by-hop headers, which are not forwarded by the
+// proxy.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// PolicyProxy is a forward HTTP proxy enforcing a URL policy. The renderers
+// (athenapdf, Chromium, and wkhtmltopdf) resolve, and fetch pages, their
+// redirects, and their resources themselves, so they are routed through it:
+// every request is checked, and every connection is made to a checked IP.
+// HTTPS (and WebSocket) requests are tunnelled with CONNECT.
+type PolicyProxy struct {
+	policy    URLPolicy
+	dialer    *net.Dialer
+	transport *http.Transport
+	ln        net.Listener
+	srv       *http.Server
+}
+
+// ListenPolicyProxy starts a PolicyProxy on a random port of the loopback
+// interface.
+func ListenPolicyProxy(p URLPolicy) (*PolicyProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	pp := &PolicyProxy{
+		policy: p,
+		dialer: dialer,
+		transport: &http.Transport{
+			DialContext:           p.dialContext(dialer),
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		ln: ln,
+	}
+	pp.srv = &http.Server{Handler: pp}
+	go pp.srv.Serve(ln)
+	return pp, nil
+}
+
+// URL returns the URL of the proxy, e.g. 'http://127.0.0.1:36775'.
+func (pp *PolicyProxy) URL() string {
+	return "http://" + pp.ln.Addr().String()
+}
+
+// Close stops the proxy.
+func (pp *PolicyProxy) Close() error {
+	pp.transport.CloseIdleConnections()
+	return pp.srv.Close()
+}
+
+// ServeHTTP forwards a request if it is allowed by the policy. Redirects are
+// not followed: they are returned to the renderer, which requests them again
+// through the proxy.
+func (pp *PolicyProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		pp.tunnel(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "proxy: absolute URL required", http.StatusBadRequest)
+		return
+	}
+	if err := pp.policy.CheckURL(r.URL); err != nil {
+		pp.forbid(w, r.URL.String())
+		return
+	}
+
+	out := r.WithContext(r.Context())
+	out.RequestURI = ""
+	out.Header = make(http.Header, len(r.Header))
+	for k, v := range r.Header {
+		out.Header[k] = v
+	}
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+
+	res, err := pp.transport.RoundTrip(out)
+	if err == ErrURLForbidden {
+		pp.forbid(w, r.URL.String())
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	for _, h := range hopHeaders {
+		res.Header.Del(h)
+	}
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
+
+// tunnel connects a CONNECT request to its (checked) host, and copies the
+// bytes both ways until either side closes.
+func (pp *PolicyProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	u := &url.URL{Scheme: "https", Host: r.Host}
+	if err := pp.policy.CheckURL(u); err != nil {
+		pp.forbid(w, u.String())
+		return
+	}
+	upstream, err := pp.policy.dialContext(pp.dialer)(r.Context(), "tcp", r.Host)
+	if err == ErrURLForbidden {
+		pp.forbid(w, u.String())
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "proxy: hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+	var once sync.Once
+	closeBoth := func() {
+		client.Close()
+		upstream.Close()
+	}
+	go func() {
+		// The client may already have sent the start of the TLS handshake
+		io.Copy(upstream, buf)
+		once.Do(closeBoth)
+	}()
+	io.Copy(client, upstream)
+	once.Do(closeBoth)
+}
+
+func (pp *PolicyProxy) forbid(w http.ResponseWriter, uri string) {
+	log.Printf("[Proxy] blocked by the URL policy: %s\n", uri)
+	http.Error(w, ErrURLForbidden.Error(), http.StatusForbidden)
+}
@@ -0,0 +1,87 @@
+package converter
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// proxyClient returns a client of the test server ts going through the proxy.
+func proxyClient(ts *httptest.Server, pp *PolicyProxy) *http.Client {
+	u, _ := url.Parse(pp.URL())
+	tr := ts.Client().Transport.(*http.Transport)
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(u),
+			TLSClientConfig: tr.TLSClientConfig,
+		},
+	}
+}
+
+func TestPolicyProxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Connection") != "" {
+			t.Errorf("expected hop-by-hop headers not to be forwarded")
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	tls := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer tls.Close()
+
+	for _, allowPrivate := range []bool{false, true} {
+		pp, err := ListenPolicyProxy(URLPolicy{AllowPrivate: allowPrivate})
+		if err != nil {
+			t.Fatalf("listenpolicyproxy returned an unexpected error: %+v", err)
+		}
+
+		for _, s := range []*httptest.Server{ts, tls} {
+			res, err := proxyClient(s, pp).Get(s.URL)
+			if !allowPrivate {
+				// The test servers listen on a loopback IP
+				if err == nil && res.StatusCode != http.StatusForbidden {
+					t.Errorf("expected %s to be blocked, got %d", s.URL, res.StatusCode)
+				}
+				if err == nil {
+					res.Body.Close()
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("get %s returned an unexpected error: %+v", s.URL, err)
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if got := string(body); got != "ok" {
+				t.Errorf("expected body of %s to be ok, got %s", s.URL, got)
+			}
+		}
+		pp.Close()
+	}
+}
+
+func TestPolicyProxy_checkURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected %s not to be requested", r.URL)
+	}))
+	defer ts.Close()
+
+	pp, err := ListenPolicyProxy(URLPolicy{AllowPrivate: true, DenyHosts: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("listenpolicyproxy returned an unexpected error: %+v", err)
+	}
+	defer pp.Close()
+
+	res, err := proxyClient(ts, pp).Get(ts.URL)
+	if err != nil {
+		t.Fatalf("get returned an unexpected error: %+v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status code to be %d, got %d", http.StatusForbidden, res.StatusCode)
+	}
+}
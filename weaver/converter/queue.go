@@ -0,0 +1,382 @@
+package converter
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errQueueClosed is returned by NewWork if the work queue has been closed.
+var errQueueClosed = errors.New("work queue is closed")
+
+// Priority is the scheduling class of a work.
+type Priority string
+
+// 优先级
+const (
+	// PriorityInteractive is for conversions that a client is waiting for.
+	PriorityInteractive Priority = "interactive"
+	// PriorityBulk is for batches of conversions (e.g. asynchronous jobs).
+	PriorityBulk Priority = "bulk"
+)
+
+// Priorities contains the valid priorities (highest first).
+var Priorities = []Priority{PriorityInteractive, PriorityBulk}
+
+// ValidPriority returns true if p is one of Priorities, or empty (the
+// default priority).
+func ValidPriority(p Priority) bool {
+	if p == "" {
+		return true
+	}
+	for _, v := range Priorities {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Class identifies who a work belongs to, and how urgent it is. It is used
+// for scheduling the works in a WorkQueue.
+type Class struct {
+	Tenant string `json:"tenant,omitempty"`
+	// Priority defaults to PriorityInteractive.
+	Priority Priority `json:"priority,omitempty"`
+}
+
+// SchedulerOptions configure how the workers are shared between the
+// priorities, and the tenants of a WorkQueue.
+type SchedulerOptions struct {
+	// PriorityWeights are the relative shares of the workers given to each
+	// priority when works of several priorities are waiting.
+	// Defaults to 4 for interactive, and 1 for bulk.
+	PriorityWeights map[Priority]int
+	// TenantWeights are the relative shares of the workers given to each
+	// tenant when works of several tenants are waiting.
+	// Defaults to 1.
+	TenantWeights map[string]int
+	// TenantMaxWorkers is the maximum number of works of a tenant that can be
+	// running at once. The "*" key applies to any other tenant.
+	// Defaults to no limit.
+	TenantMaxWorkers map[string]int
+	// TenantMaxQueued is the maximum number of pending works of a tenant, so
+	// that a tenant cannot take all the slots of the queue. The "*" key
+	// applies to any other tenant.
+	// Defaults to no limit.
+	TenantMaxQueued map[string]int
+}
+
+func (o SchedulerOptions) priorityWeight(p Priority) int {
+	if w := o.PriorityWeights[p]; w > 0 {
+		return w
+	}
+	if p == PriorityInteractive {
+		return 4
+	}
+	return 1
+}
+
+func (o SchedulerOptions) tenantWeight(t string) int {
+	if w := o.TenantWeights[t]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (o SchedulerOptions) tenantMaxWorkers(t string) int {
+	if n, ok := o.TenantMaxWorkers[t]; ok {
+		return n
+	}
+	return o.TenantMaxWorkers["*"]
+}
+
+func (o SchedulerOptions) tenantMaxQueued(t string) int {
+	if n, ok := o.TenantMaxQueued[t]; ok {
+		return n
+	}
+	return o.TenantMaxQueued["*"]
+}
+
+// WorkQueue holds the works waiting for a worker. Instead of running them in
+// order, it shares the workers fairly between the priorities, and the tenants
+// of the works (weighted by SchedulerOptions), using stride scheduling: the
+// priority, and the tenant which have received the smallest share so far go
+// first.
+type WorkQueue struct {
+	size int
+	o    SchedulerOptions
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// freed is closed (and replaced) every time a work leaves the queue.
+	freed  chan struct{}
+	closed bool
+
+	// pending works by priority, and tenant (in order of arrival).
+	pending map[Priority]map[string][]Work
+	len     int
+	// tenantLen is the number of pending works of a tenant.
+	tenantLen map[string]int
+	running   map[string]int
+
+	// pass is the virtual time of a priority or tenant. It is increased by
+	// 1/weight every time one of its works is scheduled.
+	priorityPass  map[Priority]float64
+	tenantPass    map[string]float64
+	priorityClock float64
+	tenantClock   float64
+}
+
+// NewWorkQueue creates, and returns a new WorkQueue which can hold up to size
+// pending works.
+func NewWorkQueue(size int, o SchedulerOptions) *WorkQueue {
+	q := &WorkQueue{
+		size:         size,
+		o:            o,
+		freed:        make(chan struct{}),
+		pending:      make(map[Priority]map[string][]Work),
+		tenantLen:    make(map[string]int),
+		running:      make(map[string]int),
+		priorityPass: make(map[Priority]float64),
+		tenantPass:   make(map[string]float64),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	for _, p := range Priorities {
+		q.pending[p] = make(map[string][]Work)
+	}
+	return q
+}
+
+// Len returns the number of pending works.
+func (q *WorkQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.len
+}
+
+// Cap returns the maximum number of pending works.
+func (q *WorkQueue) Cap() int {
+	return q.size
+}
+
+// Freed returns a channel that will be closed when a work leaves the queue
+// (i.e. when a slot may have been freed).
+func (q *WorkQueue) Freed() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.freed
+}
+
+// Close stops the workers once they have finished their current work.
+func (q *WorkQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// push adds a work to the queue. If the queue is full (or the tenant of the
+// work has reached its limit of pending works), it waits for up to wait for a
+// free slot, and it returns ErrQueueFull if there is none.
+func (q *WorkQueue) push(w Work, wait time.Duration) error {
+	var timeout <-chan time.Time
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return errQueueClosed
+		}
+		if q.len < q.size && !q.tenantFull(w.class.Tenant) {
+			q.add(w)
+			q.mu.Unlock()
+			return nil
+		}
+		freed := q.freed
+		q.mu.Unlock()
+
+		if wait <= 0 {
+			return ErrQueueFull
+		}
+		if timeout == nil {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-freed:
+		case <-timeout:
+			return ErrQueueFull
+		}
+	}
+}
+
+// tenantFull returns true if a tenant has reached its limit of pending works.
+// The caller must hold the lock.
+func (q *WorkQueue) tenantFull(t string) bool {
+	max := q.o.tenantMaxQueued(t)
+	return max > 0 && q.tenantLen[t] >= max
+}
+
+// add adds a work to its pending queue. The caller must hold the lock.
+func (q *WorkQueue) add(w Work) {
+	p, t := w.class.Priority, w.class.Tenant
+
+	// A priority, or a tenant which has been idle does not accumulate credit
+	if !q.hasPriority(p) && q.priorityPass[p] < q.priorityClock {
+		q.priorityPass[p] = q.priorityClock
+	}
+	if q.tenantLen[t] == 0 && q.tenantPass[t] < q.tenantClock {
+		q.tenantPass[t] = q.tenantClock
+	}
+
+	q.pending[p][t] = append(q.pending[p][t], w)
+	q.tenantLen[t]++
+	q.len++
+	q.cond.Signal()
+}
+
+// hasPriority returns true if there are pending works of a priority. The
+// caller must hold the lock.
+func (q *WorkQueue) hasPriority(p Priority) bool {
+	for _, works := range q.pending[p] {
+		if len(works) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// pop blocks until a work can be scheduled, and removes it from the queue.
+// It returns false if the queue has been closed.
+func (q *WorkQueue) pop() (Work, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return Work{}, false
+		}
+		if w, ok := q.next(); ok {
+			return w, true
+		}
+		q.cond.Wait()
+	}
+}
+
+// next removes the next work to schedule. It returns false if there is no
+// pending work (or if all the tenants with pending works are at their
+// limit). The caller must hold the lock.
+func (q *WorkQueue) next() (Work, bool) {
+	var (
+		p       Priority
+		tenants []string
+	)
+	for _, v := range Priorities {
+		eligible := q.eligible(v)
+		if len(eligible) == 0 {
+			continue
+		}
+		if tenants == nil || q.priorityPass[v] < q.priorityPass[p] {
+			p, tenants = v, eligible
+		}
+	}
+	if tenants == nil {
+		return Work{}, false
+	}
+
+	t := tenants[0]
+	for _, v := range tenants[1:] {
+		if q.tenantPass[v] < q.tenantPass[t] {
+			t = v
+		}
+	}
+
+	q.priorityClock = q.priorityPass[p]
+	q.priorityPass[p] += 1 / float64(q.o.priorityWeight(p))
+	q.tenantClock = q.tenantPass[t]
+	q.tenantPass[t] += 1 / float64(q.o.tenantWeight(t))
+
+	works := q.pending[p][t]
+	w := works[0]
+	if len(works) == 1 {
+		delete(q.pending[p], t)
+	} else {
+		q.pending[p][t] = works[1:]
+	}
+	q.tenantLen[t]--
+	if q.tenantLen[t] == 0 {
+		delete(q.tenantLen, t)
+	}
+	q.len--
+	q.running[t]++
+
+	close(q.freed)
+	q.freed = make(chan struct{})
+	return w, true
+}
+
+// remove removes a pending work from the queue (e.g. if it has been
+// cancelled). It returns false if the work is not pending.
+func (q *WorkQueue) remove(w Work) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p, t := w.class.Priority, w.class.Tenant
+	works := q.pending[p][t]
+	for i, v := range works {
+		if v.done != w.done {
+			continue
+		}
+		if len(works) == 1 {
+			delete(q.pending[p], t)
+		} else {
+			q.pending[p][t] = append(works[:i:i], works[i+1:]...)
+		}
+		q.tenantLen[t]--
+		if q.tenantLen[t] == 0 {
+			delete(q.tenantLen, t)
+			if q.running[t] == 0 {
+				delete(q.tenantPass, t)
+			}
+		}
+		q.len--
+
+		close(q.freed)
+		q.freed = make(chan struct{})
+		return true
+	}
+	return false
+}
+
+// eligible returns the tenants with pending works of a priority which are
+// below their limit of running works (sorted). The caller must hold the lock.
+func (q *WorkQueue) eligible(p Priority) []string {
+	var tenants []string
+	for t, works := range q.pending[p] {
+		if len(works) == 0 {
+			continue
+		}
+		if max := q.o.tenantMaxWorkers(t); max > 0 && q.running[t] >= max {
+			continue
+		}
+		tenants = append(tenants, t)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// done records that a work (returned by pop) has finished.
+func (q *WorkQueue) done(w Work) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t := w.class.Tenant
+	q.running[t]--
+	if q.running[t] <= 0 {
+		delete(q.running, t)
+		if q.tenantLen[t] == 0 {
+			delete(q.tenantPass, t)
+		}
+	}
+	// A tenant may be below its limit again
+	q.cond.Broadcast()
+}
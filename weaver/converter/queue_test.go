@@ -0,0 +1,192 @@
+package converter
+
+import (
+	"testing"
+	"time"
+)
+
+func mockQueue(t *testing.T, q *WorkQueue, cl Class, n int) {
+	for i := 0; i < n; i++ {
+		if err := q.push(Work{class: cl}, 0); err != nil {
+			t.Fatalf("push returned an unexpected error: %+v", err)
+		}
+	}
+}
+
+// mockNext returns the classes of the next n works, without blocking.
+func mockNext(q *WorkQueue, n int) map[Class]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	got := make(map[Class]int)
+	for i := 0; i < n; i++ {
+		w, ok := q.next()
+		if !ok {
+			break
+		}
+		got[w.class]++
+	}
+	return got
+}
+
+// mockPop removes the next work, without blocking.
+func mockPop(q *WorkQueue) (Work, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.next()
+}
+
+func TestWorkQueue_priority(t *testing.T) {
+	q := NewWorkQueue(20, SchedulerOptions{})
+	interactive := Class{Priority: PriorityInteractive}
+	bulk := Class{Priority: PriorityBulk}
+	mockQueue(t, q, bulk, 10)
+	mockQueue(t, q, interactive, 10)
+
+	got := mockNext(q, 10)
+	if got[interactive] != 8 || got[bulk] != 2 {
+		t.Errorf("expected 8 interactive, and 2 bulk works to be scheduled, got %+v", got)
+	}
+	if got, want := q.Len(), 10; got != want {
+		t.Errorf("expected %d pending works, got %d", want, got)
+	}
+}
+
+func TestWorkQueue_tenantWeights(t *testing.T) {
+	q := NewWorkQueue(20, SchedulerOptions{TenantWeights: map[string]int{"a": 2}})
+	a := Class{Tenant: "a", Priority: PriorityBulk}
+	b := Class{Tenant: "b", Priority: PriorityBulk}
+	mockQueue(t, q, b, 6)
+	mockQueue(t, q, a, 6)
+
+	got := mockNext(q, 6)
+	if got[a] != 4 || got[b] != 2 {
+		t.Errorf("expected 4 works of a, and 2 works of b to be scheduled, got %+v", got)
+	}
+}
+
+func TestWorkQueue_idleTenant(t *testing.T) {
+	q := NewWorkQueue(20, SchedulerOptions{})
+	a := Class{Tenant: "a", Priority: PriorityBulk}
+	b := Class{Tenant: "b", Priority: PriorityBulk}
+	mockQueue(t, q, a, 10)
+	mockNext(q, 7)
+
+	// b does not get all the workers to catch up with a
+	mockQueue(t, q, b, 3)
+	got := mockNext(q, 4)
+	if got[a] != 2 || got[b] != 2 {
+		t.Errorf("expected 2 works of a, and 2 works of b to be scheduled, got %+v", got)
+	}
+}
+
+func TestWorkQueue_tenantMaxWorkers(t *testing.T) {
+	q := NewWorkQueue(20, SchedulerOptions{TenantMaxWorkers: map[string]int{"*": 1}})
+	a := Class{Tenant: "a", Priority: PriorityInteractive}
+	b := Class{Tenant: "b", Priority: PriorityBulk}
+	mockQueue(t, q, a, 2)
+	mockQueue(t, q, b, 1)
+
+	first, _ := q.pop()
+	if first.class != a {
+		t.Fatalf("expected a work of a to be scheduled, got %+v", first.class)
+	}
+	// a is at its limit
+	if w, _ := q.pop(); w.class != b {
+		t.Fatalf("expected a work of b to be scheduled, got %+v", w.class)
+	}
+
+	popped := make(chan Work, 1)
+	go func() {
+		w, _ := q.pop()
+		popped <- w
+	}()
+	select {
+	case <-popped:
+		t.Fatalf("expected the work of a to wait for a free worker")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	q.done(first)
+	select {
+	case w := <-popped:
+		if w.class != a {
+			t.Errorf("expected a work of a to be scheduled, got %+v", w.class)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the work of a to be scheduled before timeout")
+	}
+}
+
+func TestWorkQueue_tenantMaxQueued(t *testing.T) {
+	q := NewWorkQueue(20, SchedulerOptions{TenantMaxQueued: map[string]int{"*": 2, "b": 3}})
+	a := Class{Tenant: "a", Priority: PriorityBulk}
+	b := Class{Tenant: "b", Priority: PriorityBulk}
+	mockQueue(t, q, a, 2)
+	mockQueue(t, q, b, 3)
+
+	// a is at its limit, but the queue still has slots for other tenants
+	if err := q.push(Work{class: a}, 0); err != ErrQueueFull {
+		t.Errorf("expected error to be %+v, got %+v", ErrQueueFull, err)
+	}
+	mockQueue(t, q, Class{Tenant: "c", Priority: PriorityBulk}, 1)
+
+	// A work of a leaves the queue
+	mockNext(q, 1)
+	if err := q.push(Work{class: a}, 0); err != nil {
+		t.Errorf("push returned an unexpected error: %+v", err)
+	}
+}
+
+func TestWorkQueue_cancel(t *testing.T) {
+	q := NewWorkQueue(2, SchedulerOptions{})
+	cl := Class{Tenant: "a", Priority: PriorityBulk}
+	var works []Work
+	for i := 0; i < 2; i++ {
+		w, err := NewWork(q, nil, ConversionSource{}, Destination{}, cl, 0)
+		if err != nil {
+			t.Fatalf("newwork returned an unexpected error: %+v", err)
+		}
+		works = append(works, w)
+	}
+	if _, err := NewWork(q, nil, ConversionSource{}, Destination{}, cl, 0); err != ErrQueueFull {
+		t.Fatalf("expected error to be %+v, got %+v", ErrQueueFull, err)
+	}
+
+	// A cancelled work frees its slot
+	freed := q.Freed()
+	works[0].Cancel()
+	select {
+	case <-freed:
+	default:
+		t.Errorf("expected freed to be closed")
+	}
+	if got, want := q.Len(), 1; got != want {
+		t.Errorf("expected queue length to be %d, got %d", want, got)
+	}
+	w, err := NewWork(q, nil, ConversionSource{}, Destination{}, cl, 0)
+	if err != nil {
+		t.Fatalf("newwork returned an unexpected error: %+v", err)
+	}
+
+	// The cancelled work is never scheduled
+	for _, want := range []Work{works[1], w} {
+		got, ok := mockPop(q)
+		if !ok || got.done != want.done {
+			t.Errorf("expected the pending works to be scheduled in order")
+		}
+	}
+	if _, ok := mockPop(q); ok {
+		t.Errorf("expected no pending work")
+	}
+}
+
+func TestWorkQueue_close(t *testing.T) {
+	q := NewWorkQueue(1, SchedulerOptions{})
+	q.Close()
+	if _, ok := q.pop(); ok {
+		t.Errorf("expected no work from a closed queue")
+	}
+	if err := q.push(Work{}, 0); err != errQueueClosed {
+		t.Errorf("expected error to be %+v, got %+v", errQueueClosed, err)
+	}
+}
@@ -0,0 +1,132 @@
+package converter
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrUnknownConverter should be returned when a converter has not been
+	// registered.
+	ErrUnknownConverter = errors.New("unknown converter")
+	// ErrNoConverter should be returned when none of the converters can
+	// convert a source.
+	ErrNoConverter = errors.New("no converter supports this conversion")
+)
+
+// CapabilityError is returned when a converter does not support a source, or
+// one of its options.
+type CapabilityError struct {
+	Converter string
+	// Feature is the content type, or the option which is not supported.
+	Feature string
+}
+
+func (e *CapabilityError) Error() string {
+	return e.Converter + " does not support " + e.Feature
+}
+
+// Capabilities describe what a converter can convert.
+type Capabilities struct {
+	// MimeTypes of the sources it can convert (e.g. 'text/html', or
+	// 'image/*').
+	// Defaults to any content type.
+	MimeTypes []string
+	// Options it supports, by name (see ConversionOptions.Names). The
+	// 'aggressive' option stands for the aggressive content extraction.
+	Options []string
+	// Headers should be true if it sends the headers (and cookies) of a
+	// remote source when fetching it.
+	Headers bool
+}
+
+// Factory creates a Converter for a single conversion.
+type Factory func(aggressive bool) Converter
+
+// Backend is a converter, and its capabilities.
+type Backend struct {
+	New          Factory
+	Capabilities Capabilities
+}
+
+// Supports returns a CapabilityError if the backend cannot convert a source.
+func (b Backend) Supports(name string, s ConversionSource, aggressive bool) error {
+	if !matchType(b.Capabilities.MimeTypes, s.Mime) {
+		return &CapabilityError{name, "content type " + s.Mime}
+	}
+	if !s.IsLocal && (len(s.Header) > 0 || len(s.Cookies) > 0) && !b.Capabilities.Headers {
+		return &CapabilityError{name, "headers, or cookies"}
+	}
+	options := s.Options.Names()
+	if aggressive {
+		options = append(options, "aggressive")
+	}
+	for _, o := range options {
+		if !contains(b.Capabilities.Options, o) {
+			return &CapabilityError{name, "the " + o + " option"}
+		}
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry contains named converters. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	backends map[string]Backend
+}
+
+// NewRegistry creates, and returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{backends: make(map[string]Backend)}
+}
+
+// Register adds a converter under a name, replacing any existing converter
+// with the same name.
+func (r *Registry) Register(name string, b Backend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backends[name] = b
+}
+
+// Get returns the converter registered under a name.
+func (r *Registry) Get(name string) (Backend, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	b, ok := r.backends[name]
+	if !ok {
+		return Backend{}, ErrUnknownConverter
+	}
+	return b, nil
+}
+
+// Supports returns ErrUnknownConverter if a converter does not exist, or a
+// CapabilityError if it cannot convert a source.
+func (r *Registry) Supports(name string, s ConversionSource, aggressive bool) error {
+	b, err := r.Get(name)
+	if err != nil {
+		return err
+	}
+	return b.Supports(name, s, aggressive)
+}
+
+// Names returns the sorted names of all registered converters.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.backends))
+	for name := range r.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
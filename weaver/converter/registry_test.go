@@ -0,0 +1,49 @@
+package converter
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register("b", Backend{})
+	r.Register("a", Backend{})
+	if got, want := r.Names(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected names to be %+v, got %+v", want, got)
+	}
+	if _, err := r.Get("c"); err != ErrUnknownConverter {
+		t.Errorf("expected error to be %+v, got %+v", ErrUnknownConverter, err)
+	}
+	if err := r.Supports("c", ConversionSource{}, false); err != ErrUnknownConverter {
+		t.Errorf("expected error to be %+v, got %+v", ErrUnknownConverter, err)
+	}
+}
+
+func TestBackendSupports(t *testing.T) {
+	b := Backend{Capabilities: Capabilities{
+		MimeTypes: []string{"text/html"},
+		Options:   []string{"page_size"},
+	}}
+	html := ConversionSource{URI: "http://www.example.com", Mime: "text/html; charset=utf-8"}
+	if err := b.Supports("test", html, false); err != nil {
+		t.Errorf("Supports returned an unexpected error: %+v", err)
+	}
+
+	tests := map[string]ConversionSource{
+		"content type image/png": {Mime: "image/png"},
+		"the delay option":       {Mime: "text/html", Options: ConversionOptions{PageSize: "A4", Delay: 100}},
+		"headers, or cookies":    {Mime: "text/html", Header: http.Header{"Cookie": {"a=1"}}},
+	}
+	for feature, s := range tests {
+		err := b.Supports("test", s, false)
+		if ce, ok := err.(*CapabilityError); !ok || ce.Feature != feature {
+			t.Errorf("expected a capability error for %s, got %+v", feature, err)
+		}
+	}
+
+	if err := b.Supports("test", html, true); err == nil {
+		t.Errorf("expected the aggressive option not to be supported")
+	}
+}
@@ -3,7 +3,10 @@ package converter
 import (
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"os"
 	"path/filepath"
 
@@ -29,8 +32,95 @@ type ConversionSource struct {
 	// IsLocal should be true if the URI relies on a local conversion strategy,
 	// and false if the target is a remote source (that does not require
 	// pre-processing).
-	IsLocal  bool
-	HeaderKV string
+	IsLocal bool
+	// Header contains the HTTP headers to be sent by the converter when
+	// fetching a remote source. It does not contain cookies (see Cookies).
+	Header http.Header
+	// Cookies to be set by the converter when fetching a remote source. Every
+	// cookie is scoped to its Domain (or the host of the URI if it is empty),
+	// and its Path, so that it is not sent to other hosts.
+	Cookies []*http.Cookie
+	// Options controls the layout of the conversion.
+	Options ConversionOptions
+}
+
+// FetchOptions contains the HTTP headers, and cookies to be sent when fetching
+// a remote conversion source (e.g. for authenticated pages).
+type FetchOptions struct {
+	Header  http.Header
+	Cookies []*http.Cookie
+	// Domain (optional) is the domain of the cookies which do not declare
+	// their own. Cookies are only sent to the hosts within their domain
+	// (and path), including on redirects.
+	Domain string
+	// Policy (optional) restricts the URL, its redirects, and the IPs that
+	// can be fetched.
+	Policy *URLPolicy
+}
+
+// newCookieJar creates a cookie jar containing the cookies to be sent to the
+// URL, and it returns them with their scope. Cookies without a domain are
+// scoped to the given domain (or the host of the URL if it is empty), and
+// cookies without a path to the root. Cookies which do not match the URL are
+// dropped.
+func newCookieJar(u *url.URL, cookies []*http.Cookie, domain string) (*cookiejar.Jar, []*http.Cookie, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scoped := make([]*http.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		sc := *c
+		if sc.Domain == "" {
+			sc.Domain = domain
+		}
+		if sc.Path == "" {
+			sc.Path = "/"
+		}
+		scoped = append(scoped, &sc)
+	}
+	jar.SetCookies(u, scoped)
+
+	if n := len(jar.Cookies(u)); n < len(cookies) {
+		log.Printf("%d cookie(s) do not match %s, and they will not be sent\n", len(cookies)-n, u.Host)
+	}
+	return jar, scoped, nil
+}
+
+// sourceCookies returns the cookies sent to a URL (by a jar) with their scope.
+// The cookies which are not in scoped (i.e. set by a redirect) are scoped to
+// the host of the URL.
+func sourceCookies(sent []*http.Cookie, scoped []*http.Cookie) []*http.Cookie {
+	cookies := make([]*http.Cookie, 0, len(sent))
+	for _, c := range sent {
+		sc := &http.Cookie{Name: c.Name, Value: c.Value, Path: "/"}
+		for _, s := range scoped {
+			if s.Name == c.Name {
+				sc.Domain, sc.Path = s.Domain, s.Path
+				break
+			}
+		}
+		cookies = append(cookies, sc)
+	}
+	return cookies
+}
+
+// copyHeader returns a copy of the HTTP headers with canonical keys.
+func copyHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		for _, vv := range v {
+			c.Add(k, vv)
+		}
+	}
+	return c
+}
+
+// headerCookies returns the cookies of the Cookie request headers.
+func headerCookies(h http.Header) []*http.Cookie {
+	req := http.Request{Header: h}
+	return req.Cookies()
 }
 
 // readerContentType attempts to determine the content type using bytes from a
@@ -49,21 +139,36 @@ func readerContentType(r io.Reader) (string, error) {
 
 // readerTmpFile creates a temporary file using bytes from a reader.
 // It returns the full temporary file path, and its mime type if successful.
-func readerTmpFile(r io.Reader) (string, string, error) {
+// It returns ErrSourceTooLarge if the reader contains more than maxSize bytes
+// (unless maxSize is 0). The temporary file is removed on error.
+func readerTmpFile(r io.Reader, maxSize int64) (p string, t string, err error) {
 	// Create a temporary file
 	f, err := ioutil.TempFile("/tmp", "tmp")
 	if err != nil {
 		return "", "", err
 	}
 	defer f.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
 
 	// Pipe bytes from a reader to a file writer
-	if _, err = io.Copy(f, r); err != nil {
+	if maxSize > 0 {
+		// Read one more byte to find out if the limit has been exceeded
+		r = io.LimitReader(r, maxSize+1)
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
 		return "", "", err
 	}
+	if maxSize > 0 && n > maxSize {
+		return "", "", ErrSourceTooLarge
+	}
 
 	// Determine full temporary file path
-	p, err := filepath.Abs(f.Name())
+	p, err = filepath.Abs(f.Name())
 	if err != nil {
 		return "", "", err
 	}
@@ -75,7 +180,7 @@ func readerTmpFile(r io.Reader) (string, string, error) {
 	}
 
 	// Determine file content type from file reader
-	t, err := readerContentType(f)
+	t, err = readerContentType(f)
 	if err != nil {
 		return "", "", err
 	}
@@ -86,58 +191,127 @@ func readerTmpFile(r io.Reader) (string, string, error) {
 // rawSource is a local conversion strategy handler. It accepts a reader,
 // and performs pre-processing to save the stream of bytes to a local file
 // for conversion.
-func rawSource(s *ConversionSource, body io.Reader) error {
+func rawSource(s *ConversionSource, body io.Reader, l Limits) error {
 	// Save content locally in a temporary file
-	p, t, err := readerTmpFile(body)
+	p, t, err := readerTmpFile(body, l.MaxSize)
 	if err != nil {
 		return err
 	}
+	if !l.AllowedType(t) {
+		os.Remove(p)
+		return ErrSourceType
+	}
 	s.URI = p
 	s.Mime = t
 	s.IsLocal = true
 	return nil
 }
 
+// fetchError returns the cause of an error returned by an HTTP client if it
+// is one of the errors returned to the client (e.g. ErrURLForbidden).
+func fetchError(err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return ErrFetchTimeout
+	}
+	cause := err
+	if ue, ok := cause.(*url.Error); ok {
+		cause = ue.Err
+	}
+	if oe, ok := cause.(*net.OpError); ok {
+		cause = oe.Err
+	}
+	switch cause {
+	case ErrURLForbidden, ErrTooManyRedirects:
+		return cause
+	}
+	return err
+}
+
 // uriSource is a remote conversion strategy handler. It will attempt to fetch
 // the remote URI to determine: if it is accessible; its mime type; and
 // if it needs pre-processing (e.g. `octet-stream`).
-func uriSource(s *ConversionSource, uri, token, key, domain string) error {
+// The limits are enforced on the response, and its redirects.
+func uriSource(s *ConversionSource, uri string, fo FetchOptions, l Limits) error {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return err
+	}
+	if fo.Policy != nil {
+		if err := fo.Policy.CheckURL(req.URL); err != nil {
+			return err
+		}
+	}
+	// The client adds the cookies in the jar to the request headers, so the
+	// cookies of a Cookie header are moved to the jar (where they are scoped
+	// to the host of the URL)
+	header := copyHeader(fo.Header)
+	cookies := append(headerCookies(header), fo.Cookies...)
+	header.Del("Cookie")
+	req.Header = copyHeader(header)
+
 	// Fetch URL with support for cookies (to handle session-based redirects)
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", uri, nil)
-	// req.Header = http.Header{"Cookie": {key + "=" + token}}
-	// req.Header.Set("Cookie", cookie.String())
-	req.Header.Set("Cookie", key+"="+token)
+	jar, scoped, err := newCookieJar(req.URL, cookies, fo.Domain)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("请求详细参数: %s %s\n", req.Method, req.URL)
+	client := &http.Client{Jar: jar}
+	if fo.Policy != nil {
+		client = fo.Policy.Client(jar)
+	}
+	client.Timeout = l.FetchTimeout
+	checkRedirect := client.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= l.maxRedirects() {
+			return ErrTooManyRedirects
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		return nil
+	}
 
-	log.Printf("请求详细参数: %+v\n", req)
 	response, err := client.Do(req)
-	log.Printf("响应详细信息: %+v\n", response)
 	if err != nil {
-		log.Error(err)
+		return fetchError(err)
 	}
-	if response != nil {
-		defer response.Body.Close()
+	defer response.Body.Close()
+	log.Printf("响应详细信息: %s\n", response.Status)
+
+	if l.MaxSize > 0 && response.ContentLength > l.MaxSize {
+		return ErrSourceTooLarge
 	}
 
 	// Save content locally (temporarily) if the HTTP header indicates that it
 	// is a binary stream.
-	// TODO: file restrictions / limits (e.g. size)
 	if response.Header.Get("Content-Type") == "application/octet-stream" {
 		// Set the OriginalURI as we are running a local conversion strategy
 		s.OriginalURI = uri
 		// Pipe HTTP response body to a temporary file via io.Reader
-		if rawSource(s, response.Body) != nil {
-			return err
+		if err := rawSource(s, response.Body, l); err != nil {
+			return fetchError(err)
 		}
 	} else {
 		// Do not set the OriginalURI as it is NOT a local conversion
 		s.URI = uri
-		s.HeaderKV = "Cookie" + ":" + key + "=" + token // 2018年04月20日 加入用于命令的 header cookie 设置
+		// The converter fetches the page again, so it needs the same headers,
+		// and cookies, including those set by the redirect chain (e.g. a login
+		// redirect)
+		if len(header) > 0 {
+			s.Header = header
+		}
+		if cookies := jar.Cookies(req.URL); len(cookies) > 0 {
+			s.Cookies = sourceCookies(cookies, scoped)
+		}
 		// Read the first 512 bytes of the page contents into a temporary buffer
 		// so that we can determine the content type
 		t, err := readerContentType(response.Body)
 		if err != nil {
-			return err
+			return fetchError(err)
+		}
+		if !l.AllowedType(t) {
+			return ErrSourceType
 		}
 		s.Mime = t
 	}
@@ -161,14 +335,16 @@ func setCustomExtension(s *ConversionSource, ext string) error {
 // of bytes. If both parameters are specified, the reader takes precedence.
 // The ConversionSource is prepared using one of two strategies: a local
 // conversion (see rawSource) or a remote conversion (see uriSource).
-func NewConversionSource(uri, token, key, domain, ext string, body io.Reader) (*ConversionSource, error) {
+// The fetch options are only used for remote conversions, and the limits are
+// enforced on both.
+func NewConversionSource(uri string, fo FetchOptions, l Limits, ext string, body io.Reader) (*ConversionSource, error) {
 	s := new(ConversionSource)
 
 	var err error
 	if body != nil {
-		err = rawSource(s, body)
+		err = rawSource(s, body, l)
 	} else {
-		err = uriSource(s, uri, token, key, domain)
+		err = uriSource(s, uri, fo, l)
 	}
 
 	if err != nil {
@@ -3,6 +3,8 @@ package converter
 import (
 	"bytes"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -100,7 +102,7 @@ func TestReaderContentType_eof(t *testing.T) {
 func TestReaderTmpFile(t *testing.T) {
 	mockData := "<!DOCTYPE HTML>"
 	mockReader := strings.NewReader(mockData)
-	fp, ft, err := readerTmpFile(mockReader)
+	fp, ft, err := readerTmpFile(mockReader, 0)
 	if err != nil {
 		t.Fatalf("readertmpfile returned an unexpected error: %+v", err)
 	}
@@ -126,7 +128,7 @@ func TestRawSource(t *testing.T) {
 	s.URI = mockURI
 	mockData := "<!DOCTYPE HTML>"
 	mockReader := strings.NewReader(mockData)
-	err := rawSource(s, mockReader)
+	err := rawSource(s, mockReader, Limits{})
 	if err != nil {
 		t.Fatalf("rawsource returned an unexpected error: %+v", err)
 	}
@@ -137,7 +139,7 @@ func TestUriSource(t *testing.T) {
 	s := new(ConversionSource)
 	ts := testutil.MockHTTPServer("", "<?xml version=\"1.0\" encoding=\"UTF-8\"?>", false)
 	defer ts.Close()
-	err := uriSource(s, ts.URL, "", "", "")
+	err := uriSource(s, ts.URL, FetchOptions{}, Limits{})
 	if err != nil {
 		t.Fatalf("urisource returned an unexpected error: %+v", err)
 	}
@@ -150,7 +152,7 @@ func TestUriSource_basicAuth(t *testing.T) {
 	defer ts.Close()
 
 	// Test unauthenticated
-	err := uriSource(s, ts.URL, "", "", "")
+	err := uriSource(s, ts.URL, FetchOptions{}, Limits{})
 	if err != nil {
 		t.Fatalf("urisource (unauthenticated) returned an unexpected error: %+v", err)
 	}
@@ -166,7 +168,7 @@ func TestUriSource_basicAuth(t *testing.T) {
 	}
 
 	u.User = url.UserPassword("test", "test")
-	err = uriSource(s, u.String(), "", "", "")
+	err = uriSource(s, u.String(), FetchOptions{}, Limits{})
 	if err != nil {
 		t.Fatalf("urisource (authenticated) returned an unexpected error: %+v", err)
 	}
@@ -181,7 +183,7 @@ func TestUriSource_octet(t *testing.T) {
 	mockData := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>"
 	ts := testutil.MockHTTPServer("application/octet-stream", mockData, false)
 	defer ts.Close()
-	err := uriSource(s, ts.URL, "", "", "")
+	err := uriSource(s, ts.URL, FetchOptions{}, Limits{})
 	if err != nil {
 		t.Fatalf("urisource returned an unexpected error: %+v", err)
 	}
@@ -191,6 +193,177 @@ func TestUriSource_octet(t *testing.T) {
 	}
 }
 
+func TestUriSource_fetchOptions(t *testing.T) {
+	var got *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Write([]byte("<!DOCTYPE HTML>"))
+	}))
+	defer ts.Close()
+
+	s := new(ConversionSource)
+	fo := FetchOptions{
+		Header: http.Header{
+			"Authorization":   {"Bearer test"},
+			"Accept-Language": {"zh-CN"},
+		},
+		Cookies: []*http.Cookie{
+			{Name: "session", Value: "a"},
+			{Name: "token", Value: "b"},
+		},
+	}
+	err := uriSource(s, ts.URL, fo, Limits{})
+	if err != nil {
+		t.Fatalf("urisource returned an unexpected error: %+v", err)
+	}
+
+	for k, want := range map[string]string{
+		"Authorization":   "Bearer test",
+		"Accept-Language": "zh-CN",
+		"Cookie":          "session=a; token=b",
+	} {
+		if got := got.Header.Get(k); got != want {
+			t.Errorf("expected %s header of fetch request to be %s, got %s", k, want, got)
+		}
+		if k == "Cookie" {
+			want = ""
+		}
+		if got := s.Header.Get(k); got != want {
+			t.Errorf("expected %s header of conversion source to be %s, got %s", k, want, got)
+		}
+	}
+	want := []string{"session=a; Path=/", "token=b; Path=/"}
+	if got := cookieStrings(s.Cookies); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected cookies of conversion source to be %+v, got %+v", want, got)
+	}
+}
+
+// cookieStrings returns the cookies in the Set-Cookie format.
+func cookieStrings(cookies []*http.Cookie) []string {
+	var s []string
+	for _, c := range cookies {
+		s = append(s, c.String())
+	}
+	return s
+}
+
+func TestUriSource_cookieHeader(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Cookie")
+		w.Write([]byte("<!DOCTYPE HTML>"))
+	}))
+	defer ts.Close()
+
+	// The cookies of a Cookie header are scoped to the host of the URL
+	s := new(ConversionSource)
+	fo := FetchOptions{Header: http.Header{"Cookie": {"session=a"}}}
+	if err := uriSource(s, ts.URL, fo, Limits{}); err != nil {
+		t.Fatalf("urisource returned an unexpected error: %+v", err)
+	}
+	if want := "session=a"; got != want {
+		t.Errorf("expected cookies of fetch request to be %s, got %s", want, got)
+	}
+	if s.Header != nil {
+		t.Errorf("expected headers of conversion source to be empty, got %+v", s.Header)
+	}
+	want := []string{"session=a; Path=/"}
+	if got := cookieStrings(s.Cookies); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected cookies of conversion source to be %+v, got %+v", want, got)
+	}
+}
+
+func TestUriSource_cookieRedirect(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			// Session-based redirect
+			http.SetCookie(w, &http.Cookie{Name: "auth", Value: "ok", Path: "/"})
+			http.Redirect(w, r, "/page", http.StatusFound)
+		case "/page":
+			got = r.Header.Get("Cookie")
+			w.Write([]byte("<!DOCTYPE HTML>"))
+		}
+	}))
+	defer ts.Close()
+
+	s := new(ConversionSource)
+	fo := FetchOptions{Cookies: []*http.Cookie{{Name: "session", Value: "a"}}}
+	err := uriSource(s, ts.URL+"/login", fo, Limits{})
+	if err != nil {
+		t.Fatalf("urisource returned an unexpected error: %+v", err)
+	}
+
+	if want := "session=a; auth=ok"; got != want {
+		t.Errorf("expected cookies of redirected request to be %s, got %s", want, got)
+	}
+	want := []string{"session=a; Path=/", "auth=ok; Path=/"}
+	if got := cookieStrings(s.Cookies); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected cookies of conversion source to be %+v, got %+v", want, got)
+	}
+}
+
+func TestUriSource_cookieDomain(t *testing.T) {
+	var got string
+	third := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Cookie")
+		w.Write([]byte("<!DOCTYPE HTML>"))
+	}))
+	defer third.Close()
+
+	// The third party server is on a different host (localhost, and 127.0.0.1)
+	u, err := url.Parse(third.URL)
+	if err != nil {
+		t.Fatalf("failed to parse mock server url: %+v", err)
+	}
+	u.Host = "localhost:" + u.Port()
+	ts := httptest.NewServer(http.RedirectHandler(u.String(), http.StatusFound))
+	defer ts.Close()
+
+	s := new(ConversionSource)
+	fo := FetchOptions{
+		Cookies: []*http.Cookie{{Name: "session", Value: "a"}},
+		Domain:  "127.0.0.1",
+	}
+	err = uriSource(s, ts.URL, fo, Limits{})
+	if err != nil {
+		t.Fatalf("urisource returned an unexpected error: %+v", err)
+	}
+	if got != "" {
+		t.Errorf("expected cookies not to be sent to a third party host, got %s", got)
+	}
+	want := []string{"session=a; Path=/; Domain=127.0.0.1"}
+	if got := cookieStrings(s.Cookies); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected cookies of conversion source to be %+v, got %+v", want, got)
+	}
+}
+
+func TestUriSource_cookieDomainMismatch(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Cookie")
+		w.Write([]byte("<!DOCTYPE HTML>"))
+	}))
+	defer ts.Close()
+
+	s := new(ConversionSource)
+	fo := FetchOptions{
+		Cookies: []*http.Cookie{{Name: "session", Value: "a"}},
+		Domain:  "example.com",
+	}
+	err := uriSource(s, ts.URL, fo, Limits{})
+	if err != nil {
+		t.Fatalf("urisource returned an unexpected error: %+v", err)
+	}
+	if got != "" {
+		t.Errorf("expected cookies not to be sent outside of their domain, got %s", got)
+	}
+	if len(s.Cookies) > 0 {
+		t.Errorf("expected cookies of conversion source to be empty, got %+v", s.Cookies)
+	}
+}
+
 func TestSetCustomExtension(t *testing.T) {
 	s := new(ConversionSource)
 	setMockURI(t, s)
@@ -259,7 +432,7 @@ func TestNewConversionSource(t *testing.T) {
 	mockURI := "http://this-should-not-be-used"
 	mockData := "<!DOCTYPE HTML>"
 	mockReader := strings.NewReader(mockData)
-	s, err := NewConversionSource(mockURI, "", "", "", "", mockReader)
+	s, err := NewConversionSource(mockURI, FetchOptions{}, Limits{}, "", mockReader)
 	if err != nil {
 		t.Fatalf("newconversionsource returned an unexpected error: %+v", err)
 	}
@@ -269,7 +442,7 @@ func TestNewConversionSource(t *testing.T) {
 func TestNewConversionSource_remote(t *testing.T) {
 	ts := testutil.MockHTTPServer("", "<?xml version=\"1.0\" encoding=\"UTF-8\"?>", false)
 	defer ts.Close()
-	s, err := NewConversionSource(ts.URL, "", "", "", "", nil)
+	s, err := NewConversionSource(ts.URL, FetchOptions{}, Limits{}, "", nil)
 	if err != nil {
 		t.Fatalf("newconversionsource returned an unexpected error: %+v", err)
 	}
@@ -277,7 +450,7 @@ func TestNewConversionSource_remote(t *testing.T) {
 }
 
 func TestNewConversionSource_invalidURL(t *testing.T) {
-	s, err := NewConversionSource("http://invalid-url", "", "", "", "", nil)
+	s, err := NewConversionSource("http://invalid-url", FetchOptions{}, Limits{}, "", nil)
 	if err == nil {
 		t.Fatalf("expected error to be returned")
 	}
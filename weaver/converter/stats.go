@@ -0,0 +1,171 @@
+package converter
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// durationWindow is the number of recent conversions used for computing the
+// rolling duration percentiles.
+const durationWindow = 100
+
+// WorkerState is a snapshot of the state of a worker.
+type WorkerState struct {
+	ID   int
+	Busy bool
+	// URI of the source being converted (only set if the worker is busy).
+	URI string
+	// Tenant of the current job (only set if the worker is busy).
+	Tenant string
+	// Elapsed time since the worker picked up the current job.
+	Elapsed time.Duration
+	// Completed is the number of jobs processed by the worker (including
+	// failed ones).
+	Completed int
+}
+
+// Stats is a snapshot of WorkerStats.
+type Stats struct {
+	Workers   []WorkerState
+	Successes int64
+	Failures  int64
+	Timeouts  int64
+	Fallbacks int64
+	// Rejections is the number of conversions rejected because the work
+	// queue was full.
+	Rejections int64
+	// P50, and P95 are the percentiles of the duration of the most recent
+	// conversions (see durationWindow).
+	P50 time.Duration
+	P95 time.Duration
+}
+
+// WorkerStats keeps the state of every worker, and the outcome of all the
+// conversions since start. It is updated by InitWorkers, and Work.Process.
+type WorkerStats struct {
+	mu sync.Mutex
+
+	workers    []workerState
+	successes  int64
+	failures   int64
+	timeouts   int64
+	fallbacks  int64
+	rejections int64
+	// durations is a ring buffer of the most recent conversion durations.
+	durations []time.Duration
+	next      int
+}
+
+type workerState struct {
+	uri       string
+	tenant    string
+	started   time.Time
+	completed int
+}
+
+// NewWorkerStats creates, and returns a new WorkerStats for n workers.
+func NewWorkerStats(n int) *WorkerStats {
+	return &WorkerStats{workers: make([]workerState, n)}
+}
+
+// start marks a worker as busy with the source of a tenant.
+func (s *WorkerStats) start(id int, src ConversionSource, tenant string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workers[id].uri = src.GetActualURI()
+	s.workers[id].tenant = tenant
+	s.workers[id].started = time.Now()
+}
+
+// finish marks a worker as idle, and it records the outcome of its job.
+// Cancelled jobs are not counted as a success or a failure.
+func (s *WorkerStats) finish(id int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := &s.workers[id]
+	d := time.Since(w.started)
+	w.uri = ""
+	w.tenant = ""
+	w.started = time.Time{}
+	w.completed++
+
+	switch err {
+	case errWorkCancelled:
+		return
+	case nil:
+		s.successes++
+	case ErrConversionTimeout:
+		s.timeouts++
+	default:
+		s.failures++
+	}
+
+	if len(s.durations) < durationWindow {
+		s.durations = append(s.durations, d)
+		return
+	}
+	s.durations[s.next] = d
+	s.next = (s.next + 1) % durationWindow
+}
+
+// Fallback records that a failed conversion has been retried with another
+// converter.
+func (s *WorkerStats) Fallback() {
+	s.mu.Lock()
+	s.fallbacks++
+	s.mu.Unlock()
+}
+
+// Reject records that a conversion has been rejected because the work queue
+// was full.
+func (s *WorkerStats) Reject() {
+	s.mu.Lock()
+	s.rejections++
+	s.mu.Unlock()
+}
+
+// Snapshot returns a copy of the current stats.
+func (s *WorkerStats) Snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := Stats{
+		Workers:    make([]WorkerState, len(s.workers)),
+		Successes:  s.successes,
+		Failures:   s.failures,
+		Timeouts:   s.timeouts,
+		Fallbacks:  s.fallbacks,
+		Rejections: s.rejections,
+	}
+	now := time.Now()
+	for i, w := range s.workers {
+		st.Workers[i] = WorkerState{ID: i, Completed: w.completed}
+		if !w.started.IsZero() {
+			st.Workers[i].Busy = true
+			st.Workers[i].URI = w.uri
+			st.Workers[i].Tenant = w.tenant
+			st.Workers[i].Elapsed = now.Sub(w.started)
+		}
+	}
+
+	d := make([]time.Duration, len(s.durations))
+	copy(d, s.durations)
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	st.P50 = percentile(d, 50)
+	st.P95 = percentile(d, 95)
+	return st
+}
+
+// percentile returns the nearest-rank percentile p of sorted durations.
+func percentile(d []time.Duration, p int) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	i := (len(d)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return d[i]
+}
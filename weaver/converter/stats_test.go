@@ -0,0 +1,89 @@
+package converter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/metrics"
+)
+
+func TestWorkerStats(t *testing.T) {
+	ws := NewWorkerStats(2)
+	ws.start(1, ConversionSource{URI: "/tmp/test.html", OriginalURI: "http://www.ok.com"}, "acme")
+
+	st := ws.Snapshot()
+	if got, want := len(st.Workers), 2; got != want {
+		t.Fatalf("expected number of workers to be %d, got %d", want, got)
+	}
+	if st.Workers[0].Busy {
+		t.Errorf("expected worker #0 to be idle")
+	}
+	if w := st.Workers[1]; !w.Busy || w.URI != "http://www.ok.com" || w.Tenant != "acme" {
+		t.Errorf("expected worker #1 to be busy with http://www.ok.com for acme, got %+v", w)
+	}
+
+	ws.finish(1, nil)
+	ws.start(1, ConversionSource{}, "")
+	ws.finish(1, ErrConversionTimeout)
+	ws.start(1, ConversionSource{}, "")
+	ws.finish(1, ErrTestConversionError)
+	ws.start(1, ConversionSource{}, "")
+	ws.finish(1, errWorkCancelled)
+	ws.Fallback()
+	ws.Reject()
+
+	st = ws.Snapshot()
+	if w := st.Workers[1]; w.Busy || w.URI != "" {
+		t.Errorf("expected worker #1 to be idle, got %+v", w)
+	}
+	if got, want := st.Workers[1].Completed, 4; got != want {
+		t.Errorf("expected worker #1 to have completed %d jobs, got %d", want, got)
+	}
+	if got, want := [5]int64{st.Successes, st.Failures, st.Timeouts, st.Fallbacks, st.Rejections}, [5]int64{1, 1, 1, 1, 1}; got != want {
+		t.Errorf("expected totals to be %+v, got %+v", want, got)
+	}
+}
+
+func TestWorkerStats_durations(t *testing.T) {
+	ws := NewWorkerStats(1)
+	// More than the window, the oldest durations are discarded
+	for i := 1; i <= durationWindow+100; i++ {
+		ws.workers[0].started = time.Now().Add(-time.Duration(i) * time.Second)
+		ws.finish(0, nil)
+	}
+
+	st := ws.Snapshot()
+	if got, want := st.P50.Seconds(), 150.0; got < want || got > want+1 {
+		t.Errorf("expected p50 to be %vs, got %vs", want, got)
+	}
+	if got, want := st.P95.Seconds(), 195.0; got < want || got > want+1 {
+		t.Errorf("expected p95 to be %vs, got %vs", want, got)
+	}
+}
+
+func TestInitWorkers_stats(t *testing.T) {
+	wq, ws := InitWorkers(1, 1, 10, SchedulerOptions{}, metrics.Discard)
+	defer wq.Close()
+	w, err := NewWork(wq, TestConversion{}, ConversionSource{}, Destination{}, Class{}, 0)
+	if err != nil {
+		t.Fatalf("newwork returned an unexpected error: %+v", err)
+	}
+	select {
+	case <-w.Success():
+	case <-time.After(time.Second):
+		t.Fatalf("expected to receive output before timeout")
+	}
+
+	// The stats are updated after the output has been published
+	deadline := time.Now().Add(time.Second)
+	for ws.Snapshot().Successes == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	st := ws.Snapshot()
+	if got, want := st.Successes, int64(1); got != want {
+		t.Errorf("expected number of successes to be %d, got %d", want, got)
+	}
+	if got, want := st.Workers[0].Completed, 1; got != want {
+		t.Errorf("expected worker #0 to have completed %d jobs, got %d", want, got)
+	}
+}
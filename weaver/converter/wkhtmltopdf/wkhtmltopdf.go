@@ -0,0 +1,226 @@
+package wkhtmltopdf
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/gcmd"
+)
+
+// WkHTMLToPDF represents a conversion job for wkhtmltopdf.
+// WkHTMLToPDF implements the Converter interface with a custom Convert method.
+type WkHTMLToPDF struct {
+	// WkHTMLToPDF inherits properties from Conversion.
+	// See Conversion for more information.
+	converter.Conversion
+	// CMD is the base wkhtmltopdf command that will be executed.
+	// e.g. 'wkhtmltopdf -q'
+	CMD string
+	// Policy (optional) is checked against the URL of a remote source before
+	// wkhtmltopdf fetches it.
+	Policy *converter.URLPolicy
+	// Proxy (optional) is the URL of the proxy through which wkhtmltopdf
+	// loads the page, and its resources (see converter.PolicyProxy).
+	Proxy string
+}
+
+// Capabilities of wkhtmltopdf: it only converts HTML, and it supports every
+// option except the aggressive content extraction of athenapdf.
+var Capabilities = converter.Capabilities{
+	MimeTypes: []string{"text/html", "application/xhtml+xml"},
+	Options:   []string{"page_size", "margins", "orientation", "zoom", "delay", "no_background", "header_html", "footer_html", "toc"},
+	Headers:   true,
+}
+
+// marginSizes are the margins of the margin types. The standard margins are
+// the wkhtmltopdf defaults.
+var marginSizes = map[string]string{
+	"standard": "10mm",
+	"none":     "0",
+	"minimal":  "5mm",
+}
+
+// templates are the paths of the header, and footer HTML files (if any).
+type templates struct {
+	header string
+	footer string
+}
+
+// globalArgs returns the wkhtmltopdf global options for the conversion
+// options (i.e. the page layout).
+// Options which are not set are omitted so that the wkhtmltopdf defaults are
+// used.
+func globalArgs(o converter.ConversionOptions) []string {
+	var args []string
+	if o.PageSize != "" {
+		args = append(args, "-s", o.Normalize().PageSize)
+	}
+	if o.Orientation != "" {
+		if o.Landscape() {
+			args = append(args, "-O", "Landscape")
+		} else {
+			args = append(args, "-O", "Portrait")
+		}
+	}
+	if o.Margins != "" {
+		m := marginSizes[o.Normalize().Margins]
+		args = append(args, "-T", m, "-B", m, "-L", m, "-R", m)
+	}
+	return args
+}
+
+// pageArgs returns the wkhtmltopdf page options for the conversion options.
+func pageArgs(o converter.ConversionOptions, t templates) []string {
+	var args []string
+	if o.Zoom > 0 {
+		args = append(args, "--zoom", strconv.Itoa(o.Zoom))
+	}
+	if o.Delay > 0 {
+		args = append(args, "--javascript-delay", strconv.Itoa(o.Delay))
+	}
+	if o.NoBackground {
+		args = append(args, "--no-background")
+	}
+	if t.header != "" {
+		args = append(args, "--header-html", t.header)
+	}
+	if t.footer != "" {
+		args = append(args, "--footer-html", t.footer)
+	}
+	return args
+}
+
+// headerArgs returns a '--custom-header' wkhtmltopdf flag for every header
+// value. They are only sent when fetching the page (not its resources).
+// Cookie headers are left out (see cookieArgs).
+// The headers are sorted so that the command is deterministic.
+func headerArgs(h http.Header) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		if http.CanonicalHeaderKey(k) != "Cookie" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		for _, v := range h[k] {
+			args = append(args, "--custom-header", k, v)
+		}
+	}
+	return args
+}
+
+// cookieArgs returns a '--cookie' wkhtmltopdf flag for every cookie.
+// wkhtmltopdf sets them for the host of the page, so they are not sent to
+// other hosts.
+func cookieArgs(cookies []*http.Cookie) []string {
+	var args []string
+	for _, c := range cookies {
+		// The value must be URL encoded
+		args = append(args, "--cookie", c.Name, url.PathEscape(c.Value))
+	}
+	return args
+}
+
+// constructCMD returns a string array containing the wkhtmltopdf command to be
+// executed by Go's os/exec Output. It does this using a base command, and path
+// string.
+// The conversion options are mapped onto their equivalent wkhtmltopdf flags.
+// A table of contents is added before the page if it is enabled. The PDF is
+// written to the standard output.
+// The page can only read local files in dir (i.e. the source, and the
+// templates of the conversion), and it is loaded through proxy if it is not
+// empty.
+func constructCMD(base string, path string, dir string, proxy string, header http.Header, cookies []*http.Cookie, o converter.ConversionOptions, t templates) []string {
+	args := strings.Fields(base)
+	args = append(args, globalArgs(o)...)
+	if o.TOC {
+		args = append(args, "toc")
+	}
+	args = append(args, path, "--disable-local-file-access", "--allow", dir)
+	args = append(args, pageArgs(o, t)...)
+	args = append(args, headerArgs(header)...)
+	args = append(args, cookieArgs(cookies)...)
+	if proxy != "" {
+		args = append(args, "--proxy", proxy)
+	}
+	return append(args, "-")
+}
+
+// writeTemplates writes the header, and footer HTML to a directory, and
+// returns their paths. wkhtmltopdf only loads local files as HTML if they
+// have a '.html' extension.
+func writeTemplates(dir string, o converter.ConversionOptions) (templates, error) {
+	var t templates
+	if o.HeaderHTML != "" {
+		t.header = filepath.Join(dir, "header.html")
+		if err := ioutil.WriteFile(t.header, []byte(o.HeaderHTML), 0600); err != nil {
+			return t, err
+		}
+	}
+	if o.FooterHTML != "" {
+		t.footer = filepath.Join(dir, "footer.html")
+		if err := ioutil.WriteFile(t.footer, []byte(o.FooterHTML), 0600); err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// Convert returns a byte slice containing a PDF converted from HTML
+// using wkhtmltopdf.
+// See the Convert method for Conversion for more information.
+func (c WkHTMLToPDF) Convert(s converter.ConversionSource, done <-chan struct{}) ([]byte, error) {
+	log.Printf("[WkHTMLToPDF] 命令正在转换 PDF: %s\n", s.GetActualURI())
+
+	if !s.IsLocal && c.Policy != nil {
+		u, err := url.Parse(s.URI)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Policy.CheckURL(u); err != nil {
+			return nil, err
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "wkhtmltopdf")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	t, err := writeTemplates(dir, s.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	// The temporary file of a local source has no extension, so it is linked
+	// as a '.html' file (see writeTemplates). The link is in dir, so that
+	// wkhtmltopdf is allowed to read it.
+	path := s.URI
+	if s.IsLocal {
+		path = filepath.Join(dir, "source.html")
+		if err := os.Symlink(s.URI, path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Construct the command to execute
+	cmd := constructCMD(c.CMD, path, dir, c.Proxy, s.Header, s.Cookies, s.Options, t)
+	out, err := gcmd.Execute(cmd, done)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,162 @@
+package wkhtmltopdf
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/testutil"
+)
+
+func TestConstructCMD(t *testing.T) {
+	got := constructCMD("wkhtmltopdf -q", "test_file.html", "/tmp/test", "", nil, nil, converter.ConversionOptions{}, templates{})
+	want := []string{"wkhtmltopdf", "-q", "test_file.html", "--disable-local-file-access", "--allow", "/tmp/test", "-"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected constructed wkhtmltopdf command to be %+v, got %+v", want, got)
+	}
+}
+
+func TestConstructCMD_proxy(t *testing.T) {
+	got := constructCMD("wkhtmltopdf", "test_file.html", "/tmp/test", "http://127.0.0.1:3128", nil, nil, converter.ConversionOptions{}, templates{})
+	want := []string{
+		"wkhtmltopdf", "test_file.html", "--disable-local-file-access", "--allow", "/tmp/test",
+		"--proxy", "http://127.0.0.1:3128", "-",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected constructed wkhtmltopdf command to be %+v, got %+v", want, got)
+	}
+}
+
+func TestConstructCMD_options(t *testing.T) {
+	o := converter.ConversionOptions{
+		PageSize:     "letter",
+		Margins:      "none",
+		Orientation:  "landscape",
+		Zoom:         2,
+		Delay:        500,
+		NoBackground: true,
+		TOC:          true,
+	}
+	got := constructCMD("wkhtmltopdf -q", "test_file.html", "/tmp/test", "", nil, nil, o, templates{header: "header.html", footer: "footer.html"})
+	want := []string{
+		"wkhtmltopdf", "-q",
+		"-s", "Letter", "-O", "Landscape", "-T", "0", "-B", "0", "-L", "0", "-R", "0",
+		"toc", "test_file.html", "--disable-local-file-access", "--allow", "/tmp/test",
+		"--zoom", "2", "--javascript-delay", "500", "--no-background",
+		"--header-html", "header.html", "--footer-html", "footer.html",
+		"-",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected constructed wkhtmltopdf command to be %+v, got %+v", want, got)
+	}
+}
+
+func TestConstructCMD_headers(t *testing.T) {
+	h := http.Header{
+		"Cookie":        {"session=a; token=b"},
+		"Authorization": {"Bearer test"},
+	}
+	cookies := []*http.Cookie{{Name: "session", Value: "a b", Path: "/"}}
+	got := constructCMD("wkhtmltopdf", "test_file.html", "/tmp/test", "", h, cookies, converter.ConversionOptions{}, templates{})
+	want := []string{
+		"wkhtmltopdf", "test_file.html", "--disable-local-file-access", "--allow", "/tmp/test",
+		"--custom-header", "Authorization", "Bearer test",
+		"--cookie", "session", "a%20b",
+		"-",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected constructed wkhtmltopdf command to be %+v, got %+v", want, got)
+	}
+}
+
+func TestWriteTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wkhtmltopdf")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory for testing: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tpl, err := writeTemplates(dir, converter.ConversionOptions{FooterHTML: "<p>footer</p>"})
+	if err != nil {
+		t.Fatalf("writeTemplates returned an unexpected error: %+v", err)
+	}
+	if tpl.header != "" {
+		t.Errorf("expected no header template, got %s", tpl.header)
+	}
+	b, err := ioutil.ReadFile(tpl.footer)
+	if err != nil {
+		t.Fatalf("unable to read footer template: %+v", err)
+	}
+	if got, want := string(b), "<p>footer</p>"; got != want {
+		t.Errorf("expected footer template to be %s, got %s", want, got)
+	}
+	if filepath.Ext(tpl.footer) != ".html" {
+		t.Errorf("expected footer template to have a .html extension, got %s", tpl.footer)
+	}
+}
+
+func mockConversion(path string, tmp bool, cmd string) ([]byte, error) {
+	c := WkHTMLToPDF{}
+	c.CMD = cmd
+	s := converter.ConversionSource{}
+	s.URI = path
+	s.IsLocal = tmp
+	t := make(chan struct{}, 1)
+	return c.Convert(s, t)
+}
+
+func TestConvert(t *testing.T) {
+	ts := testutil.MockHTTPServer("", "test wkhtmltopdf convert", false)
+	defer ts.Close()
+	got, err := mockConversion(ts.URL, false, "echo")
+	if err != nil {
+		t.Fatalf("convert returned an unexpected error: %+v", err)
+	}
+	if p := strings.Fields(string(got))[0]; p != ts.URL {
+		t.Errorf("expected output of wkhtmltopdf conversion to start with %s, got %s", ts.URL, got)
+	}
+}
+
+func TestConvert_policy(t *testing.T) {
+	c := WkHTMLToPDF{CMD: "echo", Policy: &converter.URLPolicy{}}
+	s := converter.ConversionSource{URI: "http://169.254.169.254/latest/meta-data/"}
+	got, err := c.Convert(s, make(chan struct{}))
+	if err != converter.ErrURLForbidden {
+		t.Fatalf("expected error to be %+v, got %+v", converter.ErrURLForbidden, err)
+	}
+	if got != nil {
+		t.Errorf("expected output of wkhtmltopdf conversion to be nil, got %s", got)
+	}
+}
+
+func TestConvert_local(t *testing.T) {
+	f, err := ioutil.TempFile("/tmp", "tmp")
+	if err != nil {
+		t.Fatalf("unable to create temporary file for testing: %+v", err)
+	}
+	defer os.Remove(f.Name())
+	got, err := mockConversion(f.Name(), true, "echo")
+	if err != nil {
+		t.Fatalf("convert returned an unexpected error: %+v", err)
+	}
+	if p := strings.Fields(string(got))[0]; filepath.Base(p) != "source.html" {
+		t.Errorf("expected local source to be converted as source.html, got %s", p)
+	}
+}
+
+func TestConvert_badCMD(t *testing.T) {
+	ts := testutil.MockHTTPServer("", "test wkhtmltopdf convert", false)
+	defer ts.Close()
+	got, err := mockConversion(ts.URL, false, "echo-broken")
+	if err == nil {
+		t.Fatalf("expected error to be returned")
+	}
+	if got != nil {
+		t.Errorf("expected output of wkhtmltopdf conversion to be nil, got %s", got)
+	}
+}
@@ -3,12 +3,19 @@ package converter
 import (
 	"errors"
 	"log"
+	"sync/atomic"
 	"time"
+
+	"github.com/arachnys/athenapdf/weaver/metrics"
 )
 
 // 超时错误
 var (
 	ErrConversionTimeout = errors.New("pdf 转换超时")
+	// ErrQueueFull is returned by NewWork if the work queue is full.
+	ErrQueueFull = errors.New("work queue is full, try again later")
+	// errWorkCancelled is returned by Process if the work has been cancelled.
+	errWorkCancelled = errors.New("work cancelled")
 )
 
 // Worker 工作者信息
@@ -20,108 +27,139 @@ type Worker struct {
 // maxWorkers: 最大并行转换数
 // maxQueue: 队列里做多等待数量
 // timeout: 转换超时时间
-func InitWorkers(maxWorkers, maxQueue, timeout int) chan<- Work {
-	wq := make(chan Work, maxQueue)
-
+// o: 优先级和租户的调度选项
+// m: 队列长度和正在转换的 worker 数量
+// It returns the work queue, and the stats of its workers.
+func InitWorkers(maxWorkers, maxQueue, timeout int, o SchedulerOptions, m metrics.Metrics) (*WorkQueue, *WorkerStats) {
+	wq := NewWorkQueue(maxQueue, o)
+	st := NewWorkerStats(maxWorkers)
+
+	var active int32
 	for i := 0; i < maxWorkers; i++ {
 		w := Worker{i}
-		go func(wq <-chan Work, w Worker, timeout int) {
-			for work := range wq {
-				log.Printf("[工号 #%d] 正在转换 pdf 中... (当前等待的转换数量: %d)\n", w.id, len(wq))
-				work.Process(timeout)
+		go func(wq *WorkQueue, w Worker, timeout int) {
+			for {
+				work, ok := wq.pop()
+				if !ok {
+					return
+				}
+				log.Printf("[工号 #%d] 正在转换 pdf 中... (租户: %q, 优先级: %s, 当前等待的转换数量: %d)\n", w.id, work.class.Tenant, work.class.Priority, wq.Len())
+				m.Gauge("queue_depth", wq.Len())
+				m.Gauge("active_workers", int(atomic.AddInt32(&active, 1)))
+				st.start(w.id, work.source, work.class.Tenant)
+				st.finish(w.id, work.Process(timeout))
+				m.Gauge("active_workers", int(atomic.AddInt32(&active, -1)))
+				wq.done(work)
 			}
 		}(wq, w, timeout)
 	}
 
-	return wq
+	return wq, st
 }
 
 // Work 转换器信息
 type Work struct {
-	converter Converter
-	source    ConversionSource
-	out       chan []byte
-	url       chan string
-	err       chan error
-	uploaded  chan struct{}
-	done      chan struct{}
+	converter   Converter
+	source      ConversionSource
+	destination Destination
+	class       Class
+	out         chan []byte
+	stored      chan string
+	err         chan error
+	started     chan struct{}
+	done        chan struct{}
+	// queue holds the work until a worker picks it up.
+	queue *WorkQueue
 }
 
 // NewWork 添加新的转换工作
-func NewWork(wq chan<- Work, c Converter, s ConversionSource) Work {
+// The output of the conversion is uploaded to the destination if it has a
+// storage backend, otherwise it is published through Success.
+// The class of the work is used for scheduling it (see WorkQueue).
+// If the work queue is full, it waits for up to wait for a free slot, and it
+// returns ErrQueueFull if there is none (it does not block if wait is 0).
+func NewWork(wq *WorkQueue, c Converter, s ConversionSource, d Destination, cl Class, wait time.Duration) (Work, error) {
 	w := Work{}
 	w.converter = c
 	w.source = s
+	w.destination = d
+	w.class = cl
+	if cl.Priority == "" || !ValidPriority(cl.Priority) {
+		w.class.Priority = PriorityInteractive
+	}
 	w.out = make(chan []byte, 1)
-	w.url = make(chan string, 1)
+	w.stored = make(chan string, 1)
 	w.err = make(chan error, 1)
-	w.uploaded = make(chan struct{}, 1)
+	w.started = make(chan struct{})
 	w.done = make(chan struct{}, 1)
-	go func(wq chan<- Work, w Work) {
-		wq <- w
-	}(wq, w)
-	return w
+	w.queue = wq
+	if err := wq.push(w, wait); err != nil {
+		return Work{}, err
+	}
+	return w, nil
 }
 
 // Process 处理超时信息
-func (w Work) Process(timeout int) {
+// It returns the error published to the work, or errWorkCancelled if the work
+// has been cancelled.
+func (w Work) Process(timeout int) error {
+	close(w.started)
+
 	done := make(chan struct{}, 1)
 	defer close(done)
 
 	wout := make(chan []byte, 1)
+	wstored := make(chan string, 1)
 	werr := make(chan error, 1)
-	wurl := make(chan string, 1)
 
-	go func(w Work, done <-chan struct{}, wout chan<- []byte, werr chan<- error) {
-		// NOTE: 这里转换只是用到了链接, 对于需要 cookie 和参数的是不合适的, 需要注意
+	go func(w Work, done <-chan struct{}, wout chan<- []byte, wstored chan<- string, werr chan<- error) {
 		out, err := w.converter.Convert(w.source, done)
 		if err != nil {
 			werr <- err
 			return
 		}
 
-		// 七牛上传只需要返回给前端 URL 就好了, 不用自动弹出下载框
-		// uploaded, url, err := w.converter.UploadQiniu(out)
-		// if err != nil {
-		// 	werr <- err
-		// 	return
-		// }
-
-		// if uploaded {
-		// 	close(w.uploaded)
-		// 	return
-		// }
-
-		// log.Println("七牛返回链接: ", url)
+		if w.destination.Storage == nil {
+			wout <- out
+			return
+		}
 
-		// 原始返回的是字节数组, 七牛的话, 只返回链接即可
-		wout <- out
-		// wurl <- url
-	}(w, done, wout, werr)
+		// 上传后只需要返回给前端 URL 就好了, 不用自动弹出下载框
+		location, err := w.destination.store(out)
+		if err != nil {
+			werr <- err
+			return
+		}
+		wstored <- location
+	}(w, done, wout, wstored, werr)
 
 	select {
 	case <-w.Cancelled():
-	case <-w.Uploaded():
+		return errWorkCancelled
 	case out := <-wout:
 		w.out <- out
-	case url := <-wurl:
-		w.url <- url
+	case location := <-wstored:
+		w.stored <- location
 	case err := <-werr:
 		w.err <- err
+		return err
 	case <-time.After(time.Second * time.Duration(timeout)):
 		w.err <- ErrConversionTimeout
+		return ErrConversionTimeout
 	}
+	return nil
 }
 
-// AWSS3Success returns a channel that will be used for publishing the output of a
+// Success returns a channel that will be used for publishing the output of a
 // conversion.
-func (w Work) AWSS3Success() <-chan []byte {
+func (w Work) Success() <-chan []byte {
 	return w.out
 }
 
-// QiniuSuccess  七牛上传成功
-func (w Work) QiniuSuccess() <-chan string {
-	return w.url
+// Stored returns a channel that will be used for publishing the location of
+// a conversion once it has been uploaded to its destination.
+func (w Work) Stored() <-chan string {
+	return w.stored
 }
 
 // Error returns a channel that will be used for publishing errors from a
@@ -130,15 +168,19 @@ func (w Work) Error() <-chan error {
 	return w.err
 }
 
-// Uploaded returns a channel that will be closed when a conversion has been
-// uploaded.
-func (w Work) Uploaded() <-chan struct{} {
-	return w.uploaded
+// Started returns a channel that will be closed when a worker has picked up
+// the job from the queue.
+func (w Work) Started() <-chan struct{} {
+	return w.started
 }
 
 // Cancel will close the done channel. This will indicate to child Goroutines
 // that the job has been terminated, and the results are no longer needed.
+// A work which is still pending is removed from the queue, freeing its slot.
 func (w Work) Cancel() {
+	if w.queue != nil {
+		w.queue.remove(w)
+	}
 	close(w.done)
 }
 
@@ -5,15 +5,17 @@ import (
 	"runtime"
 	"testing"
 	"time"
+
+	"github.com/arachnys/athenapdf/weaver/metrics"
 )
 
 func TestInitWorkers(t *testing.T) {
 	i := runtime.NumGoroutine()
-	wq := InitWorkers(10, 10, 10)
+	wq, _ := InitWorkers(10, 10, 10, SchedulerOptions{}, metrics.Discard)
 	if wq == nil {
 		t.Fatalf("expected work queue to be initialised, not nil")
 	}
-	defer close(wq)
+	defer wq.Close()
 	if got, want := runtime.NumGoroutine(), i+10; got != want {
 		t.Errorf("number of running goroutines is %+v, want %+v", got, want)
 	}
@@ -27,57 +29,89 @@ func (c TestConversion) Convert(s ConversionSource, done <-chan struct{}) ([]byt
 	return []byte("test work"), nil
 }
 
-func (c TestConversion) UploadAWSS3(b []byte) (bool, error) {
-	return true, nil
-}
-
-func (c TestConversion) UploadQiniu(b []byte) (bool, string, error) {
-
-	return true, "www.ok.com", nil
-}
-
 func TestNewWork(t *testing.T) {
-	wq := InitWorkers(10, 10, 10)
+	wq, _ := InitWorkers(10, 10, 10, SchedulerOptions{}, metrics.Discard)
 	c := TestConversion{}
 	s := ConversionSource{}
-	w := NewWork(wq, c, s)
+	w, err := NewWork(wq, c, s, Destination{}, Class{}, 0)
+	if err != nil {
+		t.Fatalf("newwork returned an unexpected error: %+v", err)
+	}
 	if w.out == nil {
 		t.Fatalf("expected work output channel to be initialised, not nil")
 	}
 	if w.err == nil {
 		t.Fatalf("expected work error channel to be initialised, not nil")
 	}
-	if w.uploaded == nil {
-		t.Fatalf("expected work uploaded channel to be initialised, not nil")
+	if w.stored == nil {
+		t.Fatalf("expected work stored channel to be initialised, not nil")
 	}
 	if w.done == nil {
 		t.Fatalf("expected work done channel to be initialised, not nil")
 	}
 }
 
-type TestConversionUpload struct {
-	Conversion
+type TestStorage struct {
+	objects map[string][]byte
+}
+
+func (s TestStorage) Put(key string, b []byte) error {
+	s.objects[key] = b
+	return nil
+}
+
+func (s TestStorage) Get(key string) ([]byte, error) {
+	return s.objects[key], nil
 }
 
-func (c TestConversionUpload) UploadAWSS3(b []byte) (bool, error) {
-	return true, nil
+func (s TestStorage) URL(key string) (string, error) {
+	return "http://www.ok.com/" + key, nil
 }
 
-func (c TestConversionUpload) UploadQiniu(b []byte) (bool, string, error) {
+func (s TestStorage) Delete(key string) error {
+	delete(s.objects, key)
+	return nil
+}
 
-	return true, "www.ok.com", nil
+func TestNewWork_success(t *testing.T) {
+	wq, _ := InitWorkers(10, 10, 10, SchedulerOptions{}, metrics.Discard)
+	defer wq.Close()
+	c := TestConversion{}
+	s := ConversionSource{}
+	w, err := NewWork(wq, c, s, Destination{}, Class{}, 0)
+	if err != nil {
+		t.Fatalf("newwork returned an unexpected error: %+v", err)
+	}
+	select {
+	case out := <-w.Success():
+		if got, want := string(out), "test work"; got != want {
+			t.Errorf("expected output of work to be %s, got %s", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected to receive output before timeout")
+	}
 }
 
 func TestNewWork_upload(t *testing.T) {
-	wq := InitWorkers(10, 10, 10)
-	defer close(wq)
-	c := TestConversionUpload{}
+	wq, _ := InitWorkers(10, 10, 10, SchedulerOptions{}, metrics.Discard)
+	defer wq.Close()
+	c := TestConversion{}
 	s := ConversionSource{}
-	w := NewWork(wq, c, s)
+	st := TestStorage{make(map[string][]byte)}
+	w, err := NewWork(wq, c, s, Destination{Storage: st, Key: "test.pdf"}, Class{}, 0)
+	if err != nil {
+		t.Fatalf("newwork returned an unexpected error: %+v", err)
+	}
 	select {
-	case <-w.Uploaded():
+	case location := <-w.Stored():
+		if got, want := location, "http://www.ok.com/test.pdf"; got != want {
+			t.Errorf("expected location of stored work to be %s, got %s", want, got)
+		}
+		if got, want := string(st.objects["test.pdf"]), "test work"; got != want {
+			t.Errorf("expected stored object to be %s, got %s", want, got)
+		}
 	case <-time.After(time.Second):
-		t.Errorf("expected work uploaded channel to be closed before timeout")
+		t.Errorf("expected work to be stored before timeout")
 	}
 }
 
@@ -93,21 +127,15 @@ func (c TestConversionError) Convert(s ConversionSource, done <-chan struct{}) (
 	return []byte{}, ErrTestConversionError
 }
 
-func (c TestConversionError) UploadAWSS3(b []byte) (bool, error) {
-	return true, nil
-}
-
-func (c TestConversionError) UploadQiniu(b []byte) (bool, string, error) {
-
-	return true, "www.ok.com", nil
-}
-
 func TestNewWork_error(t *testing.T) {
-	wq := InitWorkers(10, 10, 10)
-	defer close(wq)
+	wq, _ := InitWorkers(10, 10, 10, SchedulerOptions{}, metrics.Discard)
+	defer wq.Close()
 	c := TestConversionError{}
 	s := ConversionSource{}
-	w := NewWork(wq, c, s)
+	w, err := NewWork(wq, c, s, Destination{}, Class{}, 0)
+	if err != nil {
+		t.Fatalf("newwork returned an unexpected error: %+v", err)
+	}
 	select {
 	case <-w.Error():
 	case <-time.After(time.Second):
@@ -124,26 +152,42 @@ func (c TestConversionTimeout) Convert(s ConversionSource, done <-chan struct{})
 	return []byte("test work timeout"), nil
 }
 
-func (c TestConversionTimeout) UploadAWSS3(b []byte) (bool, error) {
-	return true, nil
-}
-
-func (c TestConversionTimeout) UploadQiniu(b []byte) (bool, string, error) {
-
-	return true, "www.ok.com", nil
-}
-
 func TestNewWork_timeout(t *testing.T) {
-	wq := InitWorkers(10, 10, 1)
-	defer close(wq)
+	wq, _ := InitWorkers(10, 10, 1, SchedulerOptions{}, metrics.Discard)
+	defer wq.Close()
 	c := TestConversionTimeout{}
 	s := ConversionSource{}
-	w := NewWork(wq, c, s)
-	go func(w Work) {
-		time.Sleep(time.Second * 2)
-		close(w.err)
-	}(w)
-	if got, want := <-w.Error(), ErrConversionTimeout; got != want {
-		t.Errorf("expected a conversion timeout error, got %+v", got)
+	w, err := NewWork(wq, c, s, Destination{}, Class{}, 0)
+	if err != nil {
+		t.Fatalf("newwork returned an unexpected error: %+v", err)
+	}
+	select {
+	case err := <-w.Error():
+		if err != ErrConversionTimeout {
+			t.Errorf("expected a conversion timeout error, got %+v", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Errorf("expected to receive error before timeout")
+	}
+}
+
+func TestNewWork_queueFull(t *testing.T) {
+	wq := NewWorkQueue(1, SchedulerOptions{})
+	c := TestConversion{}
+	s := ConversionSource{}
+	if _, err := NewWork(wq, c, s, Destination{}, Class{}, 0); err != nil {
+		t.Fatalf("newwork returned an unexpected error: %+v", err)
+	}
+	if _, err := NewWork(wq, c, s, Destination{}, Class{}, 0); err != ErrQueueFull {
+		t.Errorf("expected error to be %+v, got %+v", ErrQueueFull, err)
+	}
+
+	// A slot is freed while waiting
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		wq.pop()
+	}()
+	if _, err := NewWork(wq, c, s, Destination{}, Class{}, time.Second); err != nil {
+		t.Errorf("newwork returned an unexpected error: %+v", err)
 	}
 }
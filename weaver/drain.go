@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/gcmd"
+)
+
+// ErrShuttingDown should be returned when a conversion is rejected, or
+// aborted because the microservice is shutting down.
+var ErrShuttingDown = errors.New("weaver is shutting down")
+
+// shutdownGrace is the time given to aborted conversions to clean up (e.g.
+// remove their temporary files), and to respond.
+const shutdownGrace = 5 * time.Second
+
+// Drainer keeps track of in-flight conversions so that they can finish before
+// the microservice exits.
+type Drainer struct {
+	mu      sync.Mutex
+	closing bool
+	wg      sync.WaitGroup
+	abort   chan struct{}
+	// terminate kills the commands of the aborted conversions.
+	terminate func()
+}
+
+// NewDrainer creates, and returns a new Drainer.
+func NewDrainer() *Drainer {
+	return &Drainer{abort: make(chan struct{}), terminate: gcmd.Terminate}
+}
+
+// acquire adds an in-flight conversion. It returns false if the microservice
+// is shutting down.
+func (d *Drainer) acquire() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closing {
+		return false
+	}
+	d.wg.Add(1)
+	return true
+}
+
+// add adds an in-flight conversion which outlives the request that acquired
+// it (e.g. an asynchronous job). It must only be called by a request that has
+// not released its conversion yet, so it is accepted even when closing.
+func (d *Drainer) add() {
+	d.wg.Add(1)
+}
+
+// release removes an in-flight conversion.
+func (d *Drainer) release() {
+	d.wg.Done()
+}
+
+// Closing returns true once the microservice has started shutting down.
+func (d *Drainer) Closing() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closing
+}
+
+// Aborted returns a channel that will be closed when the in-flight
+// conversions have not finished before the shutdown deadline.
+func (d *Drainer) Aborted() <-chan struct{} {
+	return d.abort
+}
+
+// Drain stops accepting new conversions, and waits for the in-flight ones to
+// finish. Once the timeout expires, the remaining conversions are aborted,
+// and their commands are killed. It returns false if any conversion has been
+// aborted.
+func (d *Drainer) Drain(timeout time.Duration) bool {
+	d.mu.Lock()
+	d.closing = true
+	d.mu.Unlock()
+
+	if waitTimeout(&d.wg, timeout) {
+		return true
+	}
+
+	close(d.abort)
+	d.terminate()
+	waitTimeout(&d.wg, shutdownGrace)
+	return false
+}
+
+// waitTimeout waits for a WaitGroup, and returns false if it has not finished
+// before the timeout.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Shutdown gracefully shuts down the servers: they stop accepting new
+// connections, and the in-flight conversions are drained (see Drain).
+func Shutdown(d *Drainer, timeout time.Duration, servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+shutdownGrace)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Println(err)
+			}
+		}(srv)
+	}
+
+	if !d.Drain(timeout) {
+		log.Println("shutdown timeout exceeded, in-flight conversions have been aborted")
+	}
+	wg.Wait()
+}
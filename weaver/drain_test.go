@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDrainerDrain(t *testing.T) {
+	d := NewDrainer()
+	if !d.acquire() {
+		t.Fatalf("expected conversion to be acquired")
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		d.release()
+	}()
+
+	if !d.Drain(time.Second) {
+		t.Errorf("expected in-flight conversion to be drained")
+	}
+	if d.acquire() {
+		t.Errorf("expected new conversions to be rejected after draining")
+	}
+	select {
+	case <-d.Aborted():
+		t.Errorf("expected drained conversions not to be aborted")
+	default:
+	}
+}
+
+func TestDrainerDrain_abort(t *testing.T) {
+	d := NewDrainer()
+	var terminated bool
+	d.terminate = func() { terminated = true }
+	d.acquire()
+	// Released once aborted
+	go func() {
+		<-d.Aborted()
+		d.release()
+	}()
+
+	if d.Drain(10 * time.Millisecond) {
+		t.Errorf("expected in-flight conversion to be aborted")
+	}
+	if !terminated {
+		t.Errorf("expected commands to be terminated")
+	}
+}
+
+func TestConversionMiddleware(t *testing.T) {
+	d := NewDrainer()
+	r := gin.Default()
+	r.Use(DrainerMiddleware(d))
+	r.GET("/", ConversionMiddleware(), func(c *gin.Context) {
+		if d.acquire() {
+			d.release()
+		}
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+
+	d.Drain(time.Second)
+	res = httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("expected response code to be %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+)
+
+// conversions coalesces the identical conversions in flight.
+var conversions = newFlightGroup()
+
+// flightGroup coalesces concurrent identical conversions (single-flight): the
+// first request runs the conversion, and the requests which arrive while it
+// is running wait for the same result instead of running their own.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+// flight is a conversion in flight.
+type flight struct {
+	waiters int
+	// cancel is closed when every waiter has gone.
+	cancel chan struct{}
+	// done is closed once res is set.
+	done chan struct{}
+	res  conversionResult
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flight)}
+}
+
+// do runs fn once for all the concurrent callers with the same key, and
+// returns its result. A caller stops waiting when its done channel is
+// closed, and fn is cancelled (through its cancel channel) only when the last
+// caller has stopped waiting. shared is true if the caller has joined a
+// conversion started by another caller.
+func (g *flightGroup) do(key string, done <-chan struct{}, fn func(cancel <-chan struct{}) conversionResult) (res conversionResult, shared bool) {
+	g.mu.Lock()
+	f, shared := g.calls[key]
+	if !shared {
+		f = &flight{cancel: make(chan struct{}), done: make(chan struct{})}
+		g.calls[key] = f
+		go func() {
+			f.res = fn(f.cancel)
+			g.mu.Lock()
+			if g.calls[key] == f {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.res, shared
+	case <-done:
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f.waiters--
+	if f.waiters == 0 {
+		// New requests must not join a cancelled conversion
+		if g.calls[key] == f {
+			delete(g.calls, key)
+		}
+		close(f.cancel)
+	}
+	return conversionResult{cancelled: true}, shared
+}
+
+// len returns the number of conversions in flight.
+func (g *flightGroup) len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.calls)
+}
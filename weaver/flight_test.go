@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockWaiters returns the number of callers waiting for a conversion.
+func mockWaiters(g *flightGroup, key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.calls[key]; ok {
+		return f.waiters
+	}
+	return 0
+}
+
+func TestFlightGroup(t *testing.T) {
+	g := newFlightGroup()
+	var calls int32
+	release := make(chan struct{})
+	fn := func(cancel <-chan struct{}) conversionResult {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return conversionResult{out: []byte("%PDF")}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		shared int32
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, ok := g.do("abc", nil, fn)
+			if string(res.out) != "%PDF" {
+				t.Errorf("expected output to be %%PDF, got %s", res.out)
+			}
+			if ok {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+	// Wait for every caller to join the conversion
+	for mockWaiters(g, "abc") < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if got, want := atomic.LoadInt32(&calls), int32(1); got != want {
+		t.Errorf("expected conversion to run %d time, got %d", want, got)
+	}
+	if got, want := atomic.LoadInt32(&shared), int32(4); got != want {
+		t.Errorf("expected %d callers to share the conversion, got %d", want, got)
+	}
+	if got := g.len(); got != 0 {
+		t.Errorf("expected no conversion in flight, got %d", got)
+	}
+}
+
+func TestFlightGroup_cancel(t *testing.T) {
+	g := newFlightGroup()
+	cancelled := make(chan struct{})
+	fn := func(cancel <-chan struct{}) conversionResult {
+		<-cancel
+		close(cancelled)
+		return conversionResult{cancelled: true}
+	}
+
+	first, second := make(chan struct{}), make(chan struct{})
+	results := make(chan conversionResult, 2)
+	go func() {
+		res, _ := g.do("abc", first, fn)
+		results <- res
+	}()
+	for g.len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		res, _ := g.do("abc", second, fn)
+		results <- res
+	}()
+	for mockWaiters(g, "abc") < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(first)
+	if res := <-results; !res.cancelled {
+		t.Errorf("expected the first caller to stop waiting")
+	}
+	select {
+	case <-cancelled:
+		t.Fatalf("expected the conversion to keep running for the second caller")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(second)
+	<-results
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("expected the conversion to be cancelled when the last caller has gone")
+	}
+}
@@ -6,12 +6,55 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"sync"
 )
 
 var (
 	ErrCmdTerminated = errors.New("command terminated")
 )
 
+// running contains the commands which have been started by Execute, and have
+// not exited yet. They are killed by Terminate.
+var running = struct {
+	sync.Mutex
+	cmds       map[*exec.Cmd]struct{}
+	terminated bool
+}{cmds: make(map[*exec.Cmd]struct{})}
+
+// start starts a command, and keeps track of it until wait is called.
+func start(cmd *exec.Cmd) error {
+	running.Lock()
+	defer running.Unlock()
+	if running.terminated {
+		return ErrCmdTerminated
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	running.cmds[cmd] = struct{}{}
+	return nil
+}
+
+func wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	running.Lock()
+	delete(running.cmds, cmd)
+	running.Unlock()
+	return err
+}
+
+// Terminate kills all the running commands, and it prevents new commands from
+// being executed. It should only be called when the process is exiting.
+func Terminate() {
+	running.Lock()
+	defer running.Unlock()
+	running.terminated = true
+	for cmd := range running.cmds {
+		log.Printf("killing %s (pid %d)\n", cmd.Path, cmd.Process.Pid)
+		cmd.Process.Kill()
+	}
+}
+
 // Execute is a concurrent wrapper around Go's os/exec Output() method.
 // It runs a command, and returns its standard output as a byte slice.
 // If a long-running command is being executed, it can easily be killed at
@@ -21,16 +64,26 @@ func Execute(c []string, terminate <-chan struct{}) ([]byte, error) {
 	cmd := exec.Command(c[0], c[1:]...)
 	cout := make(chan []byte, 1)
 	cerr := make(chan error, 1)
+	started := make(chan struct{})
 
 	go func(cmd *exec.Cmd, cout chan<- []byte, cerr chan<- error) {
-		var stderr bytes.Buffer
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
 		cmd.Stderr = &stderr
-		out, err := cmd.Output()
+		err := start(cmd)
+		close(started)
+		if err == ErrCmdTerminated {
+			cerr <- err
+			return
+		}
+		if err == nil {
+			err = wait(cmd)
+		}
 		if err != nil {
 			cerr <- fmt.Errorf("%+v : %+v", err, stderr.String())
 			return
 		}
-		cout <- out
+		cout <- stdout.Bytes()
 	}(cmd, cout, cerr)
 
 	select {
@@ -42,10 +95,13 @@ func Execute(c []string, terminate <-chan struct{}) ([]byte, error) {
 		return nil, err
 	case <-terminate:
 		log.Println("exiting")
+		// Wait for the command to be started to avoid racing with it
+		<-started
 		// if (cmd.ProcessState == nil || cmd.ProcessState.Exited() == false) && cmd.Process != nil {
 		if cmd.Process != nil {
+			// The command may have exited in the meantime
 			if err := cmd.Process.Kill(); err != nil {
-				return nil, err
+				log.Println(err)
 			}
 		}
 		return nil, ErrCmdTerminated
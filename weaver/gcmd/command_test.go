@@ -3,6 +3,7 @@ package gcmd
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestExecute(t *testing.T) {
@@ -44,3 +45,42 @@ func TestExecute_done(t *testing.T) {
 		t.Errorf("expected output of executed command to be nil, got %+v", got)
 	}
 }
+
+func TestTerminate(t *testing.T) {
+	defer func() {
+		running.Lock()
+		running.terminated = false
+		running.Unlock()
+	}()
+
+	mockTerminate := make(chan struct{}, 1)
+	cerr := make(chan error, 1)
+	go func() {
+		_, err := Execute([]string{"sleep", "10"}, mockTerminate)
+		cerr <- err
+	}()
+	// Wait for the command to be started
+	for i := 0; i < 100; i++ {
+		running.Lock()
+		n := len(running.cmds)
+		running.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Terminate()
+	select {
+	case err := <-cerr:
+		if err == nil {
+			t.Errorf("expected error to be returned")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected command to be killed before timeout")
+	}
+
+	if _, err := Execute([]string{"echo", "test"}, mockTerminate); err != ErrCmdTerminated {
+		t.Errorf("expected error to be %+v, got %+v", ErrCmdTerminated, err)
+	}
+}
@@ -3,17 +3,22 @@ package main
 import (
 	"errors"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"path"
 	"runtime"
+	"strconv"
+	"time"
 
+	"github.com/arachnys/athenapdf/weaver/auth"
+	"github.com/arachnys/athenapdf/weaver/cache"
 	"github.com/arachnys/athenapdf/weaver/converter"
-	"github.com/arachnys/athenapdf/weaver/converter/athenapdf"
-	"github.com/arachnys/athenapdf/weaver/converter/cloudconvert"
-	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/arachnys/athenapdf/weaver/metrics"
+	"github.com/arachnys/athenapdf/weaver/storage"
 	"github.com/getsentry/raven-go"
 	"github.com/gin-gonic/gin"
-	"gopkg.in/alexcesaro/statsd.v2"
+	"github.com/satori/go.uuid"
 )
 
 var (
@@ -23,141 +28,456 @@ var (
 	ErrFileInvalid   = errors.New("invalid file provided")
 	ErrDomainInvalid = errors.New("invalid domain provided")
 	ErrKeyInvalid    = errors.New("invalid Key provided")
+	// ErrStorageInvalid should be returned when a storage backend does not
+	// exist.
+	ErrStorageInvalid = errors.New("invalid storage backend provided")
+	// ErrPriorityInvalid should be returned when a priority is not one of
+	// converter.Priorities.
+	ErrPriorityInvalid = errors.New("invalid priority provided")
+	// ErrConverterInvalid should be returned when a converter does not exist.
+	ErrConverterInvalid = errors.New("invalid converter provided")
 )
 
+// sourceErrors maps the errors returned when a conversion source is rejected
+// to their HTTP status code.
+var sourceErrors = map[error]int{
+	converter.ErrURLForbidden:     http.StatusForbidden,
+	converter.ErrSourceTooLarge:   http.StatusRequestEntityTooLarge,
+	converter.ErrSourceType:       http.StatusUnsupportedMediaType,
+	converter.ErrFetchTimeout:     http.StatusRequestTimeout,
+	converter.ErrTooManyRedirects: http.StatusBadRequest,
+}
+
+// multipartOverhead is the room left for the multipart headers, and other
+// form fields when limiting the size of a request body.
+const multipartOverhead = 1 << 20
+
+// abortSourceError aborts the request with a public error if a conversion
+// source has been rejected. It returns false if it is any other error.
+func abortSourceError(c *gin.Context, err error) bool {
+	code, ok := sourceErrors[err]
+	if !ok {
+		return false
+	}
+	c.AbortWithError(code, err).SetType(gin.ErrorTypePublic)
+	c.MustGet("metrics").(metrics.Metrics).Error("rejected_source")
+	return true
+}
+
 // indexHandler returns a JSON string indicating that the microservice is online.
-// It does not actually check if conversions are working. It is nevertheless,
-// used for monitoring.
+// It does not actually check if conversions are working (see readyzHandler).
+// It is nevertheless, used for monitoring.
 func indexHandler(c *gin.Context) {
 	// We can do better than this...
 	c.JSON(http.StatusOK, gin.H{"status": "online"})
 }
 
+// workerStates returns the state of every worker for statsHandler. The URI,
+// and the tenant of a conversion are only shown to its own tenant, and they
+// are never shown to an unauthorized request.
+func workerStates(st converter.Stats, tenant string, authorized bool) []gin.H {
+	workers := make([]gin.H, len(st.Workers))
+	for i, w := range st.Workers {
+		state := "idle"
+		if w.Busy {
+			state = "busy"
+		}
+		if !authorized || w.Tenant != tenant {
+			w.URI, w.Tenant = "", ""
+		}
+		workers[i] = gin.H{
+			"id":        w.ID,
+			"state":     state,
+			"uri":       w.URI,
+			"tenant":    w.Tenant,
+			"elapsed":   w.Elapsed.Seconds(),
+			"completed": w.Completed,
+		}
+	}
+	return workers
+}
+
 // statsHandler returns a JSON string containing the number of running
-// Goroutines, and pending jobs in the work queue.
+// Goroutines, pending jobs in the work queue, the state of every worker, and
+// the outcome of the conversions since start. The conversions of other
+// tenants are redacted (see workerStates).
+// Durations are in seconds.
 func statsHandler(c *gin.Context) {
-	q := c.MustGet("queue").(chan<- converter.Work)
+	q := c.MustGet("queue").(*converter.WorkQueue)
+	st := c.MustGet("workers").(*converter.WorkerStats).Snapshot()
+	_, authorized := c.Get("key")
+	workers := workerStates(st, c.GetString("tenant"), authorized)
+
 	c.JSON(http.StatusOK, gin.H{
 		"goroutines": runtime.NumGoroutine(),
-		"pending":    len(q),
+		"pending":    q.Len(),
+		"workers":    workers,
+		"totals": gin.H{
+			"successes":  st.Successes,
+			"failures":   st.Failures,
+			"timeouts":   st.Timeouts,
+			"fallbacks":  st.Fallbacks,
+			"rejections": st.Rejections,
+		},
+		"durations": gin.H{
+			"p50": st.P50.Seconds(),
+			"p95": st.P95.Seconds(),
+		},
 	})
 }
 
-func conversionHandler(c *gin.Context, source converter.ConversionSource) {
-	// GC if converting temporary file
-	log.Println("转换资源位置 URI: ", source.URI)
+// abortQueueFull responds with 503 Service Unavailable when the work queue is
+// full. The Retry-After header is the median duration of a conversion.
+func abortQueueFull(c *gin.Context) {
+	st := c.MustGet("workers").(*converter.WorkerStats).Snapshot()
+	retry := int(math.Ceil(st.P50.Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retry))
+	c.AbortWithError(http.StatusServiceUnavailable, converter.ErrQueueFull).SetType(gin.ErrorTypePublic)
+}
+
+// storageDestination returns the destination for a storage backend in the
+// registry. A random key is used if the key is empty. The key is prefixed with
+// the namespace of the request (see storageNamespace). The output is returned
+// to the client if the name is empty.
+func storageDestination(c *gin.Context, name, key string) (converter.Destination, error) {
+	if name == "" {
+		return converter.Destination{}, nil
+	}
+
+	r := c.MustGet("storage").(*storage.Registry)
+	st, err := r.Get(name)
+	if err != nil {
+		return converter.Destination{}, ErrStorageInvalid
+	}
+
+	if key == "" {
+		u, err := uuid.NewV4()
+		if err != nil {
+			return converter.Destination{}, err
+		}
+		key = u.String() + ".pdf"
+	}
+	// Relative segments are resolved, so that a key cannot escape its
+	// namespace (e.g. '../acme/invoice.pdf')
+	key = path.Clean("/" + key)[1:]
+	if ns := storageNamespace(c); ns != "" {
+		key = ns + "/" + key
+	}
+
+	log.Printf("[Storage] uploading to %s with key: %s\n", name, key)
+	return converter.Destination{Storage: st, Key: key, Name: name}, nil
+}
 
-	if source.IsLocal {
-		defer os.Remove(source.URI)
+// storageNamespace returns the prefix of the storage keys of a request: the
+// tenant of the authorization key, or else its ID, so that a client cannot
+// overwrite the objects of another tenant. It is empty without a key file.
+func storageNamespace(c *gin.Context) string {
+	if t := c.GetString("tenant"); t != "" {
+		return t
 	}
+	if k, ok := c.Get("key"); ok {
+		return k.(auth.Key).ID
+	}
+	return ""
+}
 
+// newDestination returns the destination for the output of a conversion.
+// The legacy S3 query parameters take precedence over a storage backend from
+// the registry. The output is returned to the client if neither is set.
+func newDestination(c *gin.Context) (converter.Destination, error) {
+	if bucket, key := c.Query("s3_bucket"), c.Query("s3_key"); bucket != "" && key != "" {
+		st := storage.S3{
+			Region:       c.Query("aws_region"),
+			AccessKey:    c.Query("aws_id"),
+			AccessSecret: c.Query("aws_secret"),
+			Bucket:       bucket,
+			ACL:          c.Query("s3_acl"),
+		}
+		return converter.Destination{Storage: st, Key: key}, nil
+	}
+
+	conf := c.MustGet("config").(Config)
+	return storageDestination(c, c.DefaultQuery("storage", conf.Storage), c.Query("storage_key"))
+}
+
+// queryConversionOptions parses the conversion options in the query
+// parameters, and validates them.
+func queryConversionOptions(c *gin.Context) (converter.ConversionOptions, error) {
+	o := converter.ConversionOptions{
+		PageSize:    c.Query("page_size"),
+		Margins:     c.Query("margins"),
+		Orientation: c.Query("orientation"),
+		HeaderHTML:  c.Query("header_html"),
+		FooterHTML:  c.Query("footer_html"),
+	}
+
+	var err error
+	if zoom := c.Query("zoom"); zoom != "" {
+		if o.Zoom, err = strconv.Atoi(zoom); err != nil {
+			return o, &converter.OptionError{Option: "zoom"}
+		}
+	}
+	if delay := c.Query("delay"); delay != "" {
+		if o.Delay, err = strconv.Atoi(delay); err != nil {
+			return o, &converter.OptionError{Option: "delay"}
+		}
+	}
+	if o.NoBackground, err = queryFlag(c, "no_background"); err != nil {
+		return o, err
+	}
+	if o.TOC, err = queryFlag(c, "toc"); err != nil {
+		return o, err
+	}
+
+	return o, o.Validate()
+}
+
+// queryFlag parses a boolean conversion option in the query parameters.
+// A flag without a value (e.g. '?no_background') is true.
+func queryFlag(c *gin.Context, name string) (bool, error) {
+	v, ok := c.GetQuery(name)
+	if !ok || v == "" {
+		return ok, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &converter.OptionError{Option: name}
+	}
+	return b, nil
+}
+
+// newConversionRequest creates a conversionRequest for a source using the
+// conversion, upload, and callback options in the query parameters.
+// It returns false if the request has been aborted.
+func newConversionRequest(c *gin.Context, source converter.ConversionSource) (conversionRequest, bool) {
 	_, aggressive := c.GetQuery("aggressive")
 
+	callbackURL := c.Query("callback_url")
 	conf := c.MustGet("config").(Config)
-	wq := c.MustGet("queue").(chan<- converter.Work)
-	s := c.MustGet("statsd").(*statsd.Client)
-	r, ravenOk := c.Get("sentry")
+	if callbackURL != "" && !validCallbackURL(conf.URLPolicy, callbackURL) {
+		c.AbortWithError(http.StatusBadRequest, ErrCallbackURLInvalid).SetType(gin.ErrorTypePublic)
+		return conversionRequest{}, false
+	}
 
-	newTiming := s.NewTiming()
-
-	awsConf := converter.AWSS3{
-		Region:       c.Query("aws_region"),
-		AccessKey:    c.Query("aws_id"),
-		AccessSecret: c.Query("aws_secret"),
-		S3Bucket:     c.Query("s3_bucket"),
-		S3Key:        c.Query("s3_key"),
-		S3Acl:        c.Query("s3_acl"),
-	}
-
-	log.Printf("待转数量: %d\n", len(wq))
-	log.Printf("statsd client 信息: %+v\n", s)
-	log.Printf("sentry 信息: %v  %t\n", r, ravenOk)
-	log.Printf("AWSS3 配置: %+v\n", awsConf)
-	log.Printf("newTiming: %v", newTiming)
-
-	var conversion converter.Converter
-	var work converter.Work
-	attempts := 0
-
-	baseConversion := converter.Conversion{}
-	uploadConversion := converter.UploadConversion{Conversion: baseConversion, AWSS3: awsConf}
-
-StartConversion:
-	conversion = athenapdf.AthenaPDF{UploadConversion: uploadConversion, CMD: conf.AthenaCMD, Aggressive: aggressive}
-	if attempts != 0 {
-		cc := cloudconvert.Client{BaseURL: conf.CloudConvert.APIUrl, APIKey: conf.CloudConvert.APIKey}
-		conversion = cloudconvert.CloudConvert{UploadConversion: uploadConversion, Client: cc}
-	}
-	work = converter.NewWork(wq, conversion, source)
-
-	select {
-	case <-c.Writer.CloseNotify():
-		work.Cancel()
-	case <-work.Uploaded():
-		newTiming.Send("conversion_duration")
-		s.Increment("success")
-		c.JSON(200, gin.H{"status": "uploaded"})
-	case out := <-work.AWSS3Success():
-		newTiming.Send("AWS S3 conversion_duration")
-		s.Increment("success")
-		c.Data(200, "application/pdf", out)
-	case url := <-work.QiniuSuccess():
-		newTiming.Send("Qiniu conversion_duration")
-		s.Increment("success")
+	o, err := queryConversionOptions(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
+		return conversionRequest{}, false
+	}
+	source.Options = o
 
-		urlData := struct{ URL string }{
-			URL: url,
+	cl, err := requestClass(requestTenant(c, c.Query("tenant")), c.Query("priority"))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
+		return conversionRequest{}, false
+	}
+
+	name := c.Query("converter")
+	if !checkConverter(c, name, source, aggressive) {
+		return conversionRequest{}, false
+	}
+
+	d, err := newDestination(c)
+	if err == ErrStorageInvalid {
+		c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
+		return conversionRequest{}, false
+	} else if err != nil {
+		c.Error(err)
+		return conversionRequest{}, false
+	}
+
+	return conversionRequest{
+		source:      source,
+		aggressive:  aggressive,
+		converter:   name,
+		destination: d,
+		callbackURL: callbackURL,
+		class:       cl,
+	}, true
+}
+
+// checkConverter aborts the request with 400 Bad Request if the converter it
+// asks for (if any) does not exist, or does not support the conversion. It
+// returns false if the request has been aborted.
+func checkConverter(c *gin.Context, name string, source converter.ConversionSource, aggressive bool) bool {
+	if name == "" {
+		return true
+	}
+	err := c.MustGet("converters").(*converter.Registry).Supports(name, source, aggressive)
+	if err == converter.ErrUnknownConverter {
+		err = ErrConverterInvalid
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
+		return false
+	}
+	return true
+}
+
+// requestClass returns the scheduling class of a conversion for a tenant, and
+// a priority. The priority is left empty if it is not set, so that it
+// defaults to bulk for jobs, and interactive otherwise.
+func requestClass(tenant, priority string) (converter.Class, error) {
+	cl := converter.Class{Tenant: tenant, Priority: converter.Priority(priority)}
+	if !converter.ValidPriority(cl.Priority) {
+		return cl, ErrPriorityInvalid
+	}
+	return cl, nil
+}
+
+// requestTenant returns the tenant of the authorization key (see
+// AuthorizationMiddleware). If the key does not belong to a tenant, the tenant
+// provided by the client is namespaced under the ID of the key (e.g.
+// 'default/acme'), so that a client cannot take the tenant of another key
+// (its share of the workers, its jobs, or its stats). It is ignored for keys
+// without an ID.
+func requestTenant(c *gin.Context, tenant string) string {
+	if t := c.GetString("tenant"); t != "" {
+		return t
+	}
+	k, ok := c.Get("key")
+	if !ok || tenant == "" {
+		return ""
+	}
+	if id := k.(auth.Key).ID; id != "" {
+		return id + "/" + tenant
+	}
+	return ""
+}
+
+// conversionHandler runs a conversion for a source using the options in the
+// query parameters.
+func conversionHandler(c *gin.Context, source converter.ConversionSource) {
+	req, ok := newConversionRequest(c, source)
+	if !ok {
+		if source.IsLocal {
+			os.Remove(source.URI)
 		}
+		return
+	}
+	runConversion(c, req)
+}
 
-		// 有色网前端固定格式 code + msg + data
-		c.JSON(200, gin.H{
-			"code": 0,
-			"msg":  "OK",
-			"data": urlData,
-		})
-	case err := <-work.Error():
-		log.Println(err)
-
-		// Log, and stats collection
-		if err == converter.ErrConversionTimeout {
-			s.Increment("conversion_timeout")
-		} else if _, awsError := err.(awserr.Error); awsError {
-			s.Increment("s3_upload_error")
-			if ravenOk {
-				r.(*raven.Client).CaptureError(err, map[string]string{"url": source.GetActualURI()})
+// runConversion runs a conversion request, and responds with its output.
+// Requests with a callback URL are run asynchronously (see queueJob).
+// Concurrent identical requests share a single conversion, which is only
+// cancelled once all of their clients have disconnected.
+func runConversion(c *gin.Context, req conversionRequest) {
+	// Conversions with a callback are run asynchronously
+	if req.callbackURL != "" {
+		queueJob(c, req)
+		return
+	}
+
+	log.Println("转换资源位置 URI: ", req.source.URI)
+
+	// Identical conversions are served from the result cache (if enabled),
+	// and coalesced with the identical conversions in flight
+	var (
+		rc  cache.Cache
+		key string
+	)
+	if cacheable(req) {
+		k, err := cacheKey(req)
+		if err != nil {
+			if req.source.IsLocal {
+				os.Remove(req.source.URI)
 			}
-		} else {
-			s.Increment("conversion_error")
-			if ravenOk {
-				r.(*raven.Client).CaptureError(err, map[string]string{"url": source.GetActualURI()})
+			c.Error(err)
+			return
+		}
+		key = k
+	}
+	if v, ok := c.Get("cache"); ok && key != "" {
+		rc = v.(cache.Cache)
+		if e, ok := rc.Get(key); ok && !noCache(c) {
+			if req.source.IsLocal {
+				os.Remove(req.source.URI)
 			}
+			c.MustGet("metrics").(metrics.Metrics).Increment("cache_hit")
+			serveEntry(c, e)
+			return
 		}
+		c.MustGet("metrics").(metrics.Metrics).Increment("cache_miss")
+	}
+
+	r := newRunner(c)
+	log.Printf("待转数量: %d\n", r.wq.Len())
 
-		if attempts == 0 && conf.ConversionFallback {
-			s.Increment("cloudconvert")
-			log.Println("falling back to CloudConvert...")
-			attempts++
-			goto StartConversion
+	convert := func(cancel <-chan struct{}) conversionResult {
+		// GC if converting temporary file
+		if req.source.IsLocal {
+			defer os.Remove(req.source.URI)
 		}
+		res := r.run(req, cancel, nil)
+		if rc != nil && res.out != nil {
+			if err := rc.Put(key, cache.NewEntry(res.out, time.Second*time.Duration(r.conf.CacheTTL))); err != nil {
+				log.Println(err)
+			}
+		}
+		return res
+	}
 
-		s.Increment("conversion_failed")
+	var (
+		res    conversionResult
+		shared bool
+	)
+	if key == "" {
+		res = convert(c.Request.Context().Done())
+	} else {
+		res, shared = conversions.do(key, c.Request.Context().Done(), convert)
+		if shared {
+			// The conversion runs with the source of the first request
+			if req.source.IsLocal {
+				os.Remove(req.source.URI)
+			}
+			r.m.Increment("coalesced")
+		}
+	}
+	// Only the request running the conversion counts against the quota of
+	// its key (see RateLimitMiddleware)
+	if res.admitted && !shared {
+		c.Set("admitted", true)
+	}
 
-		if err == converter.ErrConversionTimeout {
-			c.AbortWithError(http.StatusGatewayTimeout, converter.ErrConversionTimeout).SetType(gin.ErrorTypePublic)
-			return
+	switch {
+	case res.cancelled:
+	case res.location != "":
+		urlData := struct{ URL string }{
+			URL: res.location,
 		}
 
-		c.Error(err)
+		// 有色网前端固定格式 code + msg + data
+		c.JSON(200, gin.H{
+			"code": 0,
+			"msg":  "OK",
+			"data": urlData,
+		})
+	case res.err == converter.ErrQueueFull:
+		abortQueueFull(c)
+	case res.err == ErrShuttingDown:
+		c.AbortWithError(http.StatusServiceUnavailable, ErrShuttingDown).SetType(gin.ErrorTypePublic)
+	case res.err == converter.ErrNoConverter:
+		c.AbortWithError(http.StatusBadRequest, res.err).SetType(gin.ErrorTypePublic)
+	case res.err == converter.ErrConversionTimeout:
+		c.AbortWithError(http.StatusGatewayTimeout, converter.ErrConversionTimeout).SetType(gin.ErrorTypePublic)
+	case res.err != nil:
+		c.Error(res.err)
+	case rc != nil:
+		serveEntry(c, cache.NewEntry(res.out, time.Second*time.Duration(r.conf.CacheTTL)))
+	default:
+		c.Data(200, "application/pdf", res.out)
 	}
 }
 
-// convertByURLHandler is the main v1 API handler for converting a HTML to a PDF
-// via a GET request. It can either return a JSON string indicating that the
-// output of the conversion has been uploaded or it can return the output of
-// the conversion to the client (raw bytes).
-func convertByURLHandler(c *gin.Context) {
+// urlSource creates a ConversionSource from the URL (and login) query
+// parameters. It returns nil if the request has been aborted.
+func urlSource(c *gin.Context) *converter.ConversionSource {
 	var token, domain, key string
-	s := c.MustGet("statsd").(*statsd.Client)
+	s := c.MustGet("metrics").(metrics.Metrics)
 	r, ravenOk := c.Get("sentry")
 
 	url := c.Query("url")
@@ -169,63 +489,105 @@ func convertByURLHandler(c *gin.Context) {
 		if url == "" {
 			c.AbortWithError(http.StatusBadRequest, ErrURLInvalid).SetType(gin.ErrorTypePublic)
 			s.Increment("invalid_url")
-			return
+			return nil
 		}
 
 		domain = c.Query("domain")
 		if domain == "" {
 			c.AbortWithError(http.StatusBadRequest, ErrDomainInvalid).SetType(gin.ErrorTypePublic)
 			s.Increment("invalid_domain")
-			return
+			return nil
 		}
 
 		key = c.Query("key")
 		if key == "" {
 			c.AbortWithError(http.StatusBadRequest, ErrKeyInvalid).SetType(gin.ErrorTypePublic)
 			s.Increment("invalid_key")
-			return
+			return nil
 		}
 	}
 
 	log.Printf("输入参数 url: %s  token: %s  扩展名: %s  域名: %s  TokenKey: %s  needLogin: %s\n", url, token, ext, domain, key, needLogin)
 
-	source, err := converter.NewConversionSource(url, token, key, domain, ext, nil)
-	if err != nil {
-		s.Increment("conversion_error")
+	conf := c.MustGet("config").(Config)
+	fo := converter.FetchOptions{Policy: &conf.URLPolicy}
+	if needLogin == "true" {
+		fo.Cookies = []*http.Cookie{{Name: key, Value: token}}
+		fo.Domain = domain
+	}
+
+	source, err := converter.NewConversionSource(url, fo, conf.Limits, ext, nil)
+	if abortSourceError(c, err) {
+		return nil
+	} else if err != nil {
+		s.Error("conversion_error")
 		if ravenOk {
 			r.(*raven.Client).CaptureError(err, map[string]string{"url": url})
 		}
 		c.Error(err)
-		return
+		return nil
 	}
 
-	conversionHandler(c, *source)
+	return source
 }
 
-func convertByFileHandler(c *gin.Context) {
-	s := c.MustGet("statsd").(*statsd.Client)
+// fileSource creates a ConversionSource from the multipart file in the
+// request. It returns nil if the request has been aborted.
+func fileSource(c *gin.Context) *converter.ConversionSource {
+	s := c.MustGet("metrics").(metrics.Metrics)
 	r, ravenOk := c.Get("sentry")
+	conf := c.MustGet("config").(Config)
+
+	// Do not buffer more than the maximum size (to disk) if the request
+	// body is too large
+	if max := conf.Limits.MaxSize; max > 0 {
+		if c.Request.ContentLength > max+multipartOverhead {
+			abortSourceError(c, converter.ErrSourceTooLarge)
+			return nil
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max+multipartOverhead)
+	}
 
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, ErrFileInvalid).SetType(gin.ErrorTypePublic)
 		s.Increment("invalid_file")
-		return
+		return nil
 	}
 
 	ext := c.Query("ext")
 
 	log.Printf("输入参数 filename: %s  大小: %d  扩展名: %s\n", header.Filename, header.Size, ext)
 
-	source, err := converter.NewConversionSource("", "", "", "", ext, file)
-	if err != nil {
-		s.Increment("conversion_error")
+	source, err := converter.NewConversionSource("", converter.FetchOptions{}, conf.Limits, ext, file)
+	if abortSourceError(c, err) {
+		return nil
+	} else if err != nil {
+		s.Error("conversion_error")
 		if ravenOk {
 			r.(*raven.Client).CaptureError(err, map[string]string{"url": header.Filename})
 		}
 		c.Error(err)
-		return
+		return nil
+	}
+
+	return source
+}
+
+// convertByURLHandler is the main v1 API handler for converting a HTML to a PDF
+// via a GET request. It can either return a JSON string indicating that the
+// output of the conversion has been uploaded or it can return the output of
+// the conversion to the client (raw bytes).
+func convertByURLHandler(c *gin.Context) {
+	if source := urlSource(c); source != nil {
+		conversionHandler(c, *source)
 	}
+}
 
-	conversionHandler(c, *source)
+// convertByFileHandler converts a HTML file uploaded via a multipart POST
+// request. See convertByURLHandler for the possible responses.
+func convertByFileHandler(c *gin.Context) {
+	if source := fileSource(c); source != nil {
+		conversionHandler(c, *source)
+	}
 }
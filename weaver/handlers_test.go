@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arachnys/athenapdf/weaver/auth"
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAbortQueueFull(t *testing.T) {
+	r := gin.Default()
+	r.Use(WorkerStatsMiddleware(converter.NewWorkerStats(1)))
+	r.Use(ErrorMiddleware())
+	r.GET("/", abortQueueFull)
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	if got, want := res.Header().Get("Retry-After"), "1"; got != want {
+		t.Errorf("expected Retry-After header to be %s, got %s", want, got)
+	}
+}
+
+func TestWorkerStates(t *testing.T) {
+	st := converter.Stats{Workers: []converter.WorkerState{
+		{ID: 0, Busy: true, URI: "http://acme.example.com/invoice", Tenant: "acme"},
+		{ID: 1, Busy: true, URI: "http://initech.example.com/report", Tenant: "initech"},
+	}}
+	workers := workerStates(st, "acme", true)
+	if got, want := workers[0]["uri"], "http://acme.example.com/invoice"; got != want {
+		t.Errorf("expected uri of the worker to be %s, got %s", want, got)
+	}
+	if workers[1]["uri"] != "" || workers[1]["tenant"] != "" {
+		t.Errorf("expected the conversion of another tenant to be redacted, got %+v", workers[1])
+	}
+	if got, want := workers[1]["state"], "busy"; got != want {
+		t.Errorf("expected state of the worker to be %s, got %s", want, got)
+	}
+
+	// Every conversion is redacted without a key
+	for _, w := range workerStates(st, "", false) {
+		if w["uri"] != "" || w["tenant"] != "" {
+			t.Errorf("expected the conversion to be redacted, got %+v", w)
+		}
+	}
+}
+
+func TestRequestClass(t *testing.T) {
+	cl, err := requestClass("acme", "bulk")
+	if err != nil {
+		t.Fatalf("requestclass returned an unexpected error: %+v", err)
+	}
+	if want := (converter.Class{Tenant: "acme", Priority: converter.PriorityBulk}); cl != want {
+		t.Errorf("expected class to be %+v, got %+v", want, cl)
+	}
+	if _, err := requestClass("acme", "urgent"); err != ErrPriorityInvalid {
+		t.Errorf("expected error to be %+v, got %+v", ErrPriorityInvalid, err)
+	}
+}
+
+func TestStorageDestination(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	reg := storage.NewRegistry()
+	reg.Register("local", storage.Local{})
+	c.Set("storage", reg)
+
+	tests := []struct {
+		key  auth.Key
+		sKey string
+		want string
+	}{
+		{auth.Key{}, "invoice.pdf", "invoice.pdf"},
+		{auth.Key{ID: "acme-web"}, "invoice.pdf", "acme-web/invoice.pdf"},
+		{auth.Key{ID: "acme-web", Tenant: "acme"}, "invoice.pdf", "acme/invoice.pdf"},
+		// A key cannot escape its namespace
+		{auth.Key{ID: "acme-web", Tenant: "acme"}, "../initech/invoice.pdf", "acme/initech/invoice.pdf"},
+		{auth.Key{ID: "acme-web", Tenant: "acme"}, "/initech/invoice.pdf", "acme/initech/invoice.pdf"},
+	}
+	for _, tt := range tests {
+		if tt.key.ID != "" {
+			c.Set("key", tt.key)
+			c.Set("tenant", tt.key.Tenant)
+		}
+		d, err := storageDestination(c, "local", tt.sKey)
+		if err != nil {
+			t.Fatalf("storagedestination returned an unexpected error: %+v", err)
+		}
+		if d.Key != tt.want {
+			t.Errorf("expected storage key to be %s, got %s", tt.want, d.Key)
+		}
+	}
+}
+
+func TestRequestTenant(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	// The tenant of the client is only used with a key
+	if got, want := requestTenant(c, "acme"), ""; got != want {
+		t.Errorf("expected tenant to be %q, got %q", want, got)
+	}
+
+	// The tenant of the client is namespaced under the key
+	c.Set("key", auth.Key{ID: "default"})
+	c.Set("tenant", "")
+	if got, want := requestTenant(c, "acme"), "default/acme"; got != want {
+		t.Errorf("expected tenant to be %s, got %s", want, got)
+	}
+	if got, want := requestTenant(c, ""), ""; got != want {
+		t.Errorf("expected tenant to be %q, got %q", want, got)
+	}
+	c.Set("key", auth.Key{})
+	if got, want := requestTenant(c, "acme"), ""; got != want {
+		t.Errorf("expected tenant to be %q, got %q", want, got)
+	}
+
+	// The tenant of the key cannot be overridden by the client
+	c.Set("key", auth.Key{ID: "initech-web", Tenant: "initech"})
+	c.Set("tenant", "initech")
+	if got, want := requestTenant(c, "acme"), "initech"; got != want {
+		t.Errorf("expected tenant to be %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/metrics"
+	"github.com/getsentry/raven-go"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrBodyInvalid should be returned when a request body is not a valid
+	// JSON document.
+	ErrBodyInvalid = errors.New("invalid JSON body provided")
+	// ErrHeaderInvalid should be returned when a header name or value is
+	// invalid.
+	ErrHeaderInvalid = errors.New("invalid header provided")
+	// ErrCookieInvalid should be returned when a cookie name is invalid.
+	ErrCookieInvalid = errors.New("invalid cookie provided")
+)
+
+// nameValue is a header in a v2 conversion request.
+type nameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// conversionCookie is a cookie in a v2 conversion request.
+type conversionCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Domain (optional) defaults to the host of the URL.
+	Domain string `json:"domain"`
+	// Path (optional) defaults to "/".
+	Path string `json:"path"`
+}
+
+// conversionOutput is the output target of a v2 conversion request.
+type conversionOutput struct {
+	// Storage is the name of a storage backend. The output is returned to the
+	// client if it is empty, and WEAVER_STORAGE is not set.
+	Storage string `json:"storage"`
+	// Key (optional) is the key of the uploaded conversion.
+	Key string `json:"key"`
+}
+
+// conversionBody is the JSON document accepted by the v2 API.
+// See docs/quick-start.md for an example.
+type conversionBody struct {
+	URL         string                      `json:"url"`
+	Headers     []nameValue                 `json:"headers"`
+	Cookies     []conversionCookie          `json:"cookies"`
+	Options     converter.ConversionOptions `json:"options"`
+	Aggressive  bool                        `json:"aggressive"`
+	Converter   string                      `json:"converter"`
+	Ext         string                      `json:"ext"`
+	Output      conversionOutput            `json:"output"`
+	CallbackURL string                      `json:"callback_url"`
+	Tenant      string                      `json:"tenant"`
+	Priority    string                      `json:"priority"`
+}
+
+// validHeader returns true if a header can be sent as is (i.e. the name is a
+// token, and neither the name or the value can be used to inject headers).
+func validHeader(h nameValue) bool {
+	if h.Name == "" || strings.ContainsAny(h.Name, " \t\r\n:") {
+		return false
+	}
+	return !strings.ContainsAny(h.Value, "\r\n")
+}
+
+// fetchOptions returns the headers, and cookies used to fetch the URL.
+func (b conversionBody) fetchOptions() (converter.FetchOptions, error) {
+	var fo converter.FetchOptions
+	if len(b.Headers) > 0 {
+		fo.Header = make(http.Header)
+	}
+	for _, h := range b.Headers {
+		if !validHeader(h) {
+			return fo, ErrHeaderInvalid
+		}
+		fo.Header.Add(h.Name, h.Value)
+	}
+	for _, ck := range b.Cookies {
+		if ck.Name == "" || strings.ContainsAny(ck.Name, " \t\r\n;=") {
+			return fo, ErrCookieInvalid
+		}
+		fo.Cookies = append(fo.Cookies, &http.Cookie{
+			Name:   ck.Name,
+			Value:  ck.Value,
+			Domain: ck.Domain,
+			Path:   ck.Path,
+		})
+	}
+	return fo, nil
+}
+
+// convertV2Handler converts a URL described by a JSON document (see
+// conversionBody). Unlike convertByURLHandler, credentials are sent in the
+// body so that they do not end up in access logs. The responses are the same
+// as the ones returned by convertByURLHandler.
+func convertV2Handler(c *gin.Context) {
+	s := c.MustGet("metrics").(metrics.Metrics)
+	r, ravenOk := c.Get("sentry")
+	conf := c.MustGet("config").(Config)
+
+	// The body is not larger than the largest source it can contain
+	if max := conf.Limits.MaxSize; max > 0 {
+		if c.Request.ContentLength > max {
+			abortSourceError(c, converter.ErrSourceTooLarge)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+	}
+
+	var b conversionBody
+	if err := json.NewDecoder(c.Request.Body).Decode(&b); err != nil {
+		c.AbortWithError(http.StatusBadRequest, ErrBodyInvalid).SetType(gin.ErrorTypePublic)
+		s.Increment("invalid_body")
+		return
+	}
+
+	if b.URL == "" {
+		c.AbortWithError(http.StatusBadRequest, ErrURLInvalid).SetType(gin.ErrorTypePublic)
+		s.Increment("invalid_url")
+		return
+	}
+
+	fo, err := b.fetchOptions()
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
+		return
+	}
+
+	if err := b.Options.Validate(); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
+		return
+	}
+
+	if b.CallbackURL != "" && !validCallbackURL(conf.URLPolicy, b.CallbackURL) {
+		c.AbortWithError(http.StatusBadRequest, ErrCallbackURLInvalid).SetType(gin.ErrorTypePublic)
+		return
+	}
+
+	cl, err := requestClass(requestTenant(c, b.Tenant), b.Priority)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
+		return
+	}
+
+	name := b.Output.Storage
+	if name == "" {
+		name = conf.Storage
+	}
+	d, err := storageDestination(c, name, b.Output.Key)
+	if err == ErrStorageInvalid {
+		c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
+		return
+	} else if err != nil {
+		c.Error(err)
+		return
+	}
+
+	fo.Policy = &conf.URLPolicy
+	source, err := converter.NewConversionSource(b.URL, fo, conf.Limits, b.Ext, nil)
+	if abortSourceError(c, err) {
+		return
+	} else if err != nil {
+		s.Error("conversion_error")
+		if ravenOk {
+			r.(*raven.Client).CaptureError(err, map[string]string{"url": b.URL})
+		}
+		c.Error(err)
+		return
+	}
+	source.Options = b.Options
+
+	if !checkConverter(c, b.Converter, *source, b.Aggressive) {
+		if source.IsLocal {
+			os.Remove(source.URI)
+		}
+		return
+	}
+
+	runConversion(c, conversionRequest{
+		source:      *source,
+		aggressive:  b.Aggressive,
+		converter:   b.Converter,
+		destination: d,
+		callbackURL: b.CallbackURL,
+		class:       cl,
+	})
+}
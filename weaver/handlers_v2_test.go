@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/metrics"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/alexcesaro/statsd.v2"
+)
+
+func TestConversionBodyFetchOptions(t *testing.T) {
+	b := conversionBody{
+		Headers: []nameValue{
+			{Name: "Accept-Language", Value: "zh-CN"},
+			{Name: "X-Test", Value: "a"},
+			{Name: "X-Test", Value: "b"},
+		},
+		Cookies: []conversionCookie{{Name: "session", Value: "test"}},
+	}
+	fo, err := b.fetchOptions()
+	if err != nil {
+		t.Fatalf("fetchoptions returned an unexpected error: %+v", err)
+	}
+	if got, want := fo.Header.Get("Accept-Language"), "zh-CN"; got != want {
+		t.Errorf("expected Accept-Language header to be %s, got %s", want, got)
+	}
+	if got, want := len(fo.Header["X-Test"]), 2; got != want {
+		t.Errorf("expected number of X-Test header values to be %d, got %d", want, got)
+	}
+	if got, want := len(fo.Cookies), 1; got != want {
+		t.Fatalf("expected number of cookies to be %d, got %d", want, got)
+	}
+	if got, want := fo.Cookies[0].String(), "session=test"; got != want {
+		t.Errorf("expected cookie to be %s, got %s", want, got)
+	}
+}
+
+func TestConversionBodyFetchOptions_invalidHeader(t *testing.T) {
+	for _, h := range []nameValue{
+		{Name: "", Value: "test"},
+		{Name: "X Test", Value: "test"},
+		{Name: "X-Test", Value: "test\r\nX-Injected: test"},
+	} {
+		b := conversionBody{Headers: []nameValue{h}}
+		if _, err := b.fetchOptions(); err != ErrHeaderInvalid {
+			t.Errorf("expected error for header %+v to be %+v, got %+v", h, ErrHeaderInvalid, err)
+		}
+	}
+}
+
+func TestConversionBodyFetchOptions_invalidCookie(t *testing.T) {
+	b := conversionBody{Cookies: []conversionCookie{{Name: "", Value: "test"}}}
+	if _, err := b.fetchOptions(); err != ErrCookieInvalid {
+		t.Errorf("expected error to be %+v, got %+v", ErrCookieInvalid, err)
+	}
+}
+
+func TestConvertV2Handler_maxSize(t *testing.T) {
+	conf := Config{Limits: converter.Limits{MaxSize: 16}}
+	r := gin.Default()
+	r.Use(MetricsMiddleware(metrics.Statsd{Client: &statsd.Client{}}))
+	r.Use(ErrorMiddleware())
+	r.Use(ConfigMiddleware(conf))
+	r.POST("/", convertV2Handler)
+
+	body := []byte(`{"url": "http://www.example.com/invoice"}`)
+	tests := []struct {
+		contentLength int64
+		want          int
+	}{
+		{int64(len(body)), http.StatusRequestEntityTooLarge},
+		// The body is truncated if its length is unknown (chunked)
+		{-1, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/", ioutil.NopCloser(bytes.NewReader(body)))
+		req.ContentLength = tt.contentLength
+		r.ServeHTTP(res, req)
+		if got := res.Code; got != tt.want {
+			t.Errorf("expected response code to be %d, got %d", tt.want, got)
+		}
+	}
+}
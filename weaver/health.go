@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/converter/athenapdf"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrQueueSaturated is returned by the readiness check when the work
+	// queue is full.
+	ErrQueueSaturated = errors.New("work queue is saturated")
+	// ErrCanaryOutput is returned by the readiness check when the canary
+	// conversion does not return a PDF.
+	ErrCanaryOutput = errors.New("canary conversion did not return a PDF")
+	// ErrCanaryPending is returned by the readiness check until the first
+	// canary conversion has completed.
+	ErrCanaryPending = errors.New("canary conversion has not completed yet")
+)
+
+// canaryHTML is converted by the canary check.
+const canaryHTML = `<!DOCTYPE html><html><head><title>weaver</title></head><body><h1>weaver canary</h1></body></html>`
+
+// checkCMD returns an error if the executable of a converter command can not
+// be found (or it is not executable).
+func checkCMD(cmd string) error {
+	args := strings.Fields(cmd)
+	if len(args) == 0 {
+		return errors.New("command is empty")
+	}
+	_, err := exec.LookPath(args[0])
+	return err
+}
+
+// converterCMDs returns the commands run by the converters of the chain.
+// The cloudconvert converter, and a Chromium connected with ChromiumURL do
+// not run any command.
+func converterCMDs(conf Config) map[string]string {
+	cmds := make(map[string]string)
+	for _, name := range conf.Converters {
+		switch name {
+		case "athenapdf":
+			cmds[name] = conf.AthenaCMD
+		case "wkhtmltopdf":
+			cmds[name] = conf.WkHTMLToPDFCMD
+		case "chromium":
+			if conf.ChromiumURL == "" {
+				cmds[name] = conf.ChromiumCMD
+			}
+		}
+	}
+	return cmds
+}
+
+// displayAddr returns the network, and the address of an X display, e.g.
+// ':99' is the unix socket '/tmp/.X11-unix/X99', and 'host:1' is TCP port
+// 6001 on the host.
+func displayAddr(display string) (string, string, error) {
+	i := strings.LastIndex(display, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid display %q", display)
+	}
+	host, n := display[:i], display[i+1:]
+	// Ignore the screen number
+	if j := strings.Index(n, "."); j >= 0 {
+		n = n[:j]
+	}
+	var num int
+	if _, err := fmt.Sscanf(n, "%d", &num); err != nil {
+		return "", "", fmt.Errorf("invalid display %q", display)
+	}
+	if host == "" || host == "unix" {
+		return "unix", fmt.Sprintf("/tmp/.X11-unix/X%d", num), nil
+	}
+	return "tcp", net.JoinHostPort(host, fmt.Sprintf("%d", 6000+num)), nil
+}
+
+// checkDisplay returns an error if the X display (Xvfb) used by athenapdf is
+// not reachable.
+func checkDisplay(display string) error {
+	network, addr, err := displayAddr(display)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkTmpDir returns an error if a directory is not writable, or if it has
+// less than minFree bytes available.
+func checkTmpDir(dir string, minFree int64) error {
+	f, err := ioutil.TempFile(dir, "readyz")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return err
+	}
+	if free := int64(st.Bavail) * int64(st.Bsize); free < minFree {
+		return fmt.Errorf("%s has %d bytes free, expected at least %d", dir, free, minFree)
+	}
+	return nil
+}
+
+// checkQueue returns ErrQueueSaturated if the work queue is full.
+func checkQueue(wq *converter.WorkQueue) error {
+	if wq.Len() >= wq.Cap() {
+		return ErrQueueSaturated
+	}
+	return nil
+}
+
+// checkShutdown returns ErrShuttingDown once the microservice has started
+// shutting down, so that it is removed from the load balancer.
+func checkShutdown(d *Drainer) error {
+	if d.Closing() {
+		return ErrShuttingDown
+	}
+	return nil
+}
+
+// canary runs conversions of canaryHTML with athenapdf in the background, so
+// that the probes only report the result of the last one (and they do not
+// overload the converter, or wait for it).
+type canary struct {
+	cmd     string
+	timeout time.Duration
+
+	mu      sync.Mutex
+	checked bool
+	err     error
+}
+
+// check returns the result of the last canary conversion, or
+// ErrCanaryPending if none has completed yet.
+func (c *canary) check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checked {
+		return ErrCanaryPending
+	}
+	return c.err
+}
+
+// run runs a canary conversion, and caches its result.
+func (c *canary) run() {
+	err := c.convert()
+	c.mu.Lock()
+	c.err, c.checked = err, true
+	c.mu.Unlock()
+}
+
+// RunEvery runs a canary conversion immediately, and then at every interval
+// until done is closed.
+func (c *canary) RunEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.run()
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *canary) convert() error {
+	f, err := ioutil.TempFile("/tmp", "canary")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(canaryHTML)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	timer := time.AfterFunc(c.timeout, func() { close(done) })
+	defer timer.Stop()
+
+	s := converter.ConversionSource{URI: f.Name(), Mime: "text/html", IsLocal: true}
+	out, err := athenapdf.AthenaPDF{CMD: c.cmd}.Convert(s, done)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(out, []byte("%PDF")) {
+		return ErrCanaryOutput
+	}
+	return nil
+}
+
+// healthzHandler is the liveness check. It returns a JSON string as long as
+// the microservice is able to respond to requests.
+func healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyzHandler returns the readiness check. It checks that the commands of
+// the converter chain, and the display are available, that there is enough
+// space for temporary files, that the work queue is not saturated, and that
+// the microservice is not shutting down. If ReadinessCanary is enabled (and
+// athenapdf is in the chain), it also reports the result of the last canary
+// conversion, which is run in the background.
+// The response is a JSON string containing the result of every check, and it
+// returns 503 if any of them has failed.
+func readyzHandler(conf Config) gin.HandlerFunc {
+	cmds := converterCMDs(conf)
+
+	var cn *canary
+	if _, ok := cmds["athenapdf"]; ok && conf.ReadinessCanary {
+		cn = &canary{
+			cmd:     conf.AthenaCMD,
+			timeout: time.Second * time.Duration(conf.WorkerTimeout),
+		}
+		interval := time.Second * time.Duration(conf.ReadinessCanaryInterval)
+		if interval <= 0 {
+			interval = 300 * time.Second
+		}
+		go cn.RunEvery(interval, nil)
+	}
+
+	return func(c *gin.Context) {
+		checks := map[string]func() error{
+			"tmp":      func() error { return checkTmpDir("/tmp", conf.MinFreeSpace) },
+			"queue":    func() error { return checkQueue(c.MustGet("queue").(*converter.WorkQueue)) },
+			"shutdown": func() error { return checkShutdown(c.MustGet("drainer").(*Drainer)) },
+		}
+		for name, cmd := range cmds {
+			cmd := cmd
+			checks[name] = func() error { return checkCMD(cmd) }
+		}
+		// Xvfb is only used if a display is set (see conf/entrypoint.sh)
+		if display := os.Getenv("DISPLAY"); display != "" {
+			checks["display"] = func() error { return checkDisplay(display) }
+		}
+		if cn != nil {
+			checks["canary"] = cn.check
+		}
+
+		status, code := "ready", http.StatusOK
+		results := make(map[string]string, len(checks))
+		for name, check := range checks {
+			if err := check(); err != nil {
+				results[name] = err.Error()
+				status, code = "unavailable", http.StatusServiceUnavailable
+				continue
+			}
+			results[name] = "ok"
+		}
+
+		c.JSON(code, gin.H{"status": status, "checks": results})
+	}
+}
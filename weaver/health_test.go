@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckCMD(t *testing.T) {
+	if err := checkCMD("echo -S"); err != nil {
+		t.Errorf("checkcmd returned an unexpected error: %+v", err)
+	}
+	if err := checkCMD("athenapdf-does-not-exist -S"); err == nil {
+		t.Errorf("expected error to be returned")
+	}
+	if err := checkCMD(""); err == nil {
+		t.Errorf("expected error to be returned")
+	}
+}
+
+func TestConverterCMDs(t *testing.T) {
+	conf := Config{
+		Converters:     []string{"chromium", "wkhtmltopdf", "cloudconvert"},
+		AthenaCMD:      "athenapdf",
+		ChromiumCMD:    "chromium --headless",
+		WkHTMLToPDFCMD: "wkhtmltopdf -q",
+	}
+	want := map[string]string{"chromium": "chromium --headless", "wkhtmltopdf": "wkhtmltopdf -q"}
+	if got := converterCMDs(conf); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected converter commands to be %+v, got %+v", want, got)
+	}
+
+	// A running Chromium is not launched
+	conf.ChromiumURL = "http://chromium:9222"
+	want = map[string]string{"wkhtmltopdf": "wkhtmltopdf -q"}
+	if got := converterCMDs(conf); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected converter commands to be %+v, got %+v", want, got)
+	}
+}
+
+func TestDisplayAddr(t *testing.T) {
+	for display, want := range map[string][2]string{
+		":99":         {"unix", "/tmp/.X11-unix/X99"},
+		":0.0":        {"unix", "/tmp/.X11-unix/X0"},
+		"unix:1":      {"unix", "/tmp/.X11-unix/X1"},
+		"localhost:1": {"tcp", "localhost:6001"},
+	} {
+		network, addr, err := displayAddr(display)
+		if err != nil {
+			t.Fatalf("displayaddr returned an unexpected error: %+v", err)
+		}
+		if got := [2]string{network, addr}; got != want {
+			t.Errorf("expected address of display %s to be %+v, got %+v", display, want, got)
+		}
+	}
+
+	if _, _, err := displayAddr("99"); err == nil {
+		t.Errorf("expected error to be returned")
+	}
+}
+
+func TestCheckDisplay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:6099")
+	if err != nil {
+		t.Skipf("unable to listen on the display port: %+v", err)
+	}
+	defer l.Close()
+
+	if err := checkDisplay("127.0.0.1:99"); err != nil {
+		t.Errorf("checkdisplay returned an unexpected error: %+v", err)
+	}
+	l.Close()
+	if err := checkDisplay("127.0.0.1:99"); err == nil {
+		t.Errorf("expected error to be returned")
+	}
+}
+
+func TestCheckTmpDir(t *testing.T) {
+	if err := checkTmpDir(os.TempDir(), 1); err != nil {
+		t.Errorf("checktmpdir returned an unexpected error: %+v", err)
+	}
+	// More than any disk
+	if err := checkTmpDir(os.TempDir(), 1<<62); err == nil {
+		t.Errorf("expected error to be returned")
+	}
+	if err := checkTmpDir("/does/not/exist", 1); err == nil {
+		t.Errorf("expected error to be returned")
+	}
+}
+
+func TestCheckQueue(t *testing.T) {
+	wq := converter.NewWorkQueue(1, converter.SchedulerOptions{})
+	if err := checkQueue(wq); err != nil {
+		t.Errorf("checkqueue returned an unexpected error: %+v", err)
+	}
+	converter.NewWork(wq, nil, converter.ConversionSource{}, converter.Destination{}, converter.Class{}, 0)
+	if err := checkQueue(wq); err != ErrQueueSaturated {
+		t.Errorf("expected error to be %+v, got %+v", ErrQueueSaturated, err)
+	}
+}
+
+func TestCheckShutdown(t *testing.T) {
+	d := NewDrainer()
+	if err := checkShutdown(d); err != nil {
+		t.Errorf("checkshutdown returned an unexpected error: %+v", err)
+	}
+	d.Drain(time.Second)
+	if err := checkShutdown(d); err != ErrShuttingDown {
+		t.Errorf("expected error to be %+v, got %+v", ErrShuttingDown, err)
+	}
+}
+
+func mockPDFCommand(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "canary")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %+v", err)
+	}
+	p := filepath.Join(dir, "athenapdf")
+	if err := ioutil.WriteFile(p, []byte("#!/bin/sh\necho %PDF-1.4\n"), 0755); err != nil {
+		t.Fatalf("unable to create mock athenapdf command: %+v", err)
+	}
+	return p
+}
+
+func TestCanary(t *testing.T) {
+	cmd := mockPDFCommand(t)
+	defer os.RemoveAll(filepath.Dir(cmd))
+
+	c := &canary{cmd: cmd, timeout: 10 * time.Second}
+	if err := c.check(); err != ErrCanaryPending {
+		t.Fatalf("expected error to be %+v, got %+v", ErrCanaryPending, err)
+	}
+	c.run()
+	if err := c.check(); err != nil {
+		t.Fatalf("canary returned an unexpected error: %+v", err)
+	}
+
+	// The result is cached until the next run
+	c.cmd = "cat"
+	if err := c.check(); err != nil {
+		t.Errorf("expected cached canary result, got %+v", err)
+	}
+	c.run()
+	if err := c.check(); err != ErrCanaryOutput {
+		t.Errorf("expected error to be %+v, got %+v", ErrCanaryOutput, err)
+	}
+}
+
+func TestCanaryRunEvery(t *testing.T) {
+	cmd := mockPDFCommand(t)
+	defer os.RemoveAll(filepath.Dir(cmd))
+
+	c := &canary{cmd: cmd, timeout: 10 * time.Second}
+	done := make(chan struct{})
+	defer close(done)
+	go c.RunEvery(time.Hour, done)
+
+	// The first conversion is run immediately
+	if err := waitCanary(c); err != nil {
+		t.Errorf("canary returned an unexpected error: %+v", err)
+	}
+}
+
+// waitCanary waits for the first canary conversion to complete, and returns
+// its result.
+func waitCanary(c *canary) error {
+	for i := 0; i < 100; i++ {
+		if err := c.check(); err != ErrCanaryPending {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return ErrCanaryPending
+}
+
+func mockReadyzRouter(conf Config, wq *converter.WorkQueue) *gin.Engine {
+	r := gin.Default()
+	r.Use(WorkQueueMiddleware(wq))
+	r.Use(DrainerMiddleware(NewDrainer()))
+	r.GET("/readyz", readyzHandler(conf))
+	return r
+}
+
+func getReadyz(r *gin.Engine) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func mockReadyz(conf Config, wq *converter.WorkQueue) *httptest.ResponseRecorder {
+	return getReadyz(mockReadyzRouter(conf, wq))
+}
+
+func TestReadyzHandler(t *testing.T) {
+	os.Unsetenv("DISPLAY")
+	cmd := mockPDFCommand(t)
+	defer os.RemoveAll(filepath.Dir(cmd))
+
+	conf := Config{Converters: []string{"athenapdf"}, AthenaCMD: cmd, WorkerTimeout: 10, ReadinessCanary: true, ReadinessCanaryInterval: 60}
+	r := mockReadyzRouter(conf, converter.NewWorkQueue(1, converter.SchedulerOptions{}))
+
+	// The instance is not ready until the first canary conversion completes
+	var res *httptest.ResponseRecorder
+	for i := 0; i < 100; i++ {
+		if res = getReadyz(r); res.Code == http.StatusOK {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d: %s", want, got, res.Body)
+	}
+
+	var body struct {
+		Status string
+		Checks map[string]string
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("unable to decode response: %+v", err)
+	}
+	if got, want := body.Status, "ready"; got != want {
+		t.Errorf("expected status to be %s, got %s", want, got)
+	}
+	for _, name := range []string{"athenapdf", "tmp", "queue", "shutdown", "canary"} {
+		if got, want := body.Checks[name], "ok"; got != want {
+			t.Errorf("expected %s check to be %s, got %s", name, want, got)
+		}
+	}
+}
+
+func TestReadyzHandler_queueFull(t *testing.T) {
+	os.Unsetenv("DISPLAY")
+	cmd := mockPDFCommand(t)
+	defer os.RemoveAll(filepath.Dir(cmd))
+
+	conf := Config{Converters: []string{"athenapdf"}, AthenaCMD: cmd}
+	res := mockReadyz(conf, converter.NewWorkQueue(0, converter.SchedulerOptions{}))
+	if got, want := res.Code, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("expected response code to be %d, got %d: %s", want, got, res.Body)
+	}
+	var body struct {
+		Checks map[string]string
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("unable to decode response: %+v", err)
+	}
+	if got, want := body.Checks["queue"], ErrQueueSaturated.Error(); got != want {
+		t.Errorf("expected queue check to be %s, got %s", want, got)
+	}
+}
+
+func TestReadyzHandler_unavailable(t *testing.T) {
+	os.Unsetenv("DISPLAY")
+	conf := Config{Converters: []string{"athenapdf"}, AthenaCMD: "athenapdf-does-not-exist"}
+	res := mockReadyz(conf, converter.NewWorkQueue(1, converter.SchedulerOptions{}))
+	if got, want := res.Code, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+}
+
+func TestReadyzHandler_converterChain(t *testing.T) {
+	os.Unsetenv("DISPLAY")
+	// athenapdf is not in the chain, so its command is not checked
+	conf := Config{
+		Converters:     []string{"wkhtmltopdf", "cloudconvert"},
+		AthenaCMD:      "athenapdf-does-not-exist",
+		WkHTMLToPDFCMD: "wkhtmltopdf-does-not-exist -q",
+	}
+	res := mockReadyz(conf, converter.NewWorkQueue(1, converter.SchedulerOptions{}))
+	if got, want := res.Code, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	var body struct {
+		Checks map[string]string
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("unable to decode response: %+v", err)
+	}
+	if _, ok := body.Checks["athenapdf"]; ok {
+		t.Errorf("expected athenapdf not to be checked")
+	}
+	if got := body.Checks["wkhtmltopdf"]; got == "" || got == "ok" {
+		t.Errorf("expected wkhtmltopdf check to fail, got %q", got)
+	}
+}
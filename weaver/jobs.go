@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/auth"
+	"github.com/arachnys/athenapdf/weaver/callback"
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/storage"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrJobNotFound should be returned when a job ID does not exist (or it
+	// has expired).
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobNotFinished should be returned when the result of a job is
+	// requested before it has finished.
+	ErrJobNotFinished = errors.New("job has not finished")
+	// ErrJobFailed should be returned when the result of a failed job is
+	// requested.
+	ErrJobFailed = errors.New("job failed")
+	// ErrCallbackURLInvalid should be returned when a callback URL is invalid.
+	ErrCallbackURLInvalid = errors.New("invalid callback URL provided")
+	// ErrJobNotResumable is the error of a persisted job which could not be
+	// resumed after a restart (e.g. its destination used ad hoc S3
+	// credentials, which are not persisted, or the key signing its callback
+	// no longer exists).
+	ErrJobNotResumable = errors.New("job could not be resumed after a restart")
+	// ErrCallbackRedirects is returned when a callback URL redirects more
+	// than callbackMaxRedirects times.
+	ErrCallbackRedirects = errors.New("callback redirected too many times")
+)
+
+// callbackMaxRedirects is the maximum number of redirects followed when
+// delivering a callback.
+const callbackMaxRedirects = 5
+
+// jobRequest is the persisted form of a conversionRequest. The destination
+// is persisted as the name of a storage backend in the registry, and the key
+// signing the callback as its ID (their credentials are not persisted).
+type jobRequest struct {
+	Source      converter.ConversionSource `json:"source"`
+	Aggressive  bool                       `json:"aggressive"`
+	Converter   string                     `json:"converter,omitempty"`
+	Storage     string                     `json:"storage,omitempty"`
+	Key         string                     `json:"key,omitempty"`
+	CallbackURL string                     `json:"callback_url,omitempty"`
+	// CallbackKeyID is the ID of the API key signing the callback.
+	CallbackKeyID string          `json:"callback_key_id,omitempty"`
+	Class         converter.Class `json:"class"`
+}
+
+// encodeJobRequest serialises a conversion request to be persisted with its
+// job. It returns nil if the request can not be resumed.
+func encodeJobRequest(req conversionRequest) ([]byte, error) {
+	if req.destination.Storage != nil && req.destination.Name == "" {
+		return nil, nil
+	}
+	// Keys without an ID can not be looked up again
+	if req.callbackURL != "" && req.callbackKey.ID == "" {
+		return nil, nil
+	}
+	return json.Marshal(jobRequest{
+		Source:        req.source,
+		Aggressive:    req.aggressive,
+		Converter:     req.converter,
+		Storage:       req.destination.Name,
+		Key:           req.destination.Key,
+		CallbackURL:   req.callbackURL,
+		CallbackKeyID: req.callbackKey.ID,
+		Class:         req.class,
+	})
+}
+
+// decodeJobRequest deserialises a persisted conversion request, and looks up
+// the storage backend of its destination in the registry, and the key signing
+// its callback in the key store.
+func decodeJobRequest(b []byte, reg *storage.Registry, ks *auth.KeyStore) (conversionRequest, error) {
+	if b == nil {
+		return conversionRequest{}, ErrJobNotResumable
+	}
+	var jr jobRequest
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return conversionRequest{}, err
+	}
+
+	req := conversionRequest{
+		source:      jr.Source,
+		aggressive:  jr.Aggressive,
+		converter:   jr.Converter,
+		callbackURL: jr.CallbackURL,
+		class:       jr.Class,
+	}
+	if jr.Storage != "" {
+		st, err := reg.Get(jr.Storage)
+		if err != nil {
+			return conversionRequest{}, err
+		}
+		req.destination = converter.Destination{Storage: st, Key: jr.Key, Name: jr.Storage}
+	}
+	if jr.CallbackURL != "" {
+		k, ok := ks.LookupID(jr.CallbackKeyID)
+		if !ok {
+			return conversionRequest{}, ErrJobNotResumable
+		}
+		req.callbackKey = k
+	}
+	return req, nil
+}
+
+// keepSource moves a local source to the sources directory of a persisted
+// job store, so that it survives a restart. It returns the new path.
+func keepSource(dir, p string) (string, error) {
+	dst := filepath.Join(dir, filepath.Base(p))
+	if err := os.Rename(p, dst); err == nil {
+		return dst, nil
+	}
+
+	// The directories may be on different devices
+	src, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		os.Remove(dst)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	os.Remove(p)
+	return dst, nil
+}
+
+// resumeJobs runs the unfinished jobs of a persisted job store again (e.g.
+// after a restart). Jobs which were running when the microservice stopped
+// are run again, so a job may be run more than once.
+func resumeJobs(r runner, d *Drainer, js *converter.JobStore, reg *storage.Registry, ks *auth.KeyStore) {
+	for _, job := range js.Unfinished() {
+		req, err := decodeJobRequest(job.Request, reg, ks)
+		if err != nil {
+			log.Printf("[Job] unable to resume %s: %+v\n", job.ID, err)
+			js.Update(job.ID, func(j *converter.Job) {
+				j.Status = converter.JobFailed
+				j.Error = ErrJobNotResumable.Error()
+				j.Request = nil
+			})
+			continue
+		}
+
+		if !d.acquire() {
+			return
+		}
+		js.Update(job.ID, func(j *converter.Job) {
+			j.Status = converter.JobQueued
+		})
+		log.Printf("[Job] resuming %s\n", job.ID)
+		go runJob(r, d, js, job.ID, req)
+	}
+}
+
+// runJob runs a conversion in the background, and records its progress in
+// the job store. Unlike conversionHandler, it is not tied to a client
+// connection, so it can not be cancelled (but it is aborted on shutdown).
+// It releases its conversion from the drainer once it has finished.
+func runJob(r runner, d *Drainer, js *converter.JobStore, id string, req conversionRequest) {
+	defer d.release()
+
+	// Jobs wait for a free slot when the work queue is full
+	req.async = true
+
+	res := r.run(req, nil, func() {
+		js.Update(id, func(j *converter.Job) {
+			j.Status = converter.JobRunning
+		})
+	})
+
+	// Persisted jobs (and their sources) are kept to be resumed on restart
+	if res.err == ErrShuttingDown && js.Persisted() {
+		js.Update(id, func(j *converter.Job) {
+			j.Status = converter.JobQueued
+		})
+		log.Printf("[Job] %s will be resumed on restart\n", id)
+		return
+	}
+
+	// GC if converting temporary file
+	if req.source.IsLocal {
+		defer os.Remove(req.source.URI)
+	}
+
+	js.Update(id, func(j *converter.Job) {
+		// The request is no longer needed once the job has finished
+		j.Request = nil
+		if res.err != nil {
+			j.Status = converter.JobFailed
+			j.Error = res.err.Error()
+			return
+		}
+		j.Status = converter.JobSucceeded
+		j.Output = res.out
+		j.Location = res.location
+	})
+
+	log.Printf("[Job] %s finished\n", id)
+
+	if req.callbackURL != "" {
+		job, _ := js.Get(id)
+		deliverCallback(r, req, job)
+	}
+}
+
+// deliverCallback sends the result of a finished job to its callback URL.
+// The body is signed using the key returned by callbackSigningKey.
+func deliverCallback(r runner, req conversionRequest, job converter.Job) {
+	u := req.callbackURL
+	// The callback URL is checked against the URL policy (see
+	// validCallbackURL), and so are the IPs it resolves to, and its redirects
+	hc := r.conf.URLPolicy.Client(nil)
+	hc.Timeout = time.Second * time.Duration(r.conf.CallbackTimeout)
+	checkRedirect := hc.CheckRedirect
+	hc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= callbackMaxRedirects {
+			return ErrCallbackRedirects
+		}
+		return checkRedirect(req, via)
+	}
+	cb := callback.Client{
+		HTTPClient:  hc,
+		Key:         callbackSigningKey(r.conf, req),
+		MaxAttempts: r.conf.CallbackMaxAttempts,
+		Backoff:     time.Second * time.Duration(r.conf.CallbackBackoff),
+	}
+
+	p := callback.Payload{
+		ID:       job.ID,
+		Status:   string(job.Status),
+		Error:    job.Error,
+		Location: job.Location,
+		Data:     job.Output,
+	}
+
+	if err := cb.Deliver(u, p); err != nil {
+		log.Println(err)
+		r.m.Error("callback_failed")
+		r.captureError(err, u)
+		return
+	}
+	r.m.Increment("callback_success")
+}
+
+// callbackSigningKey returns the key signing the callback of a job: the auth
+// key, or the API key which has submitted the job if CallbackSignWithAPIKey is
+// set (so that tenants can not verify, or forge the callbacks of each other).
+func callbackSigningKey(conf Config, req conversionRequest) string {
+	if conf.CallbackSignWithAPIKey && req.callbackKey.Key != "" {
+		return req.callbackKey.Key
+	}
+	return conf.AuthKey
+}
+
+// validCallbackURL returns true if the URL is an absolute HTTP(S) URL allowed
+// by the URL policy (so that callbacks can not be sent to internal services).
+func validCallbackURL(p converter.URLPolicy, u string) bool {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	if (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return false
+	}
+	return p.CheckURL(pu) == nil
+}
+
+// queueJob creates an asynchronous job for a conversion request, and returns
+// its job ID to the client immediately.
+func queueJob(c *gin.Context, req conversionRequest) {
+	js := c.MustGet("jobs").(*converter.JobStore)
+	conf := c.MustGet("config").(Config)
+	r := newRunner(c)
+
+	// Jobs are batches of conversions by default
+	if req.class.Priority == "" {
+		req.class.Priority = converter.PriorityBulk
+	}
+	if k, ok := c.Get("key"); ok {
+		req.callbackKey = k.(auth.Key)
+	}
+
+	// Keep the local source with the persisted job
+	if req.source.IsLocal && js.Persisted() {
+		p, err := keepSource(filepath.Join(conf.JobDir, "sources"), req.source.URI)
+		if err != nil {
+			os.Remove(req.source.URI)
+			c.Error(err)
+			return
+		}
+		req.source.URI = p
+	}
+
+	var job converter.Job
+	b, err := encodeJobRequest(req)
+	if err == nil {
+		job, err = js.Create(c.GetString("tenant"), b)
+	}
+	if err != nil {
+		if req.source.IsLocal {
+			os.Remove(req.source.URI)
+		}
+		c.Error(err)
+		return
+	}
+
+	// The job outlives the request, so it is drained separately
+	d := c.MustGet("drainer").(*Drainer)
+	d.add()
+	go runJob(r, d, js, job.ID, req)
+
+	// Jobs wait for a free slot, so they count against the quota of the key
+	// once they are created (see RateLimitMiddleware)
+	c.Set("admitted", true)
+	c.Header("Location", "/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// createJobHandler queues an asynchronous conversion, and returns its job ID
+// immediately. It accepts the same parameters as the /convert routes: a URL
+// (query parameter) or a multipart file, and an optional callback URL.
+func createJobHandler(c *gin.Context) {
+	var source *converter.ConversionSource
+	if c.Query("url") != "" {
+		source = urlSource(c)
+	} else {
+		source = fileSource(c)
+	}
+	if source == nil {
+		return
+	}
+
+	req, ok := newConversionRequest(c, *source)
+	if !ok {
+		if source.IsLocal {
+			os.Remove(source.URI)
+		}
+		return
+	}
+	queueJob(c, req)
+}
+
+// requestJob returns the job in the path parameters. The jobs of other
+// tenants are not found.
+func requestJob(c *gin.Context) (converter.Job, bool) {
+	js := c.MustGet("jobs").(*converter.JobStore)
+	job, ok := js.Get(c.Param("id"))
+	if !ok || job.Tenant != c.GetString("tenant") {
+		c.AbortWithError(http.StatusNotFound, ErrJobNotFound).SetType(gin.ErrorTypePublic)
+		return converter.Job{}, false
+	}
+	return job, true
+}
+
+// jobStatusHandler returns the status of a job.
+func jobStatusHandler(c *gin.Context) {
+	job, ok := requestJob(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// jobResultHandler returns the output of a successful job. The response is
+// the same as the one returned by the /convert routes.
+func jobResultHandler(c *gin.Context) {
+	job, ok := requestJob(c)
+	if !ok {
+		return
+	}
+
+	switch {
+	case !job.Finished():
+		c.AbortWithError(http.StatusConflict, ErrJobNotFinished).SetType(gin.ErrorTypePublic)
+	case job.Status == converter.JobFailed:
+		c.AbortWithError(http.StatusConflict, ErrJobFailed).SetType(gin.ErrorTypePublic)
+	case job.Location != "":
+		c.JSON(http.StatusOK, gin.H{
+			"code": 0,
+			"msg":  "OK",
+			"data": struct{ URL string }{URL: job.Location},
+		})
+	default:
+		c.Data(http.StatusOK, "application/pdf", job.Output)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/arachnys/athenapdf/weaver/auth"
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func TestJobRequest(t *testing.T) {
+	reg := storage.NewRegistry()
+	st := storage.Local{Dir: "/tmp"}
+	reg.Register("local", st)
+
+	req := conversionRequest{
+		source:      converter.ConversionSource{URI: "http://www.ok.com", Mime: "text/html"},
+		aggressive:  true,
+		destination: converter.Destination{Storage: st, Key: "test.pdf", Name: "local"},
+		callbackURL: "http://www.ok.com/callback",
+		callbackKey: auth.Key{ID: "acme", Key: "secret", Enabled: true},
+	}
+	b, err := encodeJobRequest(req)
+	if err != nil {
+		t.Fatalf("encodejobrequest returned an unexpected error: %+v", err)
+	}
+	got, err := decodeJobRequest(b, reg, auth.NewKeyStore(req.callbackKey))
+	if err != nil {
+		t.Fatalf("decodejobrequest returned an unexpected error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, req) {
+		t.Errorf("expected decoded request to be %+v, got %+v", req, got)
+	}
+}
+
+func TestJobRequest_notResumable(t *testing.T) {
+	// Ad hoc storage backends are not persisted
+	req := conversionRequest{destination: converter.Destination{Storage: storage.S3{}, Key: "test.pdf"}}
+	b, err := encodeJobRequest(req)
+	if err != nil {
+		t.Fatalf("encodejobrequest returned an unexpected error: %+v", err)
+	}
+	if _, err := decodeJobRequest(b, storage.NewRegistry(), auth.NewKeyStore()); err != ErrJobNotResumable {
+		t.Errorf("expected error to be %+v, got %+v", ErrJobNotResumable, err)
+	}
+
+	// The key signing the callback has been revoked
+	req = conversionRequest{callbackURL: "http://www.ok.com/callback", callbackKey: auth.Key{ID: "acme", Key: "secret"}}
+	b, _ = encodeJobRequest(req)
+	if _, err := decodeJobRequest(b, storage.NewRegistry(), auth.NewKeyStore()); err != ErrJobNotResumable {
+		t.Errorf("expected error to be %+v, got %+v", ErrJobNotResumable, err)
+	}
+}
+
+func TestKeepSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sources")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := ioutil.TempFile("", "tmp")
+	if err != nil {
+		t.Fatalf("unable to create temporary file: %+v", err)
+	}
+	f.WriteString("<!DOCTYPE HTML>")
+	f.Close()
+
+	p, err := keepSource(dir, f.Name())
+	if err != nil {
+		t.Fatalf("keepsource returned an unexpected error: %+v", err)
+	}
+	if got, want := filepath.Dir(p), dir; got != want {
+		t.Errorf("expected source to be moved to %s, got %s", want, got)
+	}
+	if b, _ := ioutil.ReadFile(p); string(b) != "<!DOCTYPE HTML>" {
+		t.Errorf("expected source to be kept, got %s", b)
+	}
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Errorf("expected temporary file to be removed")
+	}
+}
+
+func TestCallbackSigningKey(t *testing.T) {
+	req := conversionRequest{callbackKey: auth.Key{ID: "acme", Key: "secret"}}
+	tests := []struct {
+		conf Config
+		req  conversionRequest
+		want string
+	}{
+		{Config{AuthKey: "auth-secret"}, req, "auth-secret"},
+		{Config{AuthKey: "auth-secret", CallbackSignWithAPIKey: true}, req, "secret"},
+		// Jobs submitted with the auth key (no key file)
+		{Config{AuthKey: "auth-secret", CallbackSignWithAPIKey: true}, conversionRequest{}, "auth-secret"},
+	}
+	for _, tt := range tests {
+		if got := callbackSigningKey(tt.conf, tt.req); got != tt.want {
+			t.Errorf("expected callback signing key to be %s, got %s", tt.want, got)
+		}
+	}
+}
+
+func TestValidCallbackURL(t *testing.T) {
+	tests := []struct {
+		u    string
+		want bool
+	}{
+		{"https://www.ok.com/callback", true},
+		{"ftp://www.ok.com/callback", false},
+		{"/callback", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://127.0.0.1:8080/callback", false},
+	}
+	for _, tt := range tests {
+		if got := validCallbackURL(converter.URLPolicy{}, tt.u); got != tt.want {
+			t.Errorf("expected %s to be valid: %t, got %t", tt.u, tt.want, got)
+		}
+	}
+}
+
+func TestJobStatusHandler_tenant(t *testing.T) {
+	js := converter.NewJobStore(0)
+	job, _ := js.Create("acme", nil)
+
+	for tenant, want := range map[string]int{"acme": http.StatusOK, "initech": http.StatusNotFound} {
+		r := gin.Default()
+		r.Use(JobStoreMiddleware(js))
+		r.Use(func(c *gin.Context) { c.Set("tenant", tenant) })
+		r.Use(ErrorMiddleware())
+		r.GET("/jobs/:id", jobStatusHandler)
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/jobs/"+job.ID, nil)
+		r.ServeHTTP(res, req)
+		if got := res.Code; got != want {
+			t.Errorf("expected response code for %s to be %d, got %d", tenant, want, got)
+		}
+	}
+}
@@ -1,10 +1,24 @@
 package main
 
 import (
+	"io/ioutil"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
+	"github.com/arachnys/athenapdf/weaver/auth"
+	"github.com/arachnys/athenapdf/weaver/cache"
 	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/converter/athenapdf"
+	"github.com/arachnys/athenapdf/weaver/converter/chromium"
+	"github.com/arachnys/athenapdf/weaver/converter/cloudconvert"
+	"github.com/arachnys/athenapdf/weaver/converter/wkhtmltopdf"
+	"github.com/arachnys/athenapdf/weaver/metrics"
+	"github.com/arachnys/athenapdf/weaver/storage"
 
 	"github.com/DeanThompson/ginpprof"
 	"github.com/getsentry/raven-go"
@@ -13,22 +27,64 @@ import (
 	"gopkg.in/alexcesaro/statsd.v2"
 )
 
-// InitMiddleware sets up the necessary middlewares for the microservice.
-// These include middlewares to establish a sane context containing access to
-// the configuration, worker queue, statsd client, and Sentry client (Raven).
-// The latter two are disabled in debugging mode to avoid contaminating
-// production stats.
-// It will also set up a middleware for catching, and handling errors thrown
-// from a route.
-func InitMiddleware(router *gin.Engine, conf Config) {
-	// Config
-	router.Use(ConfigMiddleware(conf))
+// keyReloadInterval is how often the key file is checked for changes.
+const keyReloadInterval = 10 * time.Second
 
-	// Worker queue
-	wq := converter.InitWorkers(conf.MaxWorkers, conf.MaxConversionQueue, conf.WorkerTimeout)
-	router.Use(WorkQueueMiddleware(wq))
+// jobPruneInterval is how often the expired jobs are removed.
+const jobPruneInterval = time.Minute
+
+// cachePruneInterval is how often the expired entries of the disk cache are
+// removed.
+const cachePruneInterval = time.Minute
+
+// InitStorage creates a registry containing the storage backends which have
+// been configured in the environment.
+func InitStorage(conf Config) *storage.Registry {
+	r := storage.NewRegistry()
 
-	// Statsd
+	if conf.S3.Bucket != "" {
+		r.Register("s3", storage.S3{
+			Region:           conf.S3.Region,
+			AccessKey:        conf.S3.AccessKey,
+			AccessSecret:     conf.S3.AccessSecret,
+			Bucket:           conf.S3.Bucket,
+			ACL:              conf.S3.ACL,
+			KeyPrefix:        conf.S3.KeyPrefix,
+			Endpoint:         conf.S3.Endpoint,
+			PathStyle:        conf.S3.PathStyle,
+			DisableSSL:       conf.S3.DisableSSL,
+			SignatureVersion: conf.S3.SignatureVersion,
+		})
+	}
+
+	if conf.Qiniu.Bucket != "" {
+		r.Register("qiniu", storage.Qiniu{
+			AccessKey: conf.Qiniu.AccessKey,
+			SecretKey: conf.Qiniu.SecretKey,
+			Bucket:    conf.Qiniu.Bucket,
+			Domain:    conf.Qiniu.Domain,
+			KeyPrefix: conf.Qiniu.KeyPrefix,
+			Private:   conf.Qiniu.Private,
+			UpHost:    conf.Qiniu.UpHost,
+			RSHost:    conf.Qiniu.RSHost,
+		})
+	}
+
+	if conf.LocalStorage.Dir != "" {
+		r.Register("local", storage.Local{
+			Dir:     conf.LocalStorage.Dir,
+			BaseURL: conf.LocalStorage.BaseURL,
+		})
+	}
+
+	log.Printf("storage backends: %v\n", r.Names())
+	return r
+}
+
+// InitMetrics creates the metrics backends which have been configured in the
+// environment: statsd (muted in debugging mode to avoid contaminating
+// production stats), and Prometheus. The latter is nil if it is disabled.
+func InitMetrics(conf Config) (metrics.Metrics, *metrics.Prometheus) {
 	muteStatsd := gin.IsDebugging()
 	if conf.Statsd.Address == "" {
 		muteStatsd = true
@@ -42,9 +98,180 @@ func InitMiddleware(router *gin.Engine, conf Config) {
 	if err != nil {
 		panic(err)
 	}
-	router.Use(StatsdMiddleware(s))
+	m := metrics.Multi{metrics.Statsd{Client: s}}
+
+	if !conf.Prometheus {
+		return m, nil
+	}
+	p := metrics.NewPrometheus()
+	return append(m, p), p
+}
+
+// InitJobStore creates the store of asynchronous jobs, and it prunes the
+// expired jobs every jobPruneInterval. It is persisted in JobDir if it is set
+// (the jobs are only held in memory otherwise).
+func InitJobStore(conf Config) *converter.JobStore {
+	ttl := time.Second * time.Duration(conf.JobTTL)
+	if conf.JobDir == "" {
+		js := converter.NewJobStore(ttl)
+		go js.PruneEvery(jobPruneInterval, nil)
+		return js
+	}
+
+	// Local sources are kept with the persisted jobs
+	if err := os.MkdirAll(filepath.Join(conf.JobDir, "sources"), 0700); err != nil {
+		log.Fatal(err)
+	}
+	js, err := converter.OpenJobStore(filepath.Join(conf.JobDir, "jobs.db"), ttl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("persisted jobs: %s (%d unfinished)\n", conf.JobDir, len(js.Unfinished()))
+	go js.PruneEvery(jobPruneInterval, nil)
+	return js
+}
+
+// InitPolicyProxy starts the proxy through which the renderers load the
+// pages, so that the URL policy applies to their redirects, and resources.
+func InitPolicyProxy(conf Config) *converter.PolicyProxy {
+	px, err := converter.ListenPolicyProxy(conf.URLPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("renderer proxy: %s\n", px.URL())
+	return px
+}
+
+// InitLimiter creates the limiter enforcing the quotas of the API keys. Their
+// usage is persisted in QuotaFile if it is set.
+func InitLimiter(conf Config) *auth.Limiter {
+	if conf.QuotaFile == "" {
+		return auth.NewLimiter()
+	}
+	l, err := auth.OpenLimiter(conf.QuotaFile, 10*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("persisted quotas: %s\n", conf.QuotaFile)
+	return l
+}
+
+// InitConverters creates a registry containing the converters, and it checks
+// that the converter chain in the environment config only contains
+// registered converters. The chromium converter shares the browser b.
+func InitConverters(conf Config, b *chromium.Browser, px *converter.PolicyProxy) *converter.Registry {
+	r := converter.NewRegistry()
+
+	r.Register("athenapdf", converter.Backend{
+		New: func(aggressive bool) converter.Converter {
+			return athenapdf.AthenaPDF{CMD: conf.AthenaCMD, Aggressive: aggressive, Proxy: px.URL()}
+		},
+		Capabilities: athenapdf.Capabilities,
+	})
+
+	r.Register("cloudconvert", converter.Backend{
+		New: func(bool) converter.Converter {
+			cc := cloudconvert.Client{BaseURL: conf.CloudConvert.APIUrl, APIKey: conf.CloudConvert.APIKey}
+			return cloudconvert.CloudConvert{Client: cc}
+		},
+		Capabilities: cloudconvert.Capabilities,
+	})
+
+	r.Register("chromium", converter.Backend{
+		New: func(bool) converter.Converter {
+			return chromium.Chromium{Browser: b, Policy: &conf.URLPolicy}
+		},
+		Capabilities: chromium.Capabilities,
+	})
+
+	r.Register("wkhtmltopdf", converter.Backend{
+		New: func(bool) converter.Converter {
+			return wkhtmltopdf.WkHTMLToPDF{CMD: conf.WkHTMLToPDFCMD, Policy: &conf.URLPolicy, Proxy: px.URL()}
+		},
+		Capabilities: wkhtmltopdf.Capabilities,
+	})
+
+	for _, name := range conf.Converters {
+		if _, err := r.Get(name); err != nil {
+			log.Fatalf("Unknown converter: %s (WEAVER_CONVERTERS)", name)
+		}
+	}
+	log.Printf("converters: %v (fallback: %t)\n", conf.Converters, conf.ConversionFallback)
+	return r
+}
+
+// InitCache creates the result cache configured in the environment. It
+// returns nil if the cache is disabled. Expired entries of a disk cache are
+// pruned periodically.
+func InitCache(conf Config) cache.Cache {
+	switch conf.Cache {
+	case "":
+		return nil
+	}
+	if conf.CacheTTL <= 0 {
+		log.Fatal("The cache TTL must be a positive number of seconds (WEAVER_CACHE_TTL)")
+	}
+
+	switch conf.Cache {
+	case "memory":
+		return cache.NewMemory(conf.CacheMaxSize)
+	case "disk":
+		if conf.CacheDir == "" {
+			log.Fatal("No cache directory provided (WEAVER_CACHE_DIR)")
+		}
+		d, err := cache.NewDisk(conf.CacheDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go d.PruneEvery(cachePruneInterval, nil)
+		return d
+	}
+	log.Fatalf("Unknown cache backend: %s (WEAVER_CACHE)", conf.Cache)
+	return nil
+}
+
+// InitMiddleware sets up the necessary middlewares for the microservice.
+// These include middlewares to establish a sane context containing access to
+// the configuration, worker queue (and its stats), converters, result cache,
+// job store, metrics, and
+// Sentry client (Raven). The latter is disabled in debugging mode.
+// It will also set up a middleware for catching, and handling errors thrown
+// from a route.
+// The unfinished jobs of a persisted job store are resumed (their callbacks
+// are signed with the keys in the key store).
+func InitMiddleware(router *gin.Engine, conf Config, m metrics.Metrics, d *Drainer, js *converter.JobStore, b *chromium.Browser, px *converter.PolicyProxy, ks *auth.KeyStore) {
+	// Config
+	router.Use(ConfigMiddleware(conf))
+
+	// In-flight conversions (drained on shutdown)
+	router.Use(DrainerMiddleware(d))
+
+	// Worker queue
+	wq, ws := converter.InitWorkers(conf.MaxWorkers, conf.MaxConversionQueue, conf.WorkerTimeout, conf.Scheduler, m)
+	router.Use(WorkQueueMiddleware(wq))
+	router.Use(WorkerStatsMiddleware(ws))
+
+	// Asynchronous jobs
+	router.Use(JobStoreMiddleware(js))
+
+	// Converters
+	cr := InitConverters(conf, b, px)
+	router.Use(ConverterMiddleware(cr))
+
+	// Result cache
+	if rc := InitCache(conf); rc != nil {
+		router.Use(CacheMiddleware(rc))
+	}
+
+	// Storage
+	reg := InitStorage(conf)
+	router.Use(StorageMiddleware(reg))
+
+	// Metrics (statsd, Prometheus)
+	router.Use(MetricsMiddleware(m))
 
 	// Sentry (崩溃报告)
+	var rc *raven.Client
 	if !gin.IsDebugging() && conf.SentryDSN != "" {
 		r, err := raven.New(conf.SentryDSN)
 		if err != nil {
@@ -52,26 +279,87 @@ func InitMiddleware(router *gin.Engine, conf Config) {
 		}
 		router.Use(SentryMiddleware(r))
 		router.Use(sentry.Recovery(r, true))
+		rc = r
 	}
 
 	// Error handler
 	router.Use(ErrorMiddleware())
+
+	// Resume persisted jobs
+	r := runner{conf: conf, reg: cr, wq: wq, ws: ws, m: m, raven: rc, abort: d.Aborted()}
+	resumeJobs(r, d, js, reg, ks)
+}
+
+// InitKeyStore loads the API keys from the key file, and reloads them when the
+// file is modified. If there is no key file, the only key is the auth key
+// (without a tenant, and with the 'default' ID).
+func InitKeyStore(conf Config) *auth.KeyStore {
+	if conf.AuthKeysFile == "" {
+		return auth.NewKeyStore(auth.Key{ID: "default", Key: conf.AuthKey, Enabled: true})
+	}
+
+	ks, err := auth.LoadKeyStore(conf.AuthKeysFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("API keys: %s (%d keys)\n", conf.AuthKeysFile, ks.Len())
+	go ks.Watch(keyReloadInterval, nil)
+	return ks
+}
+
+// InitVerifier creates the verifier of the signed URLs, using the public key
+// in the environment config for JWTs (if any).
+func InitVerifier(conf Config, ks *auth.KeyStore) *auth.Verifier {
+	if conf.JWTPublicKeyFile == "" {
+		return auth.NewVerifier(ks, nil)
+	}
+
+	b, err := ioutil.ReadFile(conf.JWTPublicKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pub, err := auth.ParsePublicKey(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return auth.NewVerifier(ks, pub)
 }
 
 // InitSecureRoutes creates the necessary conversion routes with a middleware
-// to restrict access via an auth key (defined in the environment config).
-func InitSecureRoutes(router *gin.Engine, conf Config) {
+// to restrict access via the API keys in a key store (or signed URLs).
+// Conversions are limited by the quota of their key (see l). The stats are
+// only served here if they require an API key (see InitSimpleRoutes).
+func InitSecureRoutes(router *gin.Engine, conf Config, ks *auth.KeyStore, v *auth.Verifier, l *auth.Limiter) {
 	authorized := router.Group("/")
-	authorized.Use(AuthorizationMiddleware(conf.AuthKey))
-	authorized.GET("/convert", convertByURLHandler)
-	authorized.POST("/convert", convertByFileHandler)
+	authorized.Use(AuthorizationMiddleware(ks, v))
+	limited := RateLimitMiddleware(l)
+	authorized.GET("/convert", limited, ConversionMiddleware(), convertByURLHandler)
+	authorized.POST("/convert", limited, ConversionMiddleware(), convertByFileHandler)
+	authorized.POST("/v2/convert", limited, ConversionMiddleware(), convertV2Handler)
+	authorized.POST("/jobs", limited, ConversionMiddleware(), createJobHandler)
+	authorized.GET("/jobs/:id", jobStatusHandler)
+	authorized.GET("/jobs/:id/result", jobResultHandler)
+
+	if conf.StatsAuth {
+		authorized.GET("/stats", statsHandler)
+	}
 }
 
 // InitSimpleRoutes creates non-essential routes for monitoring and/or
 // debugging. 监控和调试使用的接口
-func InitSimpleRoutes(router *gin.Engine, conf Config) {
+// The Prometheus metrics are only served if p is not nil.
+func InitSimpleRoutes(router *gin.Engine, conf Config, p *metrics.Prometheus) {
 	router.GET("/", indexHandler)
-	router.GET("/stats", statsHandler)
+	router.GET("/healthz", healthzHandler)
+	router.GET("/readyz", readyzHandler(conf))
+
+	if !conf.StatsAuth {
+		router.GET("/stats", statsHandler)
+	}
+
+	if p != nil {
+		router.GET("/metrics", gin.WrapH(p.Handler()))
+	}
 
 	if gin.IsDebugging() {
 		// monitor.GinWrap(router)
@@ -83,9 +371,19 @@ func main() {
 	router := gin.Default()
 	// Get config vars from the environment
 	conf := NewEnvConfig()
-	InitMiddleware(router, conf)
-	InitSecureRoutes(router, conf)
-	InitSimpleRoutes(router, conf)
+	m, p := InitMetrics(conf)
+	d := NewDrainer()
+	js := InitJobStore(conf)
+	px := InitPolicyProxy(conf)
+	// The browser is only launched by the first chromium conversion
+	b := chromium.NewBrowser(conf.ChromiumCMD, conf.ChromiumURL, px.URL())
+	ks := InitKeyStore(conf)
+	InitMiddleware(router, conf, m, d, js, b, px, ks)
+	l := InitLimiter(conf)
+	InitSecureRoutes(router, conf, ks, InitVerifier(conf, ks), l)
+	InitSimpleRoutes(router, conf, p)
+
+	servers := []*http.Server{{Addr: conf.HTTPAddr, Handler: router}}
 
 	if conf.HTTPSAddr != "" {
 		if conf.TLSCertFile == "" {
@@ -96,10 +394,32 @@ func main() {
 			log.Fatal("No TLS key file provided (WEAVER_TLS_KEY_FILE)")
 		}
 
+		srv := &http.Server{Addr: conf.HTTPSAddr, Handler: router}
+		servers = append(servers, srv)
 		go func() {
-			log.Fatal(router.RunTLS(conf.HTTPSAddr, conf.TLSCertFile, conf.TLSKeyFile))
+			if err := srv.ListenAndServeTLS(conf.TLSCertFile, conf.TLSKeyFile); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
 		}()
 	}
 
-	log.Fatal(router.Run(conf.HTTPAddr))
+	go func() {
+		if err := servers[0].ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Drain the in-flight conversions on shutdown (e.g. rolling deploys)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	log.Printf("received %s, shutting down...\n", <-sig)
+	Shutdown(d, time.Second*time.Duration(conf.ShutdownTimeout), servers...)
+	b.Close()
+	px.Close()
+	if err := js.Close(); err != nil {
+		log.Println(err)
+	}
+	if err := l.Close(); err != nil {
+		log.Println(err)
+	}
 }
@@ -0,0 +1,56 @@
+// Package metrics records the telemetry of weaver (e.g. conversion counters,
+// and durations), and sends it to one or more backends (statsd, Prometheus).
+package metrics
+
+import (
+	"time"
+)
+
+// Metrics records the telemetry of weaver.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Increment increments a counter, e.g. 'success'.
+	Increment(name string)
+	// Error increments the error counter for a cause, e.g.
+	// 'conversion_timeout'.
+	Error(cause string)
+	// Gauge sets the current value of a gauge, e.g. 'queue_depth'.
+	Gauge(name string, value int)
+	// Timing records the duration of an operation for a converter backend,
+	// e.g. 'conversion_duration' for 'athenapdf'.
+	Timing(name, backend string, d time.Duration)
+}
+
+// Multi sends the telemetry to every Metrics it contains.
+type Multi []Metrics
+
+// Increment increments a counter in every Metrics.
+func (m Multi) Increment(name string) {
+	for _, mm := range m {
+		mm.Increment(name)
+	}
+}
+
+// Error increments the error counter for a cause in every Metrics.
+func (m Multi) Error(cause string) {
+	for _, mm := range m {
+		mm.Error(cause)
+	}
+}
+
+// Gauge sets the current value of a gauge in every Metrics.
+func (m Multi) Gauge(name string, value int) {
+	for _, mm := range m {
+		mm.Gauge(name, value)
+	}
+}
+
+// Timing records a duration in every Metrics.
+func (m Multi) Timing(name, backend string, d time.Duration) {
+	for _, mm := range m {
+		mm.Timing(name, backend, d)
+	}
+}
+
+// Discard is a Metrics which does not record anything.
+var Discard Metrics = Multi(nil)
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type mockMetrics struct {
+	calls []string
+}
+
+func (m *mockMetrics) Increment(name string) {
+	m.calls = append(m.calls, "increment "+name)
+}
+
+func (m *mockMetrics) Error(cause string) {
+	m.calls = append(m.calls, "error "+cause)
+}
+
+func (m *mockMetrics) Gauge(name string, value int) {
+	m.calls = append(m.calls, "gauge "+name)
+}
+
+func (m *mockMetrics) Timing(name, backend string, d time.Duration) {
+	m.calls = append(m.calls, "timing "+name+" "+backend)
+}
+
+func TestMulti(t *testing.T) {
+	a, b := new(mockMetrics), new(mockMetrics)
+	m := Multi{a, b}
+	m.Increment("success")
+	m.Error("conversion_timeout")
+	m.Gauge("queue_depth", 1)
+	m.Timing("conversion_duration", "athenapdf", time.Second)
+
+	want := []string{
+		"increment success",
+		"error conversion_timeout",
+		"gauge queue_depth",
+		"timing conversion_duration athenapdf",
+	}
+	for _, mm := range []*mockMetrics{a, b} {
+		if !reflect.DeepEqual(mm.calls, want) {
+			t.Errorf("expected calls to be %+v, got %+v", want, mm.calls)
+		}
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	// It should not panic
+	Discard.Increment("success")
+	Discard.Error("conversion_timeout")
+	Discard.Gauge("queue_depth", 1)
+	Discard.Timing("conversion_duration", "athenapdf", time.Second)
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DurationBuckets are the histogram buckets (in seconds) used for timings.
+// Conversions normally take a few seconds, and they time out after 90
+// seconds by default.
+var DurationBuckets = []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 90, 120}
+
+// Prometheus exposes the telemetry in the Prometheus exposition format (see
+// Handler). Counters are named 'weaver_<name>_total', gauges
+// 'weaver_<name>', and timings are histograms named 'weaver_<name>_seconds'
+// with a 'backend' label. Errors are counted in 'weaver_errors_total' with a
+// 'cause' label.
+type Prometheus struct {
+	registry *prometheus.Registry
+	errors   *prometheus.CounterVec
+
+	mu         sync.Mutex
+	counters   map[string]prometheus.Counter
+	gauges     map[string]prometheus.Gauge
+	histograms map[string]*prometheus.HistogramVec
+}
+
+// NewPrometheus creates, and returns a new Prometheus with its own registry.
+// The registry also contains the Go runtime, and process metrics.
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "weaver",
+			Name:      "errors_total",
+			Help:      "Number of errors by cause.",
+		}, []string{"cause"}),
+		counters:   make(map[string]prometheus.Counter),
+		gauges:     make(map[string]prometheus.Gauge),
+		histograms: make(map[string]*prometheus.HistogramVec),
+	}
+	p.registry.MustRegister(
+		p.errors,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return p
+}
+
+// Increment increments the 'weaver_<name>_total' counter.
+func (p *Prometheus) Increment(name string) {
+	p.mu.Lock()
+	c, ok := p.counters[name]
+	if !ok {
+		c = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "weaver",
+			Name:      name + "_total",
+			Help:      "Number of " + name + " events.",
+		})
+		p.registry.MustRegister(c)
+		p.counters[name] = c
+	}
+	p.mu.Unlock()
+	c.Inc()
+}
+
+// Error increments the 'weaver_errors_total' counter for the cause.
+func (p *Prometheus) Error(cause string) {
+	p.errors.WithLabelValues(cause).Inc()
+}
+
+// Gauge sets the 'weaver_<name>' gauge.
+func (p *Prometheus) Gauge(name string, value int) {
+	p.mu.Lock()
+	g, ok := p.gauges[name]
+	if !ok {
+		g = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "weaver",
+			Name:      name,
+			Help:      "Current " + name + ".",
+		})
+		p.registry.MustRegister(g)
+		p.gauges[name] = g
+	}
+	p.mu.Unlock()
+	g.Set(float64(value))
+}
+
+// Timing observes a duration in the 'weaver_<name>_seconds' histogram for
+// the backend.
+func (p *Prometheus) Timing(name, backend string, d time.Duration) {
+	p.mu.Lock()
+	h, ok := p.histograms[name]
+	if !ok {
+		h = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "weaver",
+			Name:      name + "_seconds",
+			Help:      "Duration of " + name + " by converter backend.",
+			Buckets:   DurationBuckets,
+		}, []string{"backend"})
+		p.registry.MustRegister(h)
+		p.histograms[name] = h
+	}
+	p.mu.Unlock()
+	h.WithLabelValues(backend).Observe(d.Seconds())
+}
+
+// Handler returns an HTTP handler serving the metrics in the Prometheus
+// exposition format.
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
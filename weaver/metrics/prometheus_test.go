@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus()
+	p.Increment("success")
+	p.Increment("success")
+	p.Error("conversion_timeout")
+	p.Gauge("queue_depth", 3)
+	p.Timing("conversion_duration", "athenapdf", 1500*time.Millisecond)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	p.Handler().ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("unable to read response body: %+v", err)
+	}
+	for _, want := range []string{
+		"weaver_success_total 2",
+		`weaver_errors_total{cause="conversion_timeout"} 1`,
+		"weaver_queue_depth 3",
+		`weaver_conversion_duration_seconds_bucket{backend="athenapdf",le="2.5"} 1`,
+		`weaver_conversion_duration_seconds_count{backend="athenapdf"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected metrics to contain %s", want)
+		}
+	}
+}
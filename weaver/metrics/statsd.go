@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"time"
+
+	"gopkg.in/alexcesaro/statsd.v2"
+)
+
+// Statsd sends the telemetry to a statsd server.
+// Errors are counted in a bucket named after their cause, and timings are
+// sent to a bucket for all the backends, and a bucket for the backend, e.g.
+// 'conversion_duration', and 'conversion_duration.athenapdf'.
+type Statsd struct {
+	Client *statsd.Client
+}
+
+// Increment increments a statsd counter.
+func (s Statsd) Increment(name string) {
+	s.Client.Increment(name)
+}
+
+// Error increments the statsd counter for the cause.
+func (s Statsd) Error(cause string) {
+	s.Client.Increment(cause)
+}
+
+// Gauge sets a statsd gauge.
+func (s Statsd) Gauge(name string, value int) {
+	s.Client.Gauge(name, value)
+}
+
+// Timing sends a statsd timing (in milliseconds).
+func (s Statsd) Timing(name, backend string, d time.Duration) {
+	ms := int(d / time.Millisecond)
+	s.Client.Timing(name, ms)
+	if backend != "" {
+		s.Client.Timing(name+"."+backend, ms)
+	}
+}
@@ -2,17 +2,27 @@ package main
 
 import (
 	"errors"
+	"github.com/arachnys/athenapdf/weaver/auth"
+	"github.com/arachnys/athenapdf/weaver/cache"
 	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/metrics"
+	"github.com/arachnys/athenapdf/weaver/storage"
 	"github.com/getsentry/raven-go"
 	"github.com/gin-gonic/gin"
-	"gopkg.in/alexcesaro/statsd.v2"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	// ErrAuthorization should be returned when the authorization key is invalid.
 	ErrAuthorization = errors.New("invalid authorization key provided")
+	// ErrRouteForbidden should be returned when the authorization key is not
+	// allowed to access a route.
+	ErrRouteForbidden = errors.New("authorization key is not allowed to access this route")
 	// ErrInternalServer should be returned when a private error is returned
 	// from a handler.
 	ErrInternalServer = errors.New("PDF conversion failed due to an internal server error")
@@ -26,12 +36,47 @@ func ConfigMiddleware(conf Config) gin.HandlerFunc {
 }
 
 // WorkQueueMiddleware sets the work queue (write only) in the context.
-func WorkQueueMiddleware(q chan<- converter.Work) gin.HandlerFunc {
+func WorkQueueMiddleware(q *converter.WorkQueue) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Set("queue", q)
 	}
 }
 
+// WorkerStatsMiddleware sets the stats of the workers in the context.
+func WorkerStatsMiddleware(ws *converter.WorkerStats) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("workers", ws)
+	}
+}
+
+// JobStoreMiddleware sets the asynchronous job store in the context.
+func JobStoreMiddleware(js *converter.JobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("jobs", js)
+	}
+}
+
+// CacheMiddleware sets the result cache in the context.
+func CacheMiddleware(rc cache.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("cache", rc)
+	}
+}
+
+// ConverterMiddleware sets the converter registry in the context.
+func ConverterMiddleware(r *converter.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("converters", r)
+	}
+}
+
+// StorageMiddleware sets the storage backend registry in the context.
+func StorageMiddleware(r *storage.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("storage", r)
+	}
+}
+
 // SentryMiddleware sets the Sentry client (Raven) in the context.
 func SentryMiddleware(r *raven.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -39,10 +84,34 @@ func SentryMiddleware(r *raven.Client) gin.HandlerFunc {
 	}
 }
 
-// StatsdMiddleware sets the Statsd client in the context.
-func StatsdMiddleware(s *statsd.Client) gin.HandlerFunc {
+// MetricsMiddleware sets the metrics (e.g. statsd, and Prometheus) in the
+// context.
+func MetricsMiddleware(m metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("metrics", m)
+	}
+}
+
+// DrainerMiddleware sets the drainer of in-flight conversions in the context.
+func DrainerMiddleware(d *Drainer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("drainer", d)
+	}
+}
+
+// ConversionMiddleware tracks a conversion request until it has completed so
+// that it can be drained on shutdown. New conversions are rejected once the
+// microservice is shutting down.
+func ConversionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Set("statsd", s)
+		d := c.MustGet("drainer").(*Drainer)
+		if !d.acquire() {
+			c.AbortWithError(http.StatusServiceUnavailable, ErrShuttingDown).SetType(gin.ErrorTypePublic)
+			return
+		}
+		defer d.release()
+
+		c.Next()
 	}
 }
 
@@ -79,15 +148,89 @@ func ErrorMiddleware() gin.HandlerFunc {
 	}
 }
 
-// AuthorizationMiddleware is a simple authorization middleware which matches
-// an authentication key, provided via a query parameter, against a defined
-// authentication key in the environment config.
-func AuthorizationMiddleware(k string) gin.HandlerFunc {
+// AuthorizationMiddleware matches an authentication key, provided via a query
+// parameter (auth), or an 'Authorization: Bearer' header, against the keys in
+// a key store. GET requests may be authorized by a signed URL instead (see
+// auth.Verifier), if v is not nil. The key, and its tenant are set in the
+// context.
+func AuthorizationMiddleware(ks *auth.KeyStore, v *auth.Verifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Query("auth") != k {
+		k, ok := ks.Lookup(requestKey(c))
+		if !ok && v != nil && c.Request.Method == http.MethodGet {
+			var err error
+			k, err = v.Verify(c.Request.Method, c.Request.URL.Path, c.Request.URL.Query())
+			if err == auth.ErrSignatureExpired || err == auth.ErrSignatureInvalid {
+				c.AbortWithError(http.StatusUnauthorized, err).SetType(gin.ErrorTypePublic)
+				return
+			}
+			ok = err == nil
+		}
+		if !ok {
 			c.AbortWithError(http.StatusUnauthorized, ErrAuthorization).SetType(gin.ErrorTypePublic)
+			return
+		}
+		if !k.Allowed(c.Request.Method, c.Request.URL.Path) {
+			c.AbortWithError(http.StatusForbidden, ErrRouteForbidden).SetType(gin.ErrorTypePublic)
+			return
+		}
+
+		c.Set("key", k)
+		c.Set("tenant", k.Tenant)
+		c.Next()
+	}
+}
+
+// requestKey returns the authentication key of a request. The Authorization
+// header takes precedence over the query parameter.
+func requestKey(c *gin.Context) string {
+	h := c.Request.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return c.Query("auth")
+}
+
+// RateLimitMiddleware enforces the rate limit, and the quotas of the key set
+// by AuthorizationMiddleware. Requests over a limit are rejected with 429 Too
+// Many Requests. The most restrictive limit is described in the
+// X-RateLimit-* headers. The daily, and monthly quotas are refunded unless
+// the handler has admitted the conversion to the work queue (by setting
+// 'admitted').
+func RateLimitMiddleware(l *auth.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k, ok := c.Get("key")
+		if !ok {
+			c.Next()
+			return
+		}
+
+		d := l.Allow(k.(auth.Key))
+		if d.Limit > 0 {
+			c.Header("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+			c.Header("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			c.Header("X-RateLimit-Reset", strconv.FormatInt(d.Reset.Unix(), 10))
+		}
+		if d.Err != nil {
+			retry := int(math.Ceil(d.Reset.Sub(time.Now()).Seconds()))
+			if retry < 1 {
+				retry = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(retry))
+			if d.Err == auth.ErrRateLimited {
+				c.MustGet("metrics").(metrics.Metrics).Error("rate_limited")
+			} else {
+				c.MustGet("metrics").(metrics.Metrics).Error("quota_exceeded")
+			}
+			c.AbortWithError(http.StatusTooManyRequests, d.Err).SetType(gin.ErrorTypePublic)
+			return
 		}
 
 		c.Next()
+
+		// Only the conversions admitted to the work queue are counted, not
+		// the invalid, or rejected requests, nor those served from the cache
+		if !c.GetBool("admitted") {
+			l.Refund(k.(auth.Key))
+		}
 	}
 }
@@ -2,14 +2,19 @@ package main
 
 import (
 	"errors"
+	"github.com/arachnys/athenapdf/weaver/auth"
 	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/metrics"
+	"github.com/arachnys/athenapdf/weaver/storage"
 	"github.com/getsentry/raven-go"
 	"github.com/gin-gonic/gin"
 	"gopkg.in/alexcesaro/statsd.v2"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -36,14 +41,11 @@ func TestConfigMiddleware(t *testing.T) {
 
 func TestWorkQueueMiddleware(t *testing.T) {
 	r := gin.Default()
-	mockWq := make(chan converter.Work, 17)
-	var ctxWq chan<- converter.Work
+	mockWq := converter.NewWorkQueue(17, converter.SchedulerOptions{})
+	var ctxWq *converter.WorkQueue
 	r.Use(WorkQueueMiddleware(mockWq))
 	r.GET("/", func(c *gin.Context) {
-		ctxWq = c.MustGet("queue").(chan<- converter.Work)
-		go func(wq chan<- converter.Work) {
-			ctxWq <- converter.Work{}
-		}(ctxWq)
+		ctxWq = c.MustGet("queue").(*converter.WorkQueue)
 	})
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/", nil)
@@ -51,10 +53,103 @@ func TestWorkQueueMiddleware(t *testing.T) {
 	if got, want := res.Code, http.StatusOK; got != want {
 		t.Fatalf("expected response code to be %d, got %d", want, got)
 	}
-	select {
-	case <-mockWq:
-	case <-time.After(time.Second):
-		t.Errorf("expected to receive work from queue before timeout")
+	if ctxWq != mockWq {
+		t.Errorf("expected work queue in context to be %p, got %p", mockWq, ctxWq)
+	}
+}
+
+func TestJobStoreMiddleware(t *testing.T) {
+	r := gin.Default()
+	mockJs := converter.NewJobStore(time.Hour)
+	var ctxJs *converter.JobStore
+	r.Use(JobStoreMiddleware(mockJs))
+	r.GET("/", func(c *gin.Context) {
+		ctxJs = c.MustGet("jobs").(*converter.JobStore)
+	})
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	if ctxJs != mockJs {
+		t.Errorf("expected job store in context to be %p, got %p", mockJs, ctxJs)
+	}
+}
+
+func TestWorkerStatsMiddleware(t *testing.T) {
+	r := gin.Default()
+	mockWs := converter.NewWorkerStats(1)
+	var ctxWs *converter.WorkerStats
+	r.Use(WorkerStatsMiddleware(mockWs))
+	r.GET("/", func(c *gin.Context) {
+		ctxWs = c.MustGet("workers").(*converter.WorkerStats)
+	})
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	if ctxWs != mockWs {
+		t.Errorf("expected worker stats in context to be %p, got %p", mockWs, ctxWs)
+	}
+}
+
+func TestDrainerMiddleware(t *testing.T) {
+	r := gin.Default()
+	mockDrainer := NewDrainer()
+	var ctxDrainer *Drainer
+	r.Use(DrainerMiddleware(mockDrainer))
+	r.GET("/", func(c *gin.Context) {
+		ctxDrainer = c.MustGet("drainer").(*Drainer)
+	})
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	if ctxDrainer != mockDrainer {
+		t.Errorf("expected drainer in context to be %p, got %p", mockDrainer, ctxDrainer)
+	}
+}
+
+func TestConverterMiddleware(t *testing.T) {
+	r := gin.Default()
+	mockRegistry := converter.NewRegistry()
+	var ctxRegistry *converter.Registry
+	r.Use(ConverterMiddleware(mockRegistry))
+	r.GET("/", func(c *gin.Context) {
+		ctxRegistry = c.MustGet("converters").(*converter.Registry)
+	})
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	if ctxRegistry != mockRegistry {
+		t.Errorf("expected converter registry in context to be %p, got %p", mockRegistry, ctxRegistry)
+	}
+}
+
+func TestStorageMiddleware(t *testing.T) {
+	r := gin.Default()
+	mockRegistry := storage.NewRegistry()
+	var ctxRegistry *storage.Registry
+	r.Use(StorageMiddleware(mockRegistry))
+	r.GET("/", func(c *gin.Context) {
+		ctxRegistry = c.MustGet("storage").(*storage.Registry)
+	})
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	if ctxRegistry != mockRegistry {
+		t.Errorf("expected storage registry in context to be %p, got %p", mockRegistry, ctxRegistry)
 	}
 }
 
@@ -77,13 +172,13 @@ func TestSentryMiddleware(t *testing.T) {
 	}
 }
 
-func TestStatsdMiddleware(t *testing.T) {
+func TestMetricsMiddleware(t *testing.T) {
 	r := gin.Default()
-	mockStatsd := new(statsd.Client)
-	var ctxStatsd *statsd.Client
-	r.Use(StatsdMiddleware(mockStatsd))
+	mockStatsd := metrics.Statsd{Client: new(statsd.Client)}
+	var ctxStatsd metrics.Metrics
+	r.Use(MetricsMiddleware(mockStatsd))
 	r.GET("/", func(c *gin.Context) {
-		ctxStatsd = c.MustGet("statsd").(*statsd.Client)
+		ctxStatsd = c.MustGet("metrics").(metrics.Metrics)
 	})
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/", nil)
@@ -92,7 +187,7 @@ func TestStatsdMiddleware(t *testing.T) {
 		t.Fatalf("expected response code to be %d, got %d", want, got)
 	}
 	if !reflect.DeepEqual(mockStatsd, ctxStatsd) {
-		t.Errorf("expected statsd in context to be %+v, got %+v", mockStatsd, ctxStatsd)
+		t.Errorf("expected metrics in context to be %+v, got %+v", mockStatsd, ctxStatsd)
 	}
 }
 
@@ -120,7 +215,7 @@ func TestErrorMiddleware(t *testing.T) {
 
 func TestAuthorizationMiddleware(t *testing.T) {
 	r := gin.Default()
-	r.Use(AuthorizationMiddleware("123456"))
+	r.Use(AuthorizationMiddleware(auth.NewKeyStore(auth.Key{Key: "123456", Enabled: true}), nil))
 	r.GET("/", func(c *gin.Context) {})
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/?auth=123456", nil)
@@ -132,7 +227,7 @@ func TestAuthorizationMiddleware(t *testing.T) {
 
 func TestAuthorizationMiddleware_authFailure(t *testing.T) {
 	r := gin.Default()
-	r.Use(AuthorizationMiddleware("123456"))
+	r.Use(AuthorizationMiddleware(auth.NewKeyStore(auth.Key{Key: "123456", Enabled: true}), nil))
 	r.GET("/", func(c *gin.Context) {})
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/", nil)
@@ -141,3 +236,124 @@ func TestAuthorizationMiddleware_authFailure(t *testing.T) {
 		t.Fatalf("expected response code to be %d, got %d", want, got)
 	}
 }
+
+func TestAuthorizationMiddleware_bearer(t *testing.T) {
+	ks := auth.NewKeyStore(auth.Key{Key: "123456", Tenant: "acme", Enabled: true})
+	r := gin.Default()
+	r.Use(AuthorizationMiddleware(ks, nil))
+	r.GET("/", func(c *gin.Context) {
+		if got, want := c.GetString("tenant"), "acme"; got != want {
+			t.Errorf("expected tenant to be %s, got %s", want, got)
+		}
+	})
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer 123456")
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+}
+
+func TestAuthorizationMiddleware_revoked(t *testing.T) {
+	r := gin.Default()
+	r.Use(AuthorizationMiddleware(auth.NewKeyStore(auth.Key{Key: "123456", Enabled: false}), nil))
+	r.GET("/", func(c *gin.Context) {})
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/?auth=123456", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusUnauthorized; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+}
+
+func TestAuthorizationMiddleware_routeForbidden(t *testing.T) {
+	ks := auth.NewKeyStore(auth.Key{Key: "123456", Enabled: true, Routes: []string{"/jobs"}})
+	r := gin.Default()
+	r.Use(AuthorizationMiddleware(ks, nil))
+	r.GET("/convert", func(c *gin.Context) {})
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/convert?auth=123456", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusForbidden; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	ks := auth.NewKeyStore(auth.Key{Key: "123456", Enabled: true, Quota: auth.Quota{Daily: 1}})
+	r := gin.Default()
+	r.Use(MetricsMiddleware(metrics.Statsd{Client: &statsd.Client{}}))
+	r.Use(ErrorMiddleware())
+	r.Use(AuthorizationMiddleware(ks, nil))
+	r.Use(RateLimitMiddleware(auth.NewLimiter()))
+	r.GET("/", func(c *gin.Context) {
+		if c.Query("admitted") != "" {
+			c.Set("admitted", true)
+		}
+	})
+
+	// Requests which are not admitted do not count against the quota
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/?auth=123456", nil)
+	for i := 0; i < 2; i++ {
+		r.ServeHTTP(res, req)
+		if got, want := res.Code, http.StatusOK; got != want {
+			t.Fatalf("expected response code to be %d, got %d", want, got)
+		}
+	}
+
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/?auth=123456&admitted=1", nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	if got, want := res.Header().Get("X-RateLimit-Remaining"), "0"; got != want {
+		t.Errorf("expected X-RateLimit-Remaining header to be %s, got %s", want, got)
+	}
+
+	res = httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusTooManyRequests; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+	if !strings.Contains(res.Body.String(), auth.ErrDailyQuota.Error()) {
+		t.Errorf("expected response body to contain %q, got %s", auth.ErrDailyQuota, res.Body.String())
+	}
+	if res.Header().Get("Retry-After") == "" {
+		t.Errorf("expected Retry-After header to be set")
+	}
+}
+
+func TestAuthorizationMiddleware_signed(t *testing.T) {
+	ks := auth.NewKeyStore(auth.Key{ID: "web", Key: "123456", Tenant: "acme", Enabled: true})
+	r := gin.Default()
+	r.Use(AuthorizationMiddleware(ks, auth.NewVerifier(ks, nil)))
+	r.GET("/convert", func(c *gin.Context) {
+		if got, want := c.GetString("tenant"), "acme"; got != want {
+			t.Errorf("expected tenant to be %s, got %s", want, got)
+		}
+	})
+
+	q := url.Values{}
+	q.Set("url", "http://www.example.com")
+	q.Set(auth.KeyIDParam, "web")
+	q.Set(auth.ExpiresParam, strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	q.Set(auth.SignatureParam, auth.Sign("123456", "GET", "/convert", q))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/convert?"+q.Encode(), nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+
+	q.Set("url", "http://www.evil.com")
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/convert?"+q.Encode(), nil)
+	r.ServeHTTP(res, req)
+	if got, want := res.Code, http.StatusUnauthorized; got != want {
+		t.Fatalf("expected response code to be %d, got %d", want, got)
+	}
+}
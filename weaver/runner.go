@@ -0,0 +1,219 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/auth"
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/metrics"
+	"github.com/getsentry/raven-go"
+	"github.com/gin-gonic/gin"
+)
+
+// conversionRequest contains everything needed to run a single conversion,
+// independently of the HTTP request that created it.
+type conversionRequest struct {
+	source     converter.ConversionSource
+	aggressive bool
+	// converter (optional) is tried before the converters of the chain.
+	converter string
+	// destination for the output of the conversion (optional).
+	destination converter.Destination
+	// callbackURL (optional) will receive the result of an asynchronous job.
+	callbackURL string
+	// callbackKey is the API key which has submitted an asynchronous job. It
+	// signs the callback.
+	callbackKey auth.Key
+	// class is used for scheduling the conversion in the work queue.
+	class converter.Class
+	// async is true for asynchronous jobs: they wait for a free slot in the
+	// work queue instead of failing with converter.ErrQueueFull.
+	async bool
+}
+
+// conversionResult is the outcome of a conversion. Only one of its fields
+// will be set (besides admitted).
+type conversionResult struct {
+	out       []byte
+	location  string
+	err       error
+	cancelled bool
+	// admitted is true if the conversion has been added to the work queue.
+	admitted bool
+}
+
+// runner runs conversions through the worker queue. It holds its own
+// references to the services in the context so that it can outlive the
+// request (e.g. for asynchronous jobs).
+type runner struct {
+	conf  Config
+	reg   *converter.Registry
+	wq    *converter.WorkQueue
+	ws    *converter.WorkerStats
+	m     metrics.Metrics
+	raven *raven.Client
+	// abort is closed when the microservice is shutting down, and the
+	// conversion must be terminated.
+	abort <-chan struct{}
+}
+
+// newRunner creates a runner using the services set in the context by
+// InitMiddleware.
+func newRunner(c *gin.Context) runner {
+	r := runner{
+		conf:  c.MustGet("config").(Config),
+		reg:   c.MustGet("converters").(*converter.Registry),
+		wq:    c.MustGet("queue").(*converter.WorkQueue),
+		ws:    c.MustGet("workers").(*converter.WorkerStats),
+		m:     c.MustGet("metrics").(metrics.Metrics),
+		abort: c.MustGet("drainer").(*Drainer).Aborted(),
+	}
+	if rc, ok := c.Get("sentry"); ok {
+		r.raven = rc.(*raven.Client)
+	}
+	return r
+}
+
+// chain returns the names of the converters to try in order: the converter
+// of the request (if any), followed by the converters in the config. Only the
+// first one is returned if ConversionFallback is disabled. The converters
+// which do not support the conversion are skipped.
+func (r runner) chain(req conversionRequest) []string {
+	names := r.conf.Converters
+	if req.converter != "" {
+		names = []string{req.converter}
+		for _, name := range r.conf.Converters {
+			if name != req.converter {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var chain []string
+	for _, name := range names {
+		if r.reg.Supports(name, req.source, req.aggressive) != nil {
+			continue
+		}
+		chain = append(chain, name)
+		if !r.conf.ConversionFallback {
+			break
+		}
+	}
+	return chain
+}
+
+// queue adds the work of a conversion to the work queue. If the queue is full,
+// synchronous conversions fail with converter.ErrQueueFull (after
+// QueueWait), and asynchronous jobs wait for a free slot until they are
+// aborted on shutdown (ErrShuttingDown).
+func (r runner) queue(req conversionRequest, c converter.Converter) (converter.Work, error) {
+	for {
+		// Taken before trying so that a slot freed in between is not missed
+		freed := r.wq.Freed()
+		work, err := converter.NewWork(r.wq, c, req.source, req.destination, req.class, time.Second*time.Duration(r.conf.QueueWait))
+		if err != converter.ErrQueueFull || !req.async {
+			return work, err
+		}
+		select {
+		case <-freed:
+		case <-r.abort:
+			return converter.Work{}, ErrShuttingDown
+		}
+	}
+}
+
+// captureError sends an error to Sentry (if enabled).
+func (r runner) captureError(err error, uri string) {
+	if r.raven != nil {
+		r.raven.CaptureError(err, map[string]string{"url": uri})
+	}
+}
+
+// reject records a conversion rejected because the work queue is full.
+func (r runner) reject() {
+	r.m.Error("queue_full")
+	r.ws.Reject()
+}
+
+// run queues a conversion, and blocks until it has completed, failed or it
+// has been cancelled through the done channel. It fails with ErrShuttingDown
+// if it is aborted on shutdown, and with converter.ErrQueueFull if the work
+// queue is full (see queue), and with converter.ErrNoConverter if none of
+// the converters supports it. It will fall back to the next converter in the
+// chain on failure if ConversionFallback is enabled.
+// started (optional) is called every time a worker picks up the conversion.
+func (r runner) run(req conversionRequest, done <-chan struct{}, started func()) (res conversionResult) {
+	start := time.Now()
+	admitted := false
+	defer func() { res.admitted = admitted }()
+
+	chain := r.chain(req)
+	if len(chain) == 0 {
+		return conversionResult{err: converter.ErrNoConverter}
+	}
+
+	var err error
+	for attempt, backend := range chain {
+		b, _ := r.reg.Get(backend)
+		var work converter.Work
+		work, err = r.queue(req, b.New(req.aggressive))
+		if err == converter.ErrQueueFull {
+			r.reject()
+			return conversionResult{err: err}
+		} else if err == ErrShuttingDown {
+			return conversionResult{err: err}
+		}
+		admitted = true
+		workStarted := work.Started()
+
+		for err == nil {
+			select {
+			case <-done:
+				work.Cancel()
+				return conversionResult{cancelled: true}
+			case <-r.abort:
+				work.Cancel()
+				return conversionResult{err: ErrShuttingDown}
+			case <-workStarted:
+				// A nil channel blocks forever, so it will only fire once
+				workStarted = nil
+				if started != nil {
+					started()
+				}
+			case out := <-work.Success():
+				r.m.Timing("conversion_duration", backend, time.Since(start))
+				r.m.Increment("success")
+				return conversionResult{out: out}
+			case location := <-work.Stored():
+				r.m.Timing("conversion_duration", backend, time.Since(start))
+				r.m.Increment("success")
+				return conversionResult{location: location}
+			case err = <-work.Error():
+			}
+		}
+
+		log.Println(err)
+
+		// Log, and stats collection
+		if err == converter.ErrConversionTimeout {
+			r.m.Error("conversion_timeout")
+		} else if _, uploadError := err.(*converter.UploadError); uploadError {
+			r.m.Error("upload_error")
+			r.captureError(err, req.source.GetActualURI())
+		} else {
+			r.m.Error("conversion_error")
+			r.captureError(err, req.source.GetActualURI())
+		}
+
+		if attempt+1 < len(chain) {
+			next := chain[attempt+1]
+			r.m.Increment(next)
+			r.ws.Fallback()
+			log.Printf("falling back to %s...\n", next)
+		}
+	}
+
+	r.m.Increment("conversion_failed")
+	return conversionResult{err: err}
+}
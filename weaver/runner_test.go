@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/arachnys/athenapdf/weaver/converter"
+	"github.com/arachnys/athenapdf/weaver/metrics"
+	"gopkg.in/alexcesaro/statsd.v2"
+)
+
+func TestRunnerChain(t *testing.T) {
+	reg := converter.NewRegistry()
+	reg.Register("a", converter.Backend{Capabilities: converter.Capabilities{MimeTypes: []string{"text/html"}}})
+	reg.Register("b", converter.Backend{})
+	reg.Register("c", converter.Backend{})
+	r := runner{conf: Config{Converters: []string{"a", "b", "c"}, ConversionFallback: true}, reg: reg}
+
+	html := conversionRequest{source: converter.ConversionSource{Mime: "text/html"}}
+	if got, want := r.chain(html), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected chain to be %+v, got %+v", want, got)
+	}
+
+	// The converter of the request goes first
+	html.converter = "c"
+	if got, want := r.chain(html), []string{"c", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected chain to be %+v, got %+v", want, got)
+	}
+
+	// Converters which do not support the source are skipped
+	png := conversionRequest{source: converter.ConversionSource{Mime: "image/png"}}
+	if got, want := r.chain(png), []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected chain to be %+v, got %+v", want, got)
+	}
+
+	r.conf.ConversionFallback = false
+	if got, want := r.chain(png), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected chain to be %+v, got %+v", want, got)
+	}
+}
+
+func TestRunnerQueue(t *testing.T) {
+	abort := make(chan struct{})
+	r := runner{wq: converter.NewWorkQueue(0, converter.SchedulerOptions{}), abort: abort}
+
+	// Synchronous conversions are rejected when the queue is full
+	if _, err := r.queue(conversionRequest{}, nil); err != converter.ErrQueueFull {
+		t.Errorf("expected error to be %+v, got %+v", converter.ErrQueueFull, err)
+	}
+
+	// Asynchronous jobs wait for a free slot until they are aborted
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.queue(conversionRequest{async: true}, nil)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		t.Fatalf("expected the job to wait for a free slot, got %+v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(abort)
+	if err := <-errc; err != ErrShuttingDown {
+		t.Errorf("expected error to be %+v, got %+v", ErrShuttingDown, err)
+	}
+}
+
+func TestRunnerRun_queueFull(t *testing.T) {
+	reg := converter.NewRegistry()
+	reg.Register("a", converter.Backend{New: func(bool) converter.Converter { return nil }})
+	r := runner{
+		conf: Config{Converters: []string{"a"}},
+		reg:  reg,
+		wq:   converter.NewWorkQueue(0, converter.SchedulerOptions{}),
+		ws:   converter.NewWorkerStats(1),
+		m:    metrics.Statsd{Client: &statsd.Client{}},
+	}
+
+	// A rejected conversion is not admitted, so it does not count against
+	// the quota of its key
+	res := r.run(conversionRequest{}, make(chan struct{}), nil)
+	if res.err != converter.ErrQueueFull {
+		t.Errorf("expected error to be %+v, got %+v", converter.ErrQueueFull, res.err)
+	}
+	if res.admitted {
+		t.Errorf("expected the conversion not to be admitted")
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInvalidKey should be returned when a key can not be used as a local
+	// file path (e.g. it escapes the storage directory).
+	ErrInvalidKey = errors.New("invalid storage key")
+)
+
+// Local is a storage backend which stores objects as files in a local
+// directory (e.g. a mounted volume).
+type Local struct {
+	// Dir is the directory the objects are stored in. It is created if it
+	// does not exist.
+	Dir string
+	// BaseURL is used to build the URL of an object, e.g.
+	// 'https://files.example.com/pdf'. A 'file://' URL is returned if it is
+	// not set.
+	BaseURL string
+}
+
+// path returns the local file path of a key. Keys may contain slashes, but
+// they must not escape the storage directory.
+func (s Local) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if key == "" || clean == "/" || strings.Contains(key, "..") {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.Dir, clean), nil
+}
+
+// Put writes the bytes to a file. The file is written to a temporary file
+// first, and then renamed, so that readers never see a partial object.
+func (s Local) Put(key string, b []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+
+	log.Printf("[Storage] writing conversion to '%s'\n", p)
+	return os.Rename(f.Name(), p)
+}
+
+// Get reads the bytes of a file.
+func (s Local) Get(key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// URL returns the URL of a file using the BaseURL (if set).
+func (s Local) URL(key string) (string, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	if s.BaseURL == "" {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return "", err
+		}
+		u := url.URL{Scheme: "file", Path: abs}
+		return u.String(), nil
+	}
+
+	rel, err := filepath.Rel(s.Dir, p)
+	if err != nil {
+		return "", err
+	}
+	u := url.URL{Path: filepath.ToSlash(rel)}
+	return strings.TrimRight(s.BaseURL, "/") + "/" + u.EscapedPath(), nil
+}
+
+// Delete removes a file.
+func (s Local) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func mockLocal(t *testing.T) Local {
+	dir, err := ioutil.TempDir("/tmp", "storage")
+	if err != nil {
+		t.Fatalf("unable to create temporary storage directory: %+v", err)
+	}
+	return Local{Dir: dir}
+}
+
+func TestLocal(t *testing.T) {
+	s := mockLocal(t)
+	defer os.RemoveAll(s.Dir)
+
+	mockData := []byte("%PDF-1.4")
+	if err := s.Put("reports/test.pdf", mockData); err != nil {
+		t.Fatalf("put returned an unexpected error: %+v", err)
+	}
+
+	got, err := s.Get("reports/test.pdf")
+	if err != nil {
+		t.Fatalf("get returned an unexpected error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, mockData) {
+		t.Errorf("expected stored object to be %s, got %s", mockData, got)
+	}
+
+	if err := s.Delete("reports/test.pdf"); err != nil {
+		t.Fatalf("delete returned an unexpected error: %+v", err)
+	}
+	if _, err := s.Get("reports/test.pdf"); err != ErrNotFound {
+		t.Errorf("expected a not found error after delete, got %+v", err)
+	}
+}
+
+func TestLocal_URL(t *testing.T) {
+	s := Local{Dir: "/data/pdf"}
+	got, err := s.URL("reports/test file.pdf")
+	if err != nil {
+		t.Fatalf("url returned an unexpected error: %+v", err)
+	}
+	if want := "file:///data/pdf/reports/test%20file.pdf"; got != want {
+		t.Errorf("expected url of object to be %s, got %s", want, got)
+	}
+
+	s.BaseURL = "https://files.example.com/pdf/"
+	got, err = s.URL("reports/test file.pdf")
+	if err != nil {
+		t.Fatalf("url returned an unexpected error: %+v", err)
+	}
+	if want := "https://files.example.com/pdf/reports/test%20file.pdf"; got != want {
+		t.Errorf("expected url of object to be %s, got %s", want, got)
+	}
+}
+
+func TestLocal_invalidKey(t *testing.T) {
+	s := mockLocal(t)
+	defer os.RemoveAll(s.Dir)
+
+	for _, key := range []string{"", "/", "../test.pdf", "reports/../../test.pdf"} {
+		if err := s.Put(key, []byte{}); err != ErrInvalidKey {
+			t.Errorf("expected an invalid key error for '%s', got %+v", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(s.Dir), "test.pdf")); !os.IsNotExist(err) {
+		t.Errorf("expected no file to be written outside the storage directory")
+	}
+}
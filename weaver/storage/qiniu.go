@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Qiniu is a Qiniu Kodo (七牛云存储) storage backend.
+type Qiniu struct {
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	// Domain is the download domain bound to the bucket, e.g.
+	// 'https://cdn.example.com'.
+	Domain string
+	// KeyPrefix is prepended to every key.
+	KeyPrefix string
+	// Private should be true if the bucket is private. Download URLs will be
+	// signed, and they will expire after URLExpiry.
+	Private bool
+	// URLExpiry defaults to 1 hour.
+	URLExpiry time.Duration
+	// UpHost is the upload host of the bucket's region.
+	// Defaults to 'https://upload.qiniup.com'.
+	UpHost string
+	// RSHost is the resource management host.
+	// Defaults to 'https://rs.qiniu.com'.
+	RSHost string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// qiniuPutPolicy is the upload policy encoded in an upload token.
+type qiniuPutPolicy struct {
+	Scope    string `json:"scope"`
+	Deadline int64  `json:"deadline"`
+}
+
+func (s Qiniu) client() *http.Client {
+	if s.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return s.HTTPClient
+}
+
+func (s Qiniu) upHost() string {
+	if s.UpHost == "" {
+		return "https://upload.qiniup.com"
+	}
+	return strings.TrimRight(s.UpHost, "/")
+}
+
+func (s Qiniu) rsHost() string {
+	if s.RSHost == "" {
+		return "https://rs.qiniu.com"
+	}
+	return strings.TrimRight(s.RSHost, "/")
+}
+
+// sign returns the URL-safe base64 encoded HMAC-SHA1 of the data using the
+// secret key.
+func (s Qiniu) sign(data string) string {
+	mac := hmac.New(sha1.New, []byte(s.SecretKey))
+	mac.Write([]byte(data))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// UploadToken returns an upload token which allows a key to be uploaded (or
+// overwritten) in the bucket until the deadline.
+func (s Qiniu) UploadToken(key string, deadline time.Time) (string, error) {
+	policy, err := json.Marshal(qiniuPutPolicy{
+		Scope:    s.Bucket + ":" + key,
+		Deadline: deadline.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.URLEncoding.EncodeToString(policy)
+	return s.AccessKey + ":" + s.sign(encoded) + ":" + encoded, nil
+}
+
+// qiniuError returns an error containing the message from a Qiniu API
+// response.
+func qiniuError(res *http.Response) error {
+	var data struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(res.Body).Decode(&data)
+	return fmt.Errorf("[Qiniu] did not receive HTTP 200 (%s): %s", res.Status, data.Error)
+}
+
+// Put uploads the bytes to the bucket using a form upload.
+func (s Qiniu) Put(key string, b []byte) error {
+	key = s.KeyPrefix + key
+	log.Printf("[Storage] uploading conversion to Qiniu bucket '%s' with key '%s'\n", s.Bucket, key)
+	st := time.Now()
+
+	token, err := s.UploadToken(key, time.Now().Add(time.Hour))
+	if err != nil {
+		return err
+	}
+
+	body := new(bytes.Buffer)
+	bw := multipart.NewWriter(body)
+	if err := bw.WriteField("token", token); err != nil {
+		return err
+	}
+	if err := bw.WriteField("key", key); err != nil {
+		return err
+	}
+	part, err := bw.CreateFormFile("file", key)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(b); err != nil {
+		return err
+	}
+	if err := bw.Close(); err != nil {
+		return err
+	}
+
+	res, err := s.client().Post(s.upHost()+"/", bw.FormDataContentType(), body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return qiniuError(res)
+	}
+
+	et := time.Now()
+	log.Printf("[Storage] uploaded to Qiniu in %s\n", et.Sub(st))
+	return nil
+}
+
+// Get downloads an object from the bucket using its download URL.
+func (s Qiniu) Get(key string) ([]byte, error) {
+	u, err := s.URL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client().Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, qiniuError(res)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// URL returns the download URL of an object. It is signed if the bucket is
+// private.
+func (s Qiniu) URL(key string) (string, error) {
+	k := url.URL{Path: s.KeyPrefix + key}
+	u := strings.TrimRight(s.Domain, "/") + "/" + k.EscapedPath()
+
+	if !s.Private {
+		return u, nil
+	}
+
+	expiry := s.URLExpiry
+	if expiry <= 0 {
+		expiry = time.Hour
+	}
+	u += "?e=" + strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	return u + "&token=" + s.AccessKey + ":" + s.sign(u), nil
+}
+
+// Delete removes an object from the bucket.
+func (s Qiniu) Delete(key string) error {
+	entry := base64.URLEncoding.EncodeToString([]byte(s.Bucket + ":" + s.KeyPrefix + key))
+	path := "/delete/" + entry
+
+	req, err := http.NewRequest("POST", s.rsHost()+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// QBox authorization: the signed data is the path, and the (empty) body
+	req.Header.Set("Authorization", "QBox "+s.AccessKey+":"+s.sign(path+"\n"))
+
+	res, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// 612: the object does not exist
+	if res.StatusCode == 612 {
+		return ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return qiniuError(res)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func mockQiniuSign(data string) string {
+	mac := hmac.New(sha1.New, []byte("test secret"))
+	mac.Write([]byte(data))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// mockQiniuServer is a fake Qiniu upload, resource management, and download
+// host for the 'test-bucket' bucket. It verifies upload tokens, management
+// authorization, and private download tokens.
+func mockQiniuServer(t *testing.T, private bool) *httptest.Server {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		// Form upload
+		case r.Method == "POST" && r.URL.Path == "/":
+			key := r.FormValue("key")
+			token := strings.SplitN(r.FormValue("token"), ":", 3)
+			if len(token) != 3 || token[0] != "test" || token[1] != mockQiniuSign(token[2]) {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"bad token"}`))
+				return
+			}
+			policy, _ := base64.URLEncoding.DecodeString(token[2])
+			var p qiniuPutPolicy
+			json.Unmarshal(policy, &p)
+			if p.Scope != "test-bucket:"+key {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"bad scope"}`))
+				return
+			}
+			f, _, err := r.FormFile("file")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			objects[key], _ = ioutil.ReadAll(f)
+			w.Write([]byte(`{"hash":"test","key":"` + key + `"}`))
+		// Delete
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/delete/"):
+			if got, want := r.Header.Get("Authorization"), "QBox test:"+mockQiniuSign(r.URL.Path+"\n"); got != want {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"bad token"}`))
+				return
+			}
+			entry, _ := base64.URLEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/delete/"))
+			key := strings.TrimPrefix(string(entry), "test-bucket:")
+			if _, ok := objects[key]; !ok {
+				w.WriteHeader(612)
+				w.Write([]byte(`{"error":"no such file or directory"}`))
+				return
+			}
+			delete(objects, key)
+		// Download
+		case r.Method == "GET":
+			if private {
+				u := ts.URL + r.URL.EscapedPath() + "?e=" + r.URL.Query().Get("e")
+				if got, want := r.URL.Query().Get("token"), "test:"+mockQiniuSign(u); got != want {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+			}
+			b, ok := objects[strings.TrimPrefix(r.URL.Path, "/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(b)
+		}
+	}))
+	return ts
+}
+
+func mockQiniu(ts *httptest.Server, private bool) Qiniu {
+	return Qiniu{
+		AccessKey: "test",
+		SecretKey: "test secret",
+		Bucket:    "test-bucket",
+		Domain:    ts.URL,
+		KeyPrefix: "pdf/",
+		Private:   private,
+		UpHost:    ts.URL,
+		RSHost:    ts.URL,
+	}
+}
+
+func expectQiniuRoundTrip(t *testing.T, private bool) {
+	ts := mockQiniuServer(t, private)
+	defer ts.Close()
+	s := mockQiniu(ts, private)
+
+	mockData := []byte("%PDF-1.4")
+	if err := s.Put("test.pdf", mockData); err != nil {
+		t.Fatalf("put returned an unexpected error: %+v", err)
+	}
+
+	got, err := s.Get("test.pdf")
+	if err != nil {
+		t.Fatalf("get returned an unexpected error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, mockData) {
+		t.Errorf("expected stored object to be %s, got %s", mockData, got)
+	}
+
+	if err := s.Delete("test.pdf"); err != nil {
+		t.Fatalf("delete returned an unexpected error: %+v", err)
+	}
+	if err := s.Delete("test.pdf"); err != ErrNotFound {
+		t.Errorf("expected a not found error after delete, got %+v", err)
+	}
+	if _, err := s.Get("test.pdf"); err != ErrNotFound {
+		t.Errorf("expected a not found error after delete, got %+v", err)
+	}
+}
+
+func TestQiniu(t *testing.T) {
+	expectQiniuRoundTrip(t, false)
+}
+
+func TestQiniu_private(t *testing.T) {
+	expectQiniuRoundTrip(t, true)
+}
+
+func TestQiniu_Put_badCredentials(t *testing.T) {
+	ts := mockQiniuServer(t, false)
+	defer ts.Close()
+	s := mockQiniu(ts, false)
+	s.SecretKey = "wrong secret"
+	if err := s.Put("test.pdf", []byte{}); err == nil {
+		t.Fatalf("expected error to be returned")
+	}
+}
+
+func TestQiniu_URL(t *testing.T) {
+	s := Qiniu{Domain: "https://cdn.example.com/", KeyPrefix: "pdf/"}
+	got, err := s.URL("test report.pdf")
+	if err != nil {
+		t.Fatalf("url returned an unexpected error: %+v", err)
+	}
+	if want := "https://cdn.example.com/pdf/test%20report.pdf"; got != want {
+		t.Errorf("expected url of object to be %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var (
+	// ErrS3BucketRequired should be returned when an S3 bucket has not been
+	// configured.
+	ErrS3BucketRequired = errors.New("no S3 bucket provided")
+	// ErrS3SignatureVersion should be returned when an S3 signature version is
+	// not supported.
+	ErrS3SignatureVersion = errors.New("unsupported S3 signature version")
+)
+
+// S3 is an AWS S3 storage backend. It can also be used with S3-compatible
+// object stores (e.g. MinIO, Ceph RGW) by setting a custom endpoint.
+type S3 struct {
+	// Region defaults to 'us-east-1'.
+	Region       string
+	AccessKey    string
+	AccessSecret string
+	Bucket       string
+	// ACL defaults to 'public-read'.
+	ACL string
+	// KeyPrefix is prepended to every key.
+	KeyPrefix string
+	// URLExpiry is the lifetime of pre-signed URLs returned for objects that
+	// are not publicly readable.
+	// Defaults to 1 hour.
+	URLExpiry time.Duration
+	// Endpoint of an S3-compatible object store, e.g. 'http://minio:9000'.
+	// Defaults to AWS.
+	Endpoint string
+	// PathStyle forces path-style addressing (http://host/bucket/key) instead
+	// of virtual-hosted-style addressing (http://bucket.host/key). It is
+	// required by most S3-compatible object stores.
+	PathStyle bool
+	// DisableSSL uses HTTP instead of HTTPS when the endpoint does not
+	// specify a scheme.
+	DisableSSL bool
+	// SignatureVersion is either 'v4' or 'v2'. The latter is only needed by
+	// object stores which do not support AWS signature version 4.
+	// Defaults to 'v4'.
+	SignatureVersion string
+}
+
+func (s S3) acl() string {
+	if s.ACL == "" {
+		return "public-read"
+	}
+	return s.ACL
+}
+
+// client returns an S3 service client using the static credentials (if any)
+// or the default credential chain.
+func (s S3) client() (*s3.S3, error) {
+	if s.Bucket == "" {
+		return nil, ErrS3BucketRequired
+	}
+
+	region := "us-east-1"
+	if s.Region != "" {
+		region = s.Region
+	}
+
+	conf := aws.NewConfig().WithRegion(region).WithMaxRetries(3)
+
+	if s.Endpoint != "" {
+		conf = conf.WithEndpoint(s.Endpoint)
+	}
+	if s.PathStyle {
+		conf = conf.WithS3ForcePathStyle(true)
+	}
+	if s.DisableSSL {
+		conf = conf.WithDisableSSL(true)
+	}
+
+	if s.AccessKey != "" && s.AccessSecret != "" {
+		creds := credentials.NewStaticCredentials(s.AccessKey, s.AccessSecret, "")
+
+		// Credential 'Value'
+		_, err := creds.Get()
+		if err != nil {
+			return nil, err
+		}
+
+		conf = conf.WithCredentials(creds)
+	}
+
+	sess, err := session.NewSession(conf)
+	if err != nil {
+		return nil, err
+	}
+	svc := s3.New(sess)
+
+	switch s.SignatureVersion {
+	case "", "v4":
+	case "v2":
+		svc.Handlers.Sign.Swap(v4.SignRequestHandler.Name, newSignV2Handler(s.Bucket, s.PathStyle))
+	default:
+		return nil, ErrS3SignatureVersion
+	}
+
+	return svc, nil
+}
+
+// Put uploads the bytes as a PDF to the S3 bucket.
+func (s S3) Put(key string, b []byte) error {
+	key = s.KeyPrefix + key
+	log.Printf("[Storage] uploading conversion to S3 bucket '%s' with key '%s'\n", s.Bucket, key)
+	st := time.Now()
+
+	svc, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	p := &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		ACL:         aws.String(s.acl()),
+		ContentType: aws.String("application/pdf"),
+		Body:        bytes.NewReader(b),
+	}
+
+	res, err := svc.PutObject(p)
+	if err != nil {
+		return err
+	}
+
+	et := time.Now()
+	log.Printf("[Storage] uploaded to S3: %s (%s)\n", awsutil.StringValue(res), et.Sub(st))
+	return nil
+}
+
+// Get downloads an object from the S3 bucket.
+func (s S3) Get(key string) ([]byte, error) {
+	svc, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.KeyPrefix + key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// URL returns the URL of an object in the S3 bucket. It is pre-signed if the
+// object is not publicly readable.
+func (s S3) URL(key string) (string, error) {
+	svc, err := s.client()
+	if err != nil {
+		return "", err
+	}
+
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.KeyPrefix + key),
+	})
+
+	if acl := s.acl(); acl == "public-read" || acl == "public-read-write" {
+		if err := req.Build(); err != nil {
+			return "", err
+		}
+		return req.HTTPRequest.URL.String(), nil
+	}
+
+	expiry := s.URLExpiry
+	if expiry <= 0 {
+		expiry = time.Hour
+	}
+	return req.Presign(expiry)
+}
+
+// Delete removes an object from the S3 bucket.
+func (s S3) Delete(key string) error {
+	svc, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.KeyPrefix + key),
+	})
+	return err
+}
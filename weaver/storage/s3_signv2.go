@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// newSignV2Handler returns a handler which signs S3 requests for a bucket
+// using AWS signature version 2.
+func newSignV2Handler(bucket string, pathStyle bool) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "storage.SignV2Handler",
+		Fn: func(r *request.Request) {
+			signV2(r, bucket, pathStyle)
+		},
+	}
+}
+
+// signV2 signs an S3 request using AWS signature version 2 (HMAC-SHA1). It
+// signs the request headers, or the query string if the request is being
+// pre-signed.
+// See: https://docs.aws.amazon.com/AmazonS3/latest/dev/RESTAuthentication.html
+func signV2(r *request.Request, bucket string, pathStyle bool) {
+	if r.Config.Credentials == credentials.AnonymousCredentials {
+		return
+	}
+
+	creds, err := r.Config.Credentials.Get()
+	if err != nil {
+		r.Error = err
+		return
+	}
+
+	req := r.HTTPRequest
+	presign := r.ExpireTime > 0
+
+	date := time.Now().UTC().Format(http.TimeFormat)
+	if presign {
+		date = strconv.FormatInt(time.Now().Add(r.ExpireTime).Unix(), 10)
+	} else {
+		req.Header.Set("Date", date)
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// Canonicalized Amz headers
+	var amz []string
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") {
+			amz = append(amz, k+":"+strings.Join(v, ","))
+		}
+	}
+	sort.Strings(amz)
+
+	// Canonicalized resource (the bucket is always part of it, regardless of
+	// the addressing style)
+	resource := req.URL.EscapedPath()
+	if !pathStyle {
+		resource = "/" + bucket + resource
+	}
+
+	parts := []string{
+		req.Method,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		date,
+	}
+	parts = append(parts, amz...)
+	parts = append(parts, resource)
+
+	mac := hmac.New(sha1.New, []byte(creds.SecretAccessKey))
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	if presign {
+		q := req.URL.Query()
+		q.Set("AWSAccessKeyId", creds.AccessKeyID)
+		q.Set("Expires", date)
+		q.Set("Signature", signature)
+		req.URL.RawQuery = q.Encode()
+		return
+	}
+
+	req.Header.Set("Authorization", "AWS "+creds.AccessKeyID+":"+signature)
+}
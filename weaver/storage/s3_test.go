@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockS3Server is a minimal S3-compatible object store using path-style
+// addressing. It verifies the request signature if the signature version is
+// 'v2', and the signature scheme otherwise.
+func mockS3Server(t *testing.T, signatureVersion string) *httptest.Server {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if signatureVersion == "v2" {
+			if want := "AWS test:" + mockSignV2(r, "test secret"); auth != want {
+				t.Errorf("expected authorization header to be %s, got %s", want, auth)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		} else if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=test/") {
+			t.Errorf("expected a signature version 4 authorization header, got %s", auth)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case "PUT":
+			b, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path] = b
+			w.Header().Set("ETag", `"test"`)
+		case "GET":
+			b, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+				return
+			}
+			w.Write(b)
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func mockSignV2(r *http.Request, secret string) string {
+	var amz []string
+	for k, v := range r.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") {
+			amz = append(amz, k+":"+strings.Join(v, ","))
+		}
+	}
+	sort.Strings(amz)
+	parts := []string{r.Method, r.Header.Get("Content-MD5"), r.Header.Get("Content-Type"), r.Header.Get("Date")}
+	parts = append(parts, amz...)
+	parts = append(parts, r.URL.EscapedPath())
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func expectS3RoundTrip(t *testing.T, s S3) {
+	mockData := []byte("%PDF-1.4")
+	if err := s.Put("test.pdf", mockData); err != nil {
+		t.Fatalf("put returned an unexpected error: %+v", err)
+	}
+
+	got, err := s.Get("test.pdf")
+	if err != nil {
+		t.Fatalf("get returned an unexpected error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, mockData) {
+		t.Errorf("expected stored object to be %s, got %s", mockData, got)
+	}
+
+	if err := s.Delete("test.pdf"); err != nil {
+		t.Fatalf("delete returned an unexpected error: %+v", err)
+	}
+	if _, err := s.Get("test.pdf"); err != ErrNotFound {
+		t.Errorf("expected a not found error after delete, got %+v", err)
+	}
+}
+
+func TestS3_Put_noBucket(t *testing.T) {
+	s := S3{}
+	if err := s.Put("s3-key-123456", []byte{}); err != ErrS3BucketRequired {
+		t.Errorf("expected a bucket required error, got %+v", err)
+	}
+}
+
+func TestS3_URL(t *testing.T) {
+	s := S3{Region: "eu-west-1", Bucket: "s3-bucket-123456", KeyPrefix: "pdf/"}
+	got, err := s.URL("s3-key-123456")
+	if err != nil {
+		t.Fatalf("url returned an unexpected error: %+v", err)
+	}
+	if want := "https://s3-bucket-123456.s3.eu-west-1.amazonaws.com/pdf/s3-key-123456"; got != want {
+		t.Errorf("expected url of object to be %s, got %s", want, got)
+	}
+}
+
+func TestS3_URL_pathStyle(t *testing.T) {
+	s := S3{Bucket: "s3-bucket-123456", Endpoint: "http://minio:9000", PathStyle: true}
+	got, err := s.URL("s3-key-123456")
+	if err != nil {
+		t.Fatalf("url returned an unexpected error: %+v", err)
+	}
+	if want := "http://minio:9000/s3-bucket-123456/s3-key-123456"; got != want {
+		t.Errorf("expected url of object to be %s, got %s", want, got)
+	}
+}
+
+func TestS3_URL_presigned(t *testing.T) {
+	s := S3{Bucket: "s3-bucket-123456", ACL: "private", AccessKey: "test", AccessSecret: "test secret"}
+	got, err := s.URL("s3-key-123456")
+	if err != nil {
+		t.Fatalf("url returned an unexpected error: %+v", err)
+	}
+	if !strings.Contains(got, "X-Amz-Signature=") {
+		t.Errorf("expected url of private object to be pre-signed, got %s", got)
+	}
+
+	s.SignatureVersion = "v2"
+	got, err = s.URL("s3-key-123456")
+	if err != nil {
+		t.Fatalf("url returned an unexpected error: %+v", err)
+	}
+	if !strings.Contains(got, "AWSAccessKeyId=test") || !strings.Contains(got, "Signature=") {
+		t.Errorf("expected url of private object to be pre-signed (v2), got %s", got)
+	}
+}
+
+func TestS3_compatible(t *testing.T) {
+	ts := mockS3Server(t, "v4")
+	defer ts.Close()
+	s := S3{
+		Bucket:       "s3-bucket-123456",
+		AccessKey:    "test",
+		AccessSecret: "test secret",
+		Endpoint:     ts.URL,
+		PathStyle:    true,
+	}
+	expectS3RoundTrip(t, s)
+}
+
+func TestS3_compatible_v2(t *testing.T) {
+	ts := mockS3Server(t, "v2")
+	defer ts.Close()
+	s := S3{
+		Bucket:           "s3-bucket-123456",
+		AccessKey:        "test",
+		AccessSecret:     "test secret",
+		Endpoint:         ts.URL,
+		PathStyle:        true,
+		SignatureVersion: "v2",
+	}
+	expectS3RoundTrip(t, s)
+}
+
+func TestS3_invalidSignatureVersion(t *testing.T) {
+	s := S3{Bucket: "s3-bucket-123456", SignatureVersion: "v3"}
+	if err := s.Put("s3-key-123456", []byte{}); err != ErrS3SignatureVersion {
+		t.Errorf("expected an unsupported signature version error, got %+v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrUnknownBackend should be returned when a storage backend has not
+	// been registered.
+	ErrUnknownBackend = errors.New("unknown storage backend")
+	// ErrNotFound should be returned when an object does not exist.
+	ErrNotFound = errors.New("object not found")
+)
+
+// Storage is a backend for storing the output of conversions.
+type Storage interface {
+	// Put stores the bytes under a key, overwriting any existing object.
+	Put(key string, b []byte) error
+	// Get returns the bytes stored under a key.
+	Get(key string) ([]byte, error)
+	// URL returns a URL which can be used by a client to download the object
+	// stored under a key.
+	URL(key string) (string, error)
+	// Delete removes the object stored under a key.
+	Delete(key string) error
+}
+
+// Registry contains named storage backends. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	backends map[string]Storage
+}
+
+// NewRegistry creates, and returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{backends: make(map[string]Storage)}
+}
+
+// Register adds a storage backend under a name, replacing any existing
+// backend with the same name.
+func (r *Registry) Register(name string, s Storage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backends[name] = s
+}
+
+// Get returns the storage backend registered under a name.
+func (r *Registry) Get(name string) (Storage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.backends[name]
+	if !ok {
+		return nil, ErrUnknownBackend
+	}
+	return s, nil
+}
+
+// Names returns the sorted names of all registered storage backends.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.backends))
+	for name := range r.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	mockStorage := S3{Bucket: "s3-bucket-123456"}
+	r.Register("s3", mockStorage)
+	got, err := r.Get("s3")
+	if err != nil {
+		t.Fatalf("get returned an unexpected error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, mockStorage) {
+		t.Errorf("expected registered storage backend to be %+v, got %+v", mockStorage, got)
+	}
+}
+
+func TestRegistry_unknown(t *testing.T) {
+	r := NewRegistry()
+	if _, err := r.Get("s3"); err != ErrUnknownBackend {
+		t.Errorf("expected an unknown backend error, got %+v", err)
+	}
+}
+
+func TestRegistry_Names(t *testing.T) {
+	r := NewRegistry()
+	r.Register("s3", S3{})
+	r.Register("local", S3{})
+	if got, want := r.Names(), []string{"local", "s3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected registered storage backend names to be %+v, got %+v", want, got)
+	}
+}